@@ -0,0 +1,168 @@
+package aur
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+)
+
+var ErrNoHEAD = errors.New("Repository has neither a HEAD nor a master or single branch")
+
+// Fetches all branches and the HEAD of a remote repository and sets the local references like the remote ones.
+// go-git fails to fetch repositories with a HEAD that no branch points to (https://github.com/go-git/go-git/issues/270),
+// so the advertised references are handled here instead.
+func fetchAllBranches(repository *git.Repository, url string) error {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return err
+	}
+	gitClient, err := client.NewClient(endpoint)
+	if err != nil {
+		return err
+	}
+	session, err := gitClient.NewUploadPackSession(endpoint, nil)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	advertisedReferences, err := session.AdvertisedReferences()
+	if err != nil {
+		return err
+	}
+
+	branches := make(map[plumbing.ReferenceName]plumbing.Hash)
+	for name, hash := range advertisedReferences.References {
+		if referenceName := plumbing.ReferenceName(name); referenceName.IsBranch() {
+			branches[referenceName] = hash
+		}
+	}
+
+	head, err := resolveRemoteHEAD(advertisedReferences, branches)
+	if err != nil {
+		return err
+	}
+
+	wantedHashes := make([]plumbing.Hash, 0, len(branches)+1)
+	for _, hash := range branches {
+		wantedHashes = append(wantedHashes, hash)
+	}
+	if head.Type() == plumbing.HashReference {
+		wantedHashes = append(wantedHashes, head.Hash())
+	}
+	if err := fetchPack(repository, session, advertisedReferences, wantedHashes); err != nil {
+		return err
+	}
+
+	for name, hash := range branches {
+		if err := repository.Storer.SetReference(plumbing.NewHashReference(name, hash)); err != nil {
+			return err
+		}
+	}
+	return repository.Storer.SetReference(head)
+}
+
+// Returns the HEAD of a remote repository: The branch it points to, a branch at the same commit or a detached commit.
+// Falls back to master or the only branch if the remote doesn't advertise a HEAD.
+func resolveRemoteHEAD(advertisedReferences *packp.AdvRefs, branches map[plumbing.ReferenceName]plumbing.Hash) (*plumbing.Reference, error) {
+	for _, symref := range advertisedReferences.Capabilities.Get(capability.SymRef) {
+		nameTarget := strings.SplitN(symref, ":", 2)
+		if len(nameTarget) != 2 || plumbing.ReferenceName(nameTarget[0]) != plumbing.HEAD {
+			continue
+		}
+		if _, ok := branches[plumbing.ReferenceName(nameTarget[1])]; ok {
+			return plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.ReferenceName(nameTarget[1])), nil
+		}
+	}
+
+	if advertisedReferences.Head != nil {
+		if hash, ok := branches[plumbing.Master]; ok && hash == *advertisedReferences.Head {
+			return plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master), nil
+		}
+		branchNames := make([]string, 0, len(branches))
+		for name := range branches {
+			branchNames = append(branchNames, name.String())
+		}
+		sort.Strings(branchNames)
+		for _, name := range branchNames {
+			if branches[plumbing.ReferenceName(name)] == *advertisedReferences.Head {
+				return plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.ReferenceName(name)), nil
+			}
+		}
+		return plumbing.NewHashReference(plumbing.HEAD, *advertisedReferences.Head), nil
+	}
+
+	if _, ok := branches[plumbing.Master]; ok {
+		return plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master), nil
+	}
+	if len(branches) == 1 {
+		for name := range branches {
+			return plumbing.NewSymbolicReference(plumbing.HEAD, name), nil
+		}
+	}
+	return nil, ErrNoHEAD
+}
+
+// Fetches the objects of the wanted commits that are missing locally, like go-git does
+func fetchPack(repository *git.Repository, session transport.UploadPackSession, advertisedReferences *packp.AdvRefs, wantedHashes []plumbing.Hash) error {
+	request := packp.NewUploadPackRequestFromCapabilities(advertisedReferences.Capabilities)
+	if advertisedReferences.Capabilities.Supports(capability.NoProgress) {
+		if err := request.Capabilities.Set(capability.NoProgress); err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[plumbing.Hash]struct{})
+	for _, hash := range wantedHashes {
+		if _, ok := seen[hash]; ok {
+			continue
+		}
+		seen[hash] = struct{}{}
+		if repository.Storer.HasEncodedObject(hash) != nil {
+			request.Wants = append(request.Wants, hash)
+		}
+	}
+	if len(request.Wants) == 0 {
+		return nil
+	}
+
+	localReferences, err := repository.Storer.IterReferences()
+	if err != nil {
+		return err
+	}
+	err = localReferences.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() == plumbing.HashReference && repository.Storer.HasEncodedObject(reference.Hash()) == nil {
+			request.Haves = append(request.Haves, reference.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := session.UploadPack(context.Background(), request)
+	if err != nil {
+		return err
+	}
+	defer response.Close()
+
+	var reader io.Reader = response
+	switch {
+	case request.Capabilities.Supports(capability.Sideband):
+		reader = sideband.NewDemuxer(sideband.Sideband, response)
+	case request.Capabilities.Supports(capability.Sideband64k):
+		reader = sideband.NewDemuxer(sideband.Sideband64k, response)
+	}
+	return packfile.UpdateObjectStorage(repository.Storer, reader)
+}
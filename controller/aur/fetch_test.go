@@ -0,0 +1,88 @@
+package aur
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+)
+
+func TestResolveRemoteHEAD(t *testing.T) {
+	hashA := plumbing.NewHash("1111111111111111111111111111111111111111")
+	hashB := plumbing.NewHash("2222222222222222222222222222222222222222")
+	hashC := plumbing.NewHash("3333333333333333333333333333333333333333")
+	mainBranch := plumbing.NewBranchReferenceName("main")
+
+	tests := []struct {
+		name     string
+		symref   string
+		head     *plumbing.Hash
+		branches map[plumbing.ReferenceName]plumbing.Hash
+		expected *plumbing.Reference
+		err      error
+	}{
+		{"symref HEAD", "HEAD:refs/heads/main", &hashB,
+			map[plumbing.ReferenceName]plumbing.Hash{plumbing.Master: hashA, mainBranch: hashB},
+			plumbing.NewSymbolicReference(plumbing.HEAD, mainBranch), nil},
+		{"symref HEAD to a branch at the same commit as master", "HEAD:refs/heads/main", &hashA,
+			map[plumbing.ReferenceName]plumbing.Hash{plumbing.Master: hashA, mainBranch: hashA},
+			plumbing.NewSymbolicReference(plumbing.HEAD, mainBranch), nil},
+		{"symref HEAD to a missing branch", "HEAD:refs/heads/gone", &hashA,
+			map[plumbing.ReferenceName]plumbing.Hash{plumbing.Master: hashA},
+			plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master), nil},
+		{"detached HEAD", "", &hashC,
+			map[plumbing.ReferenceName]plumbing.Hash{plumbing.Master: hashA, mainBranch: hashB},
+			plumbing.NewHashReference(plumbing.HEAD, hashC), nil},
+		{"detached HEAD without branches", "", &hashC,
+			map[plumbing.ReferenceName]plumbing.Hash{},
+			plumbing.NewHashReference(plumbing.HEAD, hashC), nil},
+		{"default branch other than master", "", &hashB,
+			map[plumbing.ReferenceName]plumbing.Hash{plumbing.Master: hashA, mainBranch: hashB},
+			plumbing.NewSymbolicReference(plumbing.HEAD, mainBranch), nil},
+		{"master and another branch at HEAD", "", &hashA,
+			map[plumbing.ReferenceName]plumbing.Hash{plumbing.Master: hashA, mainBranch: hashA},
+			plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master), nil},
+		{"master only", "", nil,
+			map[plumbing.ReferenceName]plumbing.Hash{plumbing.Master: hashA},
+			plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master), nil},
+		{"master without HEAD", "", nil,
+			map[plumbing.ReferenceName]plumbing.Hash{plumbing.Master: hashA, mainBranch: hashB},
+			plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master), nil},
+		{"single branch", "", nil,
+			map[plumbing.ReferenceName]plumbing.Hash{mainBranch: hashB},
+			plumbing.NewSymbolicReference(plumbing.HEAD, mainBranch), nil},
+		{"multiple branches without HEAD and master", "", nil,
+			map[plumbing.ReferenceName]plumbing.Hash{mainBranch: hashB, plumbing.NewBranchReferenceName("dev"): hashC},
+			nil, ErrNoHEAD},
+		{"no refs", "", nil,
+			map[plumbing.ReferenceName]plumbing.Hash{},
+			nil, ErrNoHEAD},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			advertisedReferences := packp.NewAdvRefs()
+			if len(test.symref) > 0 {
+				if err := advertisedReferences.Capabilities.Add(capability.SymRef, test.symref); err != nil {
+					t.Fatal(err)
+				}
+			}
+			advertisedReferences.Head = test.head
+
+			head, err := resolveRemoteHEAD(advertisedReferences, test.branches)
+			if err != test.err {
+				t.Fatalf("resolveRemoteHEAD() error = %v, want %v", err, test.err)
+			}
+			if test.expected == nil {
+				if head != nil {
+					t.Errorf("resolveRemoteHEAD() = %s, want none", head)
+				}
+				return
+			}
+			if head == nil || head.String() != test.expected.String() {
+				t.Errorf("resolveRemoteHEAD() = %s, want %s", head, test.expected)
+			}
+		})
+	}
+}
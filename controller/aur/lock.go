@@ -0,0 +1,38 @@
+package aur
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Lock files of the repositories are stored in this directory of the git storage
+const LOCK_DIR = ".locks"
+
+// Locks the repository of a package base with flock(2), which works across goroutines and processes.
+// Fetches and maintenance need an exclusive lock, reads a shared one. Blocks until the lock is acquired.
+// Locks aren't reentrant, functions holding one must not call others that lock the same repository.
+func lockRepository(gitStoragePath *string, packageBase string, exclusive bool) (func(), error) {
+	lockDir := filepath.Join(*gitStoragePath, LOCK_DIR)
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(lockDir, packageBase+".lock"), os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
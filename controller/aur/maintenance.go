@@ -0,0 +1,234 @@
+package aur
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// Returns the package bases of all repositories in the git storage
+func ListRepositories(gitStoragePath *string) ([]string, error) {
+	entries, err := ioutil.ReadDir(*gitStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var packageBases []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasSuffix(entry.Name(), ".git") {
+			packageBases = append(packageBases, strings.TrimSuffix(entry.Name(), ".git"))
+		}
+	}
+	return packageBases, nil
+}
+
+// Deletes the repository of a package base, f.e. after it was removed from the AUR
+func DeleteRepository(gitStoragePath *string, packageBase string) error {
+	unlock, err := lockRepository(gitStoragePath, packageBase, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return os.RemoveAll(getRepositoryPath(gitStoragePath, packageBase))
+}
+
+// Removes unreachable objects, packs all others into a single pack and verifies them, like git gc and git fsck.
+// Returns the stats of the repository after the maintenance, as far as they could be determined.
+func MaintainRepository(gitStoragePath *string, packageBase string) (model.RepositoryStats, error) {
+	stats := model.RepositoryStats{
+		PackageBase: packageBase,
+	}
+
+	unlock, err := lockRepository(gitStoragePath, packageBase, true)
+	if err != nil {
+		return stats, err
+	}
+	defer unlock()
+
+	repositoryPath := getRepositoryPath(gitStoragePath, packageBase)
+	repository, err := git.PlainOpen(repositoryPath)
+	if err != nil {
+		return stats, err
+	}
+
+	err = repackRepository(repository)
+	if err == nil {
+		// The storage still knows the packs that were replaced
+		repository, err = git.PlainOpen(repositoryPath)
+	}
+	if err == nil {
+		stats.Commits, err = verifyRepository(repository)
+	}
+
+	if packedObjectStorer, ok := repository.Storer.(storer.PackedObjectStorer); ok {
+		if packs, packsErr := packedObjectStorer.ObjectPacks(); packsErr == nil {
+			stats.Packs = len(packs)
+		}
+	}
+	if size, sizeErr := getDirectorySize(repositoryPath); sizeErr == nil {
+		stats.SizeBytes = size
+	}
+
+	return stats, err
+}
+
+func repackRepository(repository *git.Repository) error {
+	looseObjectStorer, ok := repository.Storer.(storer.LooseObjectStorer)
+	if !ok {
+		return git.ErrLooseObjectsNotSupported
+	}
+
+	// Nobody else accesses the repository while we hold the lock, so there is no need for a grace period
+	if err := repository.Prune(git.PruneOptions{Handler: repository.DeleteObject}); err != nil {
+		return err
+	}
+	if err := repository.RepackObjects(&git.RepackConfig{}); err != nil {
+		return err
+	}
+
+	// The remaining loose objects are reachable and part of the new pack now
+	var looseHashes []plumbing.Hash
+	if err := looseObjectStorer.ForEachObjectHash(func(hash plumbing.Hash) error {
+		looseHashes = append(looseHashes, hash)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, hash := range looseHashes {
+		if err := looseObjectStorer.DeleteLooseObject(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads all commits and trees reachable from the branches and HEAD, which might be detached, and checks that
+// the content of all files matches their hash. Returns the number of commits.
+func verifyRepository(repository *git.Repository) (int, error) {
+	references, err := repository.Storer.IterReferences()
+	if err != nil {
+		return 0, err
+	}
+
+	verifiedCommits := make(map[plumbing.Hash]struct{})
+	verifiedBlobs := make(map[plumbing.Hash]struct{})
+	err = references.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() != plumbing.HashReference || (!reference.Name().IsBranch() && reference.Name() != plumbing.HEAD) {
+			return nil
+		}
+		commitIter, err := repository.Log(&git.LogOptions{From: reference.Hash()})
+		if err != nil {
+			return fmt.Errorf("Reference %s: %s", reference.Name(), err)
+		}
+		return commitIter.ForEach(func(commit *object.Commit) error {
+			if _, ok := verifiedCommits[commit.Hash]; ok {
+				return nil
+			}
+			verifiedCommits[commit.Hash] = struct{}{}
+			return verifyCommit(repository, commit, verifiedBlobs)
+		})
+	})
+	return len(verifiedCommits), err
+}
+
+func verifyCommit(repository *git.Repository, commit *object.Commit, verifiedBlobs map[plumbing.Hash]struct{}) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("Commit %s: %s", commit.Hash, err)
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Commit %s: %s", commit.Hash, err)
+		}
+		if entry.Mode == filemode.Dir || entry.Mode == filemode.Submodule {
+			continue
+		}
+		if _, ok := verifiedBlobs[entry.Hash]; ok {
+			continue
+		}
+		if err := verifyBlob(repository, entry.Hash); err != nil {
+			return fmt.Errorf("Commit %s, file %s: %s", commit.Hash, name, err)
+		}
+		verifiedBlobs[entry.Hash] = struct{}{}
+	}
+}
+
+func verifyBlob(repository *git.Repository, hash plumbing.Hash) error {
+	blob, err := repository.BlobObject(hash)
+	if err != nil {
+		return err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	hasher := plumbing.NewHasher(plumbing.BlobObject, blob.Size)
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return err
+	}
+	if hasher.Sum() != hash {
+		return errors.New("Content doesn't match hash")
+	}
+	return nil
+}
+
+func getDirectorySize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// Removes cached tarballs that weren't used for the given time, they are generated again on demand.
+// Returns the number of removed tarballs.
+func CleanTARCache(gitStoragePath *string, maxAge time.Duration) (int, error) {
+	cacheDir := filepath.Join(*gitStoragePath, TAR_CACHE_DIR)
+	entries, err := ioutil.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || time.Since(entry.ModTime()) < maxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(cacheDir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
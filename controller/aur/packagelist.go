@@ -0,0 +1,101 @@
+package aur
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Metadata of all AUR packages, updated every few minutes
+const DEFAULT_PACKAGE_LIST_URL = "https://aur.archlinux.org/packages-meta-v1.json.gz"
+
+// A package of the AUR package list. Other fields of the list are ignored.
+type PackageListEntry struct {
+	Name          string
+	PackageBase   string
+	PackageBaseID int64
+}
+
+// Returns all packages of the AUR, f.e. from the AUR itself or a local file
+type PackageListSource interface {
+	GetPackageList() ([]PackageListEntry, error)
+}
+
+// Reads the package list from an URL
+type HTTPPackageListSource struct {
+	URL    string
+	Client *http.Client
+}
+
+// Reads the package list from a file, f.e. a fixture or a copy mirrored by other means
+type FilePackageListSource struct {
+	Path string
+}
+
+// Returns a source of the location, which is either an http(s) URL or a file path.
+// The list may be a JSON array (packages-meta-v1.json) or gzip compressed.
+func NewPackageListSource(location string) PackageListSource {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return &HTTPPackageListSource{
+			URL:    location,
+			Client: &http.Client{Timeout: 5 * time.Minute},
+		}
+	}
+	return &FilePackageListSource{
+		Path: location,
+	}
+}
+
+func (s *HTTPPackageListSource) GetPackageList() ([]PackageListEntry, error) {
+	resp, err := s.Client.Get(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %s of %s", resp.Status, s.URL)
+	}
+	return ReadPackageList(resp.Body)
+}
+
+func (s *FilePackageListSource) GetPackageList() ([]PackageListEntry, error) {
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadPackageList(file)
+}
+
+// Reads a package list, a JSON array of packages that may be gzip compressed
+func ReadPackageList(reader io.Reader) ([]PackageListEntry, error) {
+	bufferedReader := bufio.NewReader(reader)
+	magic, err := bufferedReader.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var listReader io.Reader = bufferedReader
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(bufferedReader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		listReader = gzipReader
+	}
+
+	var entries []PackageListEntry
+	if err := json.NewDecoder(listReader).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
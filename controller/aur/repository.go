@@ -2,21 +2,19 @@ package aur
 
 import (
 	"archive/tar"
-	"bytes"
-	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
-	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/hashworks/aur-ci/controller/model"
 )
 
@@ -24,127 +22,214 @@ func getRepositoryPath(gitStoragePath *string, packageBase string) string {
 	return filepath.Join(*gitStoragePath, packageBase+".git")
 }
 
-func GetCommitTAR(gitStoragePath *string, packageBase string, commitHash string) ([]byte, error) {
-	if packageBase == "hiawatha-monitor" {
-		// https://github.com/go-git/go-git/issues/302
-		return []byte{}, errors.New("Ignoring hiawatha-monitor due to issues with go-git")
+// Generated tarballs are cached in this directory of the git storage, by package base and commit hash.
+// The package base is part of the key since it names the directory of the tarball, and repositories
+// of different package bases may share commits.
+const TAR_CACHE_DIR = ".tar-cache"
+
+func getTARCachePath(gitStoragePath *string, packageBase string, commitHash string) string {
+	return filepath.Join(*gitStoragePath, TAR_CACHE_DIR, packageBase+"-"+commitHash+".tar")
+}
+
+// Returns a tarball of the files of a commit, in a directory named like the package base.
+// Tarballs are generated once and cached on disk, the caller has to close the reader.
+func GetCommitTAR(gitStoragePath *string, packageBase string, commitHash string) (io.ReadCloser, error) {
+	cachePath := getTARCachePath(gitStoragePath, packageBase, commitHash)
+	if file, err := os.Open(cachePath); err == nil {
+		// The modification time tells the maintenance which tarballs are still used
+		now := time.Now()
+		os.Chtimes(cachePath, now, now)
+		return file, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
 	}
 
-	repositoryPath := getRepositoryPath(gitStoragePath, packageBase)
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return nil, err
+	}
+	// Concurrent requests may generate the same tarball, the rename makes sure nobody reads a partial one
+	tempFile, err := os.CreateTemp(filepath.Dir(cachePath), filepath.Base(cachePath)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempFile.Name())
 
-	memoryRepository, err := git.Clone(memory.NewStorage(), memfs.New(), &git.CloneOptions{
-		URL: repositoryPath,
-	})
+	err = WriteCommitTAR(gitStoragePath, packageBase, commitHash, tempFile)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tempFile.Name(), cachePath); err != nil {
+		return nil, err
+	}
 
-	worktree, err := memoryRepository.Worktree()
+	return os.Open(cachePath)
+}
+
+// Writes a tarball of the files of a commit, in a directory named like the package base.
+// Walks the tree object of the commit, so nested directories, executable bits and symlinks are kept.
+// Submodules are skipped. All entries have the commit time, so tarballs of a commit are identical.
+func WriteCommitTAR(gitStoragePath *string, packageBase string, commitHash string, writer io.Writer) error {
+	unlock, err := lockRepository(gitStoragePath, packageBase, false)
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
+	defer unlock()
 
-	err = worktree.Checkout(&git.CheckoutOptions{
-		Hash: plumbing.NewHash(commitHash),
-	})
+	repository, err := git.PlainOpen(getRepositoryPath(gitStoragePath, packageBase))
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
 
-	files, err := worktree.Filesystem.ReadDir("/")
+	commit, err := repository.CommitObject(plumbing.NewHash(commitHash))
 	if err != nil {
-		return []byte{}, err
+		return err
 	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	tarWriter := tar.NewWriter(writer)
+	modTime := commit.Committer.When
 
-	var tarBuffer bytes.Buffer
-	tarWriter := tar.NewWriter(&tarBuffer)
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     packageBase + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
 
-	for _, fileInfo := range files {
-		if fileInfo.IsDir() {
-			continue
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
 		}
 
-		name := filepath.Join(packageBase, fileInfo.Name())
+		header := tar.Header{
+			Name:    path.Join(packageBase, name),
+			ModTime: modTime,
+		}
 
-		if fileInfo.Mode()&fs.ModeSymlink != 0 {
-			linkName, err := worktree.Filesystem.Readlink(fileInfo.Name())
-			if err != nil {
-				return []byte{}, err
+		switch entry.Mode {
+		case filemode.Dir:
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			header.Mode = 0755
+			if err := tarWriter.WriteHeader(&header); err != nil {
+				return err
 			}
-			header, err := tar.FileInfoHeader(fileInfo, linkName)
+		case filemode.Regular, filemode.Deprecated, filemode.Executable:
+			blob, err := repository.BlobObject(entry.Hash)
 			if err != nil {
-				return []byte{}, err
+				return err
 			}
-			header.Name = name
-			if err := tarWriter.WriteHeader(header); err != nil {
-				return []byte{}, err
+			header.Typeflag = tar.TypeReg
+			header.Size = blob.Size
+			header.Mode = 0644
+			if entry.Mode == filemode.Executable {
+				header.Mode = 0755
 			}
-		} else {
-			file, err := worktree.Filesystem.Open(fileInfo.Name())
-			if err != nil {
-				return []byte{}, err
+			if err := tarWriter.WriteHeader(&header); err != nil {
+				return err
 			}
-			data := make([]byte, fileInfo.Size())
-			_, err = file.Read(data)
-			if err != nil && err != io.EOF {
-				return []byte{}, err
+			if err := copyBlob(tarWriter, blob); err != nil {
+				return err
 			}
-
-			header, err := tar.FileInfoHeader(fileInfo, "")
+		case filemode.Symlink:
+			blob, err := repository.BlobObject(entry.Hash)
 			if err != nil {
-				return []byte{}, err
+				return err
 			}
-			header.Name = name
-
-			if err := tarWriter.WriteHeader(header); err != nil {
-				return []byte{}, err
+			var linkName strings.Builder
+			if err := copyBlob(&linkName, blob); err != nil {
+				return err
 			}
-			if _, err := tarWriter.Write(data); err != nil {
-				return []byte{}, err
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = linkName.String()
+			header.Mode = 0777
+			if err := tarWriter.WriteHeader(&header); err != nil {
+				return err
 			}
 		}
 	}
 
-	if err := tarWriter.Close(); err != nil {
-		return []byte{}, err
-	}
+	return tarWriter.Close()
+}
 
-	return tarBuffer.Bytes(), nil
+func copyBlob(writer io.Writer, blob *object.Blob) error {
+	reader, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(writer, reader)
+	return err
 }
 
-func CloneOrFetchRepository(gitStoragePath *string, packageBase string) (*git.Repository, error) {
+// Clones or fetches the repository of a package base. All branches are fetched and HEAD is set like the one of the AUR,
+// which might be another branch than master or a detached commit.
+func CloneOrFetchRepository(gitStoragePath *string, packageBase string) error {
+	unlock, err := lockRepository(gitStoragePath, packageBase, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	repositoryPath := getRepositoryPath(gitStoragePath, packageBase)
 
-	_, err := os.Stat(repositoryPath)
+	repository, err := git.PlainOpen(repositoryPath)
+	if err == git.ErrRepositoryNotExists {
+		repository, err = git.PlainInit(repositoryPath, true)
+	}
+	if err != nil {
+		return err
+	}
 
-	var repository *git.Repository
+	return fetchAllBranches(repository, fmt.Sprintf("https://aur.archlinux.org/%s.git", packageBase))
+}
 
-	if os.IsNotExist(err) {
-		repository, err = git.PlainClone(repositoryPath, true, &git.CloneOptions{
-			URL:        fmt.Sprintf("https://aur.archlinux.org/%s.git", packageBase),
-			Progress:   nil,
-			RemoteName: "origin",
-		})
-		if err != nil {
-			return repository, err
-		}
-	} else {
-		repository, err = git.PlainOpen(repositoryPath)
-		if err != nil {
-			return repository, err
-		}
-		// git fetch origin +master:master
-		err = repository.Fetch(&git.FetchOptions{
-			Progress:   nil,
-			RemoteName: "origin",
-			RefSpecs:   []config.RefSpec{"+refs/heads/master:refs/heads/master"},
-		})
-		if err != nil && err != git.NoErrAlreadyUpToDate {
-			return repository, err
-		}
+// Returns the commit hash HEAD of the repository of a package base points to
+func GetHEADHash(gitStoragePath *string, packageBase string) (string, error) {
+	unlock, err := lockRepository(gitStoragePath, packageBase, false)
+	if err != nil {
+		return "", err
 	}
+	defer unlock()
 
-	return repository, nil
+	repository, err := git.PlainOpen(getRepositoryPath(gitStoragePath, packageBase))
+	if err != nil {
+		return "", err
+	}
+	head, err := repository.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
 }
 
-func GetCommitsUntilHash(repository *git.Repository, packageBaseId int64, hash string) ([]model.Commit, error) {
+// Returns the commits of the repository of a package base, from HEAD until the commit of the hash, newest first
+func GetCommitsUntilHash(gitStoragePath *string, packageBase string, packageBaseId int64, hash string) ([]model.Commit, error) {
+	unlock, err := lockRepository(gitStoragePath, packageBase, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	repository, err := git.PlainOpen(getRepositoryPath(gitStoragePath, packageBase))
+	if err != nil {
+		return nil, err
+	}
+
 	var commits []model.Commit
 	commitIter, err := repository.Log(&git.LogOptions{})
 
@@ -0,0 +1,202 @@
+package aur
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// Architecture of the workers, used to select architecture specific values like depends_x86_64
+const BUILD_ARCHITECTURE = "x86_64"
+
+// Values of a section of a .SRCINFO, by key. Architecture specific keys are stored as they are, f.e. depends_x86_64.
+type srcinfoSection map[string][]string
+
+// Parsed .SRCINFO, see https://wiki.archlinux.org/title/.SRCINFO
+type SRCINFO struct {
+	PackageBase  string
+	PackageNames []string
+	base         srcinfoSection
+	packages     map[string]srcinfoSection
+}
+
+func ParseSRCINFO(reader io.Reader) (*SRCINFO, error) {
+	srcinfo := SRCINFO{
+		packages: make(map[string]srcinfoSection),
+	}
+	var section srcinfoSection
+
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keyValue := strings.SplitN(line, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("Invalid .SRCINFO line %d: %s", lineNumber, line)
+		}
+		key := strings.TrimSpace(keyValue[0])
+		value := strings.TrimSpace(keyValue[1])
+
+		switch key {
+		case "pkgbase":
+			if section != nil {
+				return nil, fmt.Errorf("Duplicate pkgbase in .SRCINFO line %d", lineNumber)
+			}
+			srcinfo.PackageBase = value
+			srcinfo.base = make(srcinfoSection)
+			section = srcinfo.base
+		case "pkgname":
+			if section == nil {
+				return nil, fmt.Errorf("pkgname before pkgbase in .SRCINFO line %d", lineNumber)
+			}
+			srcinfo.PackageNames = append(srcinfo.PackageNames, value)
+			section = make(srcinfoSection)
+			srcinfo.packages[value] = section
+		default:
+			if section == nil {
+				return nil, fmt.Errorf("Value before pkgbase in .SRCINFO line %d", lineNumber)
+			}
+			// An empty value in a package section clears the value of the package base, f.e. "depends = "
+			if len(value) == 0 {
+				section[key] = []string{}
+			} else {
+				section[key] = append(section[key], value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if srcinfo.base == nil {
+		return nil, errors.New("Missing pkgbase in .SRCINFO")
+	}
+	return &srcinfo, nil
+}
+
+// Returns the values of a key for an architecture, f.e. depends and depends_x86_64
+func (section srcinfoSection) get(key string, architecture string) []string {
+	values := append([]string{}, section[key]...)
+	return append(values, section[key+"_"+architecture]...)
+}
+
+// Returns the values of the package base, f.e. makedepends
+func (s *SRCINFO) GetBaseValues(key string, architecture string) []string {
+	return s.base.get(key, architecture)
+}
+
+// Returns the values of a package. Keys that the package doesn't override are inherited from the package base.
+func (s *SRCINFO) GetPackageValues(packageName string, key string, architecture string) []string {
+	section, ok := s.packages[packageName]
+	if !ok {
+		return nil
+	}
+
+	var values []string
+	for _, sectionKey := range []string{key, key + "_" + architecture} {
+		if sectionValues, ok := section[sectionKey]; ok {
+			values = append(values, sectionValues...)
+		} else {
+			values = append(values, s.base[sectionKey]...)
+		}
+	}
+	return values
+}
+
+// Returns the values of all packages, without duplicates
+func (s *SRCINFO) GetAllPackageValues(key string, architecture string) []string {
+	var values []string
+	for _, packageName := range s.PackageNames {
+		values = append(values, s.GetPackageValues(packageName, key, architecture)...)
+	}
+	return unique(values)
+}
+
+// Returns [epoch:]pkgver-pkgrel
+func (s *SRCINFO) GetVersion() string {
+	version := strings.Join(s.base["pkgver"], "") + "-" + strings.Join(s.base["pkgrel"], "")
+	if epoch := strings.Join(s.base["epoch"], ""); len(epoch) > 0 && epoch != "0" {
+		version = epoch + ":" + version
+	}
+	return version
+}
+
+// Returns true if a source of the package base is checked out from a VCS
+func (s *SRCINFO) HasVCSSource() bool {
+	for _, source := range s.GetBaseValues("source", BUILD_ARCHITECTURE) {
+		if IsVCSSource(source) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SRCINFO) NewCommitSRCINFO(commitId int64, content string) model.CommitSRCINFO {
+	return model.CommitSRCINFO{
+		CommitId:      commitId,
+		PackageBase:   s.PackageBase,
+		PackageNames:  s.PackageNames,
+		Version:       s.GetVersion(),
+		Architectures: s.GetBaseValues("arch", BUILD_ARCHITECTURE),
+		Depends:       s.GetAllPackageValues("depends", BUILD_ARCHITECTURE),
+		MakeDepends:   unique(s.GetBaseValues("makedepends", BUILD_ARCHITECTURE)),
+		CheckDepends:  unique(s.GetBaseValues("checkdepends", BUILD_ARCHITECTURE)),
+		Provides:      s.GetAllPackageValues("provides", BUILD_ARCHITECTURE),
+		VCS:           s.HasVCSSource(),
+		Content:       content,
+	}
+}
+
+// Reads the .SRCINFO of a commit from the repository of a package base
+func ReadSRCINFO(gitStoragePath *string, packageBase string, commitHash string) (*SRCINFO, string, error) {
+	unlock, err := lockRepository(gitStoragePath, packageBase, false)
+	if err != nil {
+		return nil, "", err
+	}
+	defer unlock()
+
+	repository, err := git.PlainOpen(getRepositoryPath(gitStoragePath, packageBase))
+	if err != nil {
+		return nil, "", err
+	}
+
+	commit, err := repository.CommitObject(plumbing.NewHash(commitHash))
+	if err != nil {
+		return nil, "", err
+	}
+
+	file, err := commit.File(".SRCINFO")
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return nil, "", err
+	}
+
+	srcinfo, err := ParseSRCINFO(strings.NewReader(content))
+	return srcinfo, content, err
+}
+
+func unique(values []string) []string {
+	seen := make(map[string]struct{})
+	uniqueValues := make([]string, 0, len(values))
+	for _, value := range values {
+		if _, ok := seen[value]; !ok {
+			seen[value] = struct{}{}
+			uniqueValues = append(uniqueValues, value)
+		}
+	}
+	return uniqueValues
+}
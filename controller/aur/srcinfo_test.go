@@ -0,0 +1,121 @@
+package aur
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadTestSRCINFO(t *testing.T, name string) *SRCINFO {
+	file, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	srcinfo, err := ParseSRCINFO(file)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %s", name, err)
+	}
+	return srcinfo
+}
+
+func TestParseSRCINFO(t *testing.T) {
+	srcinfo := loadTestSRCINFO(t, "split.SRCINFO")
+
+	if srcinfo.PackageBase != "foo-split" {
+		t.Errorf("PackageBase = %q, want foo-split", srcinfo.PackageBase)
+	}
+	if expected := []string{"foo", "foo-docs", "libfoo"}; !reflect.DeepEqual(srcinfo.PackageNames, expected) {
+		t.Errorf("PackageNames = %v, want %v", srcinfo.PackageNames, expected)
+	}
+	if version := srcinfo.GetVersion(); version != "1:1.2.3-2" {
+		t.Errorf("GetVersion() = %q, want 1:1.2.3-2", version)
+	}
+	if srcinfo.HasVCSSource() {
+		t.Error("HasVCSSource() = true, want false")
+	}
+}
+
+func TestSRCINFOGetPackageValues(t *testing.T) {
+	srcinfo := loadTestSRCINFO(t, "split.SRCINFO")
+
+	tests := []struct {
+		packageName  string
+		key          string
+		architecture string
+		expected     []string
+	}{
+		// Overridden, the architecture specific values are still inherited
+		{"foo", "depends", "x86_64", []string{"glibc", "zlib", "lib32-glibc"}},
+		{"foo", "depends", "aarch64", []string{"glibc", "zlib", "libatomic"}},
+		// Cleared by empty values
+		{"foo-docs", "depends", "x86_64", nil},
+		{"foo-docs", "depends", "aarch64", []string{"libatomic"}},
+		{"foo-docs", "arch", "x86_64", []string{"any"}},
+		// Inherited
+		{"libfoo", "depends", "x86_64", []string{"glibc", "lib32-glibc"}},
+		{"libfoo", "provides", "x86_64", []string{"libfoo.so=1-64"}},
+		{"foo", "provides", "x86_64", []string{"foo-base"}},
+		{"missing", "depends", "x86_64", nil},
+	}
+
+	for _, test := range tests {
+		values := srcinfo.GetPackageValues(test.packageName, test.key, test.architecture)
+		if !reflect.DeepEqual(values, test.expected) {
+			t.Errorf("GetPackageValues(%s, %s, %s) = %v, want %v", test.packageName, test.key, test.architecture, values, test.expected)
+		}
+	}
+
+	if values := srcinfo.GetBaseValues("makedepends", "aarch64"); !reflect.DeepEqual(values, []string{"cmake", "arm-none-eabi-gcc"}) {
+		t.Errorf("GetBaseValues(makedepends, aarch64) = %v, want [cmake arm-none-eabi-gcc]", values)
+	}
+}
+
+func TestSRCINFONewCommitSRCINFO(t *testing.T) {
+	commitSRCINFO := loadTestSRCINFO(t, "split.SRCINFO").NewCommitSRCINFO(1, "content")
+
+	tests := []struct {
+		name     string
+		values   []string
+		expected []string
+	}{
+		{"Depends", commitSRCINFO.Depends, []string{"glibc", "zlib", "lib32-glibc"}},
+		{"MakeDepends", commitSRCINFO.MakeDepends, []string{"cmake", "nasm"}},
+		{"CheckDepends", commitSRCINFO.CheckDepends, []string{"python-pytest"}},
+		{"Provides", commitSRCINFO.Provides, []string{"foo-base", "libfoo.so=1-64"}},
+		{"Architectures", commitSRCINFO.Architectures, []string{"x86_64", "aarch64"}},
+		{"GetBuildDependencies", commitSRCINFO.GetBuildDependencies(),
+			[]string{"glibc", "zlib", "lib32-glibc", "cmake", "nasm", "python-pytest"}},
+	}
+
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.values, test.expected) {
+			t.Errorf("%s = %v, want %v", test.name, test.values, test.expected)
+		}
+	}
+	if commitSRCINFO.Version != "1:1.2.3-2" || commitSRCINFO.PackageBase != "foo-split" || commitSRCINFO.VCS {
+		t.Errorf("Version = %q, PackageBase = %q, VCS = %t; want 1:1.2.3-2 of foo-split without VCS",
+			commitSRCINFO.Version, commitSRCINFO.PackageBase, commitSRCINFO.VCS)
+	}
+}
+
+func TestParseSRCINFOErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"missing pkgbase", ""},
+		{"value before pkgbase", "pkgver = 1\npkgbase = foo\n"},
+		{"pkgname before pkgbase", "pkgname = foo\npkgbase = foo\n"},
+		{"duplicate pkgbase", "pkgbase = foo\npkgbase = bar\n"},
+		{"invalid line", "pkgbase = foo\n\tpkgver\n"},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseSRCINFO(strings.NewReader(test.content)); err == nil {
+			t.Errorf("%s: ParseSRCINFO() succeeded, want an error", test.name)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package aur
+
+import "strings"
+
+// Package bases of VCS packages are named like foo-git by convention
+var VCS_SUFFIXES = []string{"-git", "-svn", "-hg", "-bzr", "-darcs", "-fossil", "-cvs"}
+
+// Protocols of sources makepkg checks out from a VCS, f.e. git+https://… or git://…
+var VCS_PROTOCOLS = []string{"git", "svn", "hg", "bzr", "fossil"}
+
+// Returns true if the package base is named like a VCS package
+func IsVCSPackageBaseName(packageBase string) bool {
+	for _, suffix := range VCS_SUFFIXES {
+		if strings.HasSuffix(packageBase, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if makepkg checks out the source from a VCS. Sources look like [name::][vcs+]url[#fragment].
+func IsVCSSource(source string) bool {
+	if separator := strings.Index(source, "::"); separator >= 0 {
+		source = source[separator+2:]
+	}
+	for _, protocol := range VCS_PROTOCOLS {
+		if strings.HasPrefix(source, protocol+"+") || strings.HasPrefix(source, protocol+"://") {
+			return true
+		}
+	}
+	return false
+}
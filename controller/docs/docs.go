@@ -32,81 +32,252 @@ var doc = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/v1/reportPackageModification": {
+        "/v1/admin/build/{id}/rebuild": {
             "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "F.e. after a failure that was fixed elsewhere. The admin and the reason are recorded on the new build.",
                 "consumes": [
                     "application/json"
                 ],
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
-                    "V1"
+                    "Admin"
                 ],
-                "summary": "Report packages as modified",
+                "summary": "Build the commit of a finished build again",
                 "parameters": [
                     {
-                        "description": "List of package names, max 250",
-                        "name": "names",
+                        "type": "integer",
+                        "description": "Build ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Reason, the commit hash is ignored",
+                        "name": "trigger",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/model.BuildTrigger"
                         }
                     }
                 ],
                 "responses": {
-                    "204": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/model.Build"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "Reason"
+                        }
+                    },
+                    "401": {
                         "description": ""
                     },
+                    "404": {
+                        "description": ""
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "Build"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/admin/builds": {
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "F.e. all package bases that depend on python after a python update.\nPackage bases whose latest commit has a pending build already are skipped.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Build the latest commits of multiple package bases",
+                "parameters": [
+                    {
+                        "description": "Package bases and reason",
+                        "name": "trigger",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.BulkBuildTrigger"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.BulkBuildTriggerResult"
+                        }
+                    },
                     "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "Reason"
+                        }
+                    },
+                    "401": {
                         "description": ""
                     }
                 }
             }
         },
-        "/v1/worker/heartbeat/{hostname}": {
+        "/v1/admin/packageBase/{packageBase}/build": {
             "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Builds the latest commit or the given one. The admin and the reason are recorded on the build.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
-                    "V1"
+                    "Admin"
                 ],
-                "summary": "Receives a heartbeat from a worker. Can also be used to register a new worker.",
+                "summary": "Build a package base",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Hostname",
-                        "name": "hostname",
+                        "description": "Package base",
+                        "name": "packageBase",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "Commit and reason",
+                        "name": "trigger",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.BuildTrigger"
+                        }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": ""
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/model.Build"
+                        }
                     },
                     "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "Reason"
+                        }
+                    },
+                    "401": {
                         "description": ""
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "Package"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "Commit"
+                        }
                     }
                 }
             }
         },
-        "/v1/worker/reportWorkResult": {
+        "/v1/admin/packageBaseConfig/{packageBase}": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Get the build configuration overrides of a package base",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Package base",
+                        "name": "packageBase",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.PackageBaseConfig"
+                        }
+                    },
+                    "401": {
+                        "description": ""
+                    },
+                    "404": {
+                        "description": ""
+                    }
+                }
+            },
             "put": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Zero values fall back to the defaults of the worker.",
                 "consumes": [
                     "application/json"
                 ],
                 "tags": [
-                    "V1"
+                    "Admin"
                 ],
-                "summary": "Endpoint for workers to report work results.",
+                "summary": "Set the build configuration overrides of a package base",
                 "parameters": [
                     {
-                        "description": "The result of the work",
-                        "name": "result",
+                        "type": "string",
+                        "description": "Package base",
+                        "name": "packageBase",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Overrides, the package base field is ignored",
+                        "name": "config",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/model.WorkResult"
+                            "$ref": "#/definitions/model.PackageBaseConfig"
                         }
                     }
                 ],
@@ -117,30 +288,131 @@ var doc = `{
                     "400": {
                         "description": ""
                     },
+                    "401": {
+                        "description": ""
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Remove the build configuration overrides of a package base",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Package base",
+                        "name": "packageBase",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "401": {
+                        "description": ""
+                    }
+                }
+            }
+        },
+        "/v1/admin/quarantine": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "They are processed again on their next modification or when retried.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List package bases the controller failed to process",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.QuarantinedPackageBase"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": ""
+                    }
+                }
+            }
+        },
+        "/v1/admin/quarantine/{packageBase}/retry": {
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Releases it from quarantine on success. Otherwise it stays quarantined with the new reason.",
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Process a quarantined package base again",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Package base",
+                        "name": "packageBase",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "401": {
+                        "description": ""
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "type": "Worker"
+                            "type": "Package"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "Processing"
                         }
                     }
                 }
             }
         },
-        "/v1/worker/requestWork": {
+        "/v1/build/{id}/artifacts": {
             "get": {
+                "description": "Split packages produce one artifact per package.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "V1"
                 ],
-                "summary": "Endpoint for workers to request work.",
+                "summary": "Get the packages a build produced",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Work amount to request, default 1",
-                        "name": "amount",
-                        "in": "query"
+                        "description": "Build ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
@@ -149,7 +421,7 @@ var doc = `{
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/model.Work"
+                                "$ref": "#/definitions/model.Artifact"
                             }
                         }
                     },
@@ -157,22 +429,1032 @@ var doc = `{
                         "description": ""
                     },
                     "404": {
-                        "description": "Not Found",
+                        "description": ""
+                    }
+                }
+            }
+        },
+        "/v1/build/{id}/filesystemChanges": {
+            "get": {
+                "description": "Changes by the build user outside of its home directory and temporary directories are a security concern.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Get the files a build changed outside of the paths it may write to",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Build ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "type": "Worker"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.FilesystemChange"
+                            }
                         }
+                    },
+                    "400": {
+                        "description": ""
+                    },
+                    "404": {
+                        "description": ""
                     }
                 }
             }
-        }
-    },
+        },
+        "/v1/build/{id}/namcap": {
+            "get": {
+                "description": "Findings of the PKGBUILD have the target \"PKGBUILD\", others the file name of a package.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Get the namcap findings of a build",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Build ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.NamcapFinding"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": ""
+                    },
+                    "404": {
+                        "description": ""
+                    }
+                }
+            }
+        },
+        "/v1/commit/{id}/reproducibility": {
+            "get": {
+                "description": "Every build with a reproducibility check adds a result, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Get the reproducibility results of a commit",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Commit ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.ReproducibilityResult"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": ""
+                    },
+                    "404": {
+                        "description": ""
+                    }
+                }
+            }
+        },
+        "/v1/commit/{id}/srcinfo": {
+            "get": {
+                "description": "Architecture specific values are the ones of x86_64, the content contains all of them.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Get the parsed .SRCINFO of a commit",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Commit ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.CommitSRCINFO"
+                        }
+                    },
+                    "400": {
+                        "description": ""
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "Commit"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/packageBase/{packageBase}/builds": {
+            "get": {
+                "description": "Includes the builds of package bases that were merged into it.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Get the builds of a package base, newest first",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Package base",
+                        "name": "packageBase",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Maximum number of builds, up to 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Number of builds to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Build"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": ""
+                    },
+                    "404": {
+                        "description": ""
+                    }
+                }
+            }
+        },
+        "/v1/packageBase/{packageBase}/merges": {
+            "get": {
+                "description": "Package bases that were deleted from the AUR while their packages moved to another one.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Get the merges of a package base, from and into it",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Package base",
+                        "name": "packageBase",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.PackageBaseMerge"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/reportPackageModification": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Report packages as modified",
+                "parameters": [
+                    {
+                        "description": "List of package names, max 250",
+                        "name": "names",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "400": {
+                        "description": ""
+                    }
+                }
+            }
+        },
+        "/v1/repositoryStats": {
+            "get": {
+                "description": "Updated by the daily git storage maintenance.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Get the storage usage and health of git repositories, largest first",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Maximum number of repositories, up to 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Number of repositories to skip",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.RepositoryStats"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": ""
+                    }
+                }
+            }
+        },
+        "/v1/repositoryStats/{packageBase}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Get the storage usage and health of the git repository of a package base",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Package base",
+                        "name": "packageBase",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.RepositoryStats"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "Repository"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/worker/heartbeat/{hostname}": {
+            "post": {
+                "description": "Workers should send their capacity and health with it, which is used to schedule builds.\nIf running builds of the worker were superseded or cancelled their IDs are returned, they should be aborted.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Receives a heartbeat from a worker. Can also be used to register a new worker.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Hostname",
+                        "name": "hostname",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Capacity and health of the worker",
+                        "name": "heartbeat",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/model.Heartbeat"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.HeartbeatResponse"
+                        }
+                    },
+                    "204": {
+                        "description": ""
+                    },
+                    "400": {
+                        "description": ""
+                    }
+                }
+            }
+        },
+        "/v1/worker/reportWorkResult": {
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Endpoint for workers to report work results.",
+                "parameters": [
+                    {
+                        "description": "The result of the work",
+                        "name": "result",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.WorkResult"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": ""
+                    },
+                    "400": {
+                        "description": ""
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "Worker"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/worker/requestWork": {
+            "get": {
+                "description": "Supports long-polling: If no work is available the request is held open for up to ` + "`" + `wait` + "`" + ` seconds.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "V1"
+                ],
+                "summary": "Endpoint for workers to request work.",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Work amount to request, default 1",
+                        "name": "amount",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Seconds to wait for work if none is available, default 0, max 60",
+                        "name": "wait",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.Work"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": ""
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "Worker"
+                        }
+                    }
+                }
+            }
+        }
+    },
     "definitions": {
+        "model.Artifact": {
+            "type": "object",
+            "properties": {
+                "architecture": {
+                    "type": "string"
+                },
+                "buildId": {
+                    "type": "integer"
+                },
+                "commitId": {
+                    "type": "integer"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "fileName": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "packageName": {
+                    "type": "string"
+                },
+                "packageRelease": {
+                    "type": "string"
+                },
+                "packageVersion": {
+                    "description": "[epoch:]pkgver",
+                    "type": "string"
+                },
+                "pkgInfo": {
+                    "description": "Content of .PKGINFO",
+                    "type": "string"
+                },
+                "sha256": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.Build": {
+            "type": "object",
+            "properties": {
+                "aurdependencies": {
+                    "description": "Resolved dependencies that need to be built from the AUR",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "commitId": {
+                    "type": "integer"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "dependsOnBuildIds": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "finishedAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "packageBase": {
+                    "type": "string"
+                },
+                "packageBaseId": {
+                    "type": "integer"
+                },
+                "rebuildOfBuildId": {
+                    "description": "Build that was rebuilt manually, if any",
+                    "type": "integer"
+                },
+                "resolvedVersion": {
+                    "description": "[epoch:]pkgver-pkgrel of the built packages, pkgver of VCS packages is set while building",
+                    "type": "string"
+                },
+                "startedAt": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "supersededByBuildId": {
+                    "description": "Build of the newer commit, if superseded",
+                    "type": "integer"
+                },
+                "triggerReason": {
+                    "type": "string"
+                },
+                "triggeredBy": {
+                    "description": "Name of the admin that triggered the build, empty for automatic builds",
+                    "type": "string"
+                },
+                "type": {
+                    "type": "integer"
+                },
+                "unsatisfiableDependencies": {
+                    "description": "Dependencies nothing satisfies, f.e. foo\u003e=2 if only foo 1 exists",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "workerId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.BuildTrigger": {
+            "type": "object",
+            "properties": {
+                "commitHash": {
+                    "description": "Optional, defaults to the latest commit",
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.BulkBuildTrigger": {
+            "type": "object",
+            "properties": {
+                "dependsOn": {
+                    "description": "Optional, adds all package bases that depend on this package, f.e. python",
+                    "type": "string"
+                },
+                "packageBases": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.BulkBuildTriggerResult": {
+            "type": "object",
+            "properties": {
+                "alreadyPending": {
+                    "description": "Package bases whose latest commit has a pending build already",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "builds": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Build"
+                    }
+                },
+                "unknown": {
+                    "description": "Package bases that aren't known or were removed from the AUR",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "model.CommitSRCINFO": {
+            "type": "object",
+            "properties": {
+                "architectures": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "checkDepends": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "commitId": {
+                    "type": "integer"
+                },
+                "content": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "depends": {
+                    "description": "Of all packages",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "makeDepends": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "packageBase": {
+                    "type": "string"
+                },
+                "packageNames": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "provides": {
+                    "description": "Of all packages",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "vcs": {
+                    "description": "A source is checked out from a VCS, f.e. git+https://…",
+                    "type": "boolean"
+                },
+                "version": {
+                    "description": "[epoch:]pkgver-pkgrel",
+                    "type": "string"
+                }
+            }
+        },
+        "model.FilesystemChange": {
+            "type": "object",
+            "properties": {
+                "buildId": {
+                    "type": "integer"
+                },
+                "change": {
+                    "description": "added, modified or removed",
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "fileType": {
+                    "description": "Type of find -printf %y, f.e. f for regular files or d for directories",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "path": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.Heartbeat": {
+            "type": "object",
+            "properties": {
+                "architecture": {
+                    "type": "string"
+                },
+                "cpucount": {
+                    "type": "integer"
+                },
+                "diskAvailable": {
+                    "type": "integer"
+                },
+                "diskTotal": {
+                    "type": "integer"
+                },
+                "freeSlots": {
+                    "type": "integer"
+                },
+                "loadAverage": {
+                    "type": "number"
+                },
+                "memoryAvailable": {
+                    "type": "integer"
+                },
+                "memoryTotal": {
+                    "type": "integer"
+                },
+                "runningBuildIds": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "sandbox": {
+                    "type": "string"
+                },
+                "sandboxVersion": {
+                    "type": "string"
+                },
+                "workerVersion": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.HeartbeatResponse": {
+            "type": "object",
+            "properties": {
+                "cancelBuildIds": {
+                    "description": "Running builds that were superseded or cancelled and should be aborted",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "model.NamcapFinding": {
+            "type": "object",
+            "properties": {
+                "buildId": {
+                    "type": "integer"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "message": {
+                    "description": "Arguments of the tag",
+                    "type": "string"
+                },
+                "severity": {
+                    "description": "error, warning or info",
+                    "type": "string"
+                },
+                "tag": {
+                    "description": "f.e. dependency-detected-not-included",
+                    "type": "string"
+                },
+                "target": {
+                    "description": "PKGBUILD or the file name of a package",
+                    "type": "string"
+                }
+            }
+        },
+        "model.PackageBaseConfig": {
+            "type": "object",
+            "properties": {
+                "buildTimeoutSeconds": {
+                    "type": "integer"
+                },
+                "checkReproducibility": {
+                    "description": "Enables the reproducibility check, even if it is disabled by default",
+                    "type": "boolean"
+                },
+                "checkTimeoutSeconds": {
+                    "type": "integer"
+                },
+                "cpus": {
+                    "type": "number"
+                },
+                "dependenciesTimeoutSeconds": {
+                    "type": "integer"
+                },
+                "diskBytes": {
+                    "type": "integer"
+                },
+                "downloadTimeoutSeconds": {
+                    "type": "integer"
+                },
+                "memoryBytes": {
+                    "type": "integer"
+                },
+                "packageBase": {
+                    "type": "string"
+                },
+                "pidsLimit": {
+                    "type": "integer"
+                },
+                "totalTimeoutSeconds": {
+                    "type": "integer"
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "updatedBy": {
+                    "type": "string"
+                },
+                "vcsrebuildIntervalSeconds": {
+                    "description": "Time between scheduled rebuilds of VCS packages",
+                    "type": "integer"
+                }
+            }
+        },
+        "model.PackageBaseMerge": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "intoPackageBase": {
+                    "type": "string"
+                },
+                "intoPackageBaseId": {
+                    "type": "integer"
+                },
+                "packageBase": {
+                    "type": "string"
+                },
+                "packageBaseId": {
+                    "type": "integer"
+                },
+                "packageNames": {
+                    "description": "That moved",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "model.QuarantinedPackageBase": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "packageBase": {
+                    "type": "string"
+                },
+                "packageBaseId": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "updatedAt": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.RepositoryStats": {
+            "type": "object",
+            "properties": {
+                "commits": {
+                    "type": "integer"
+                },
+                "error": {
+                    "description": "Of the last maintenance, f.e. missing or corrupt objects",
+                    "type": "string"
+                },
+                "maintainedAt": {
+                    "type": "string"
+                },
+                "packageBase": {
+                    "type": "string"
+                },
+                "packs": {
+                    "type": "integer"
+                },
+                "sizeBytes": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.ReproducibilityResult": {
+            "type": "object",
+            "properties": {
+                "buildId": {
+                    "type": "integer"
+                },
+                "commitId": {
+                    "type": "integer"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "differences": {
+                    "description": "f.e. \"foo-1-1-x86_64.pkg.tar.zst: ./usr/bin/foo differs in sha256digest, size\"",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "reproducible": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "model.ResourceLimits": {
+            "type": "object",
+            "properties": {
+                "cpus": {
+                    "type": "number"
+                },
+                "diskBytes": {
+                    "type": "integer"
+                },
+                "memoryBytes": {
+                    "type": "integer"
+                },
+                "pidsLimit": {
+                    "type": "integer"
+                }
+            }
+        },
+        "model.Timeouts": {
+            "type": "object",
+            "properties": {
+                "buildSeconds": {
+                    "type": "integer"
+                },
+                "checkSeconds": {
+                    "type": "integer"
+                },
+                "dependenciesSeconds": {
+                    "type": "integer"
+                },
+                "downloadSeconds": {
+                    "type": "integer"
+                },
+                "totalSeconds": {
+                    "type": "integer"
+                }
+            }
+        },
         "model.Work": {
             "type": "object",
             "properties": {
                 "buildId": {
                     "type": "integer"
                 },
+                "checkReproducibility": {
+                    "description": "Build twice and compare the packages",
+                    "type": "boolean"
+                },
                 "dependencies": {
                     "type": "array",
                     "items": {
@@ -184,18 +1466,49 @@ var doc = `{
                 },
                 "packageBaseDataBase64": {
                     "type": "string"
+                },
+                "resourceLimits": {
+                    "$ref": "#/definitions/model.ResourceLimits"
+                },
+                "sourceDateEpoch": {
+                    "description": "Passed to makepkg if set, f.e. the commit time",
+                    "type": "integer"
+                },
+                "timeouts": {
+                    "$ref": "#/definitions/model.Timeouts"
                 }
             }
         },
         "model.WorkResult": {
             "type": "object",
             "properties": {
+                "artifacts": {
+                    "description": "Stored in their own table",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.Artifact"
+                    }
+                },
                 "buildId": {
                     "type": "integer"
                 },
                 "createdAt": {
                     "type": "string"
                 },
+                "errorCategory": {
+                    "description": "timeout, out-of-memory, build, requires-network, docker-daemon, image, cancelled or internal",
+                    "type": "string"
+                },
+                "errorMessage": {
+                    "type": "string"
+                },
+                "filesystemChanges": {
+                    "description": "Stored in their own table",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.FilesystemChange"
+                    }
+                },
                 "id": {
                     "type": "integer"
                 },
@@ -205,23 +1518,51 @@ var doc = `{
                 "makepkgBuildLogBase64": {
                     "type": "string"
                 },
+                "makepkgBuildNetworkExitCode": {
+                    "type": "integer"
+                },
+                "makepkgBuildNetworkLogBase64": {
+                    "type": "string"
+                },
                 "makepkgExtractExitCode": {
                     "type": "integer"
                 },
                 "makepkgExtractLogBase64": {
                     "type": "string"
                 },
+                "namcapFindings": {
+                    "description": "Stored in their own table",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.NamcapFinding"
+                    }
+                },
                 "pacmanExitCode": {
                     "type": "integer"
                 },
                 "pacmanLogBase64": {
                     "type": "string"
                 },
+                "reproducibility": {
+                    "description": "Stored in its own table",
+                    "$ref": "#/definitions/model.ReproducibilityResult"
+                },
                 "status": {
                     "type": "integer"
+                },
+                "timedOutStep": {
+                    "description": "total, dependencies, download, build or check",
+                    "type": "string"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "AdminToken": {
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
     }
 }`
 
@@ -4,6 +4,9 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hetznercloud/hcloud-go/hcloud"
 	_ "github.com/mattn/go-sqlite3"
@@ -12,6 +15,7 @@ import (
 	"github.com/cheggaaa/pb/v3"
 	"github.com/hashworks/aur-ci/controller/aur"
 	_ "github.com/hashworks/aur-ci/controller/docs"
+	"github.com/hashworks/aur-ci/controller/mirror"
 	"github.com/hashworks/aur-ci/controller/model"
 	"github.com/hashworks/aur-ci/controller/server"
 	"github.com/robfig/cron/v3"
@@ -25,6 +29,30 @@ func getEnv(key string, defaultValue string) string {
 	return v
 }
 
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if len(v) == 0 {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Failed to parse $%s: %s", key, err)
+	}
+	return duration
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	v := os.Getenv(key)
+	if len(v) == 0 {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("Failed to parse $%s: %s", key, err)
+	}
+	return i
+}
+
 // @title AUR CI Controller
 // @version 1.0
 // @description Continuous Integration Controller for the Arch Linux User Repository
@@ -34,6 +62,9 @@ func getEnv(key string, defaultValue string) string {
 // @license.name GNU General Public License v3
 // @license.url https://www.gnu.org/licenses/gpl-3.0
 // @BasePath /api
+// @securityDefinitions.apikey AdminToken
+// @in header
+// @name Authorization
 func main() {
 	addr := flag.String("addr", getEnv("ADDRESS", "127.0.0.1:8080"), "Address to bind")
 	externalURI := flag.String("external-uri", getEnv("EXTERNAL_URI", "http://127.0.0.1:8080"), "External uri")
@@ -42,6 +73,25 @@ func main() {
 	gitStoragePath := flag.String("git", getEnv("GIT_STORAGE_PATH", "./git"), "Git storage path [$GIT_STORAGE_PATH]")
 	hetznerToken := flag.String("hetzner", getEnv("HETZNER_API_TOKEN", ""), "Hetzner API Token [$HETZNER_API_TOKEN]")
 	hetznerSSHKeyName := flag.String("hetznerSSHKey", getEnv("HETZNER_SSH_KEY", ""), "Hetzner SSH Key Name [$HETZNER_SSH_KEY]")
+	adminTokens := flag.String("admin-tokens", getEnv("ADMIN_TOKENS", ""), "Comma separated list of name:token pairs allowed to use the admin API [$ADMIN_TOKENS]")
+	buildTimeout := flag.Duration("build-timeout", getEnvDuration("BUILD_TIMEOUT", 30*time.Minute), "Default total timeout of a build [$BUILD_TIMEOUT]")
+	maxBuildTimeout := flag.Duration("max-build-timeout", getEnvDuration("MAX_BUILD_TIMEOUT", 50*time.Minute), "Maximum total timeout of a build, should stay below the lifetime of worker VMs [$MAX_BUILD_TIMEOUT]")
+	dependenciesTimeout := flag.Duration("dependencies-timeout", getEnvDuration("DEPENDENCIES_TIMEOUT", 0), "Default timeout of the dependency installation, 0 for none [$DEPENDENCIES_TIMEOUT]")
+	downloadTimeout := flag.Duration("download-timeout", getEnvDuration("DOWNLOAD_TIMEOUT", 0), "Default timeout of the source download, 0 for none [$DOWNLOAD_TIMEOUT]")
+	buildStepTimeout := flag.Duration("build-step-timeout", getEnvDuration("BUILD_STEP_TIMEOUT", 0), "Default timeout of makepkg build(), 0 for none [$BUILD_STEP_TIMEOUT]")
+	checkTimeout := flag.Duration("check-timeout", getEnvDuration("CHECK_TIMEOUT", 0), "Default timeout of makepkg check(), 0 for none [$CHECK_TIMEOUT]")
+	mirrorUpstream := flag.String("mirror-upstream", getEnv("MIRROR_UPSTREAM", ""), "Pacman mirror to proxy at /mirror/$repo/os/$arch, f.e. https://geo.mirror.pkgbuild.com. Empty to disable. [$MIRROR_UPSTREAM]")
+	mirrorCachePath := flag.String("mirror-cache", getEnv("MIRROR_CACHE_PATH", "./mirror"), "Cache path of the pacman mirror proxy [$MIRROR_CACHE_PATH]")
+	mirrorDatabaseTTL := flag.Duration("mirror-database-ttl", getEnvDuration("MIRROR_DATABASE_TTL", 5*time.Minute), "Time until the pacman mirror proxy refreshes databases [$MIRROR_DATABASE_TTL]")
+	namcapWarningsStatus := flag.Bool("namcap-warnings-status", getEnv("NAMCAP_WARNINGS_STATUS", "") == "true", "Mark successful builds with namcap errors or warnings as build with warnings [$NAMCAP_WARNINGS_STATUS]")
+	supersedeRunningBuilds := flag.Bool("supersede-running-builds", getEnv("SUPERSEDE_RUNNING_BUILDS", "") == "true", "Abort running builds of a package base when a newer commit arrives, not only pending ones [$SUPERSEDE_RUNNING_BUILDS]")
+	checkReproducibility := flag.Bool("check-reproducibility", getEnv("CHECK_REPRODUCIBILITY", "") == "true", "Build every commit twice and compare the packages, doubles the build time. Can be enabled per package base as well. [$CHECK_REPRODUCIBILITY]")
+	syncDatabasePath := flag.String("sync-db-path", getEnv("SYNC_DB_PATH", ""), "Directory of the official sync databases (f.e. core.db) to resolve dependencies against, like /var/lib/pacman/sync. Empty to pass dependencies unresolved. [$SYNC_DB_PATH]")
+	rebuildInterpreters := flag.String("rebuild-interpreters", getEnv("REBUILD_INTERPRETERS", "python,perl,ruby,lua,php"), "Comma separated official packages whose dependents are rebuilt when their major.minor version changes [$REBUILD_INTERPRETERS]")
+	syncRepositories := flag.String("sync-repositories", getEnv("SYNC_REPOSITORIES", "core,extra,multilib"), "Comma separated official repositories in the order of pacman.conf [$SYNC_REPOSITORIES]")
+	packageList := flag.String("package-list", getEnv("PACKAGE_LIST", aur.DEFAULT_PACKAGE_LIST_URL), "URL or file of the AUR package list (packages-meta-v1.json, optionally gzip compressed) to detect removed and merged packages. Empty to disable. [$PACKAGE_LIST]")
+	vcsRebuildInterval := flag.Duration("vcs-rebuild-interval", getEnvDuration("VCS_REBUILD_INTERVAL", 7*24*time.Hour), "Default time between scheduled rebuilds of VCS packages like foo-git, 0 to disable [$VCS_REBUILD_INTERVAL]")
+	vcsRebuildsPerRun := flag.Int("vcs-rebuilds-per-run", getEnvInt("VCS_REBUILDS_PER_RUN", 50), "Maximum amount of VCS rebuilds enqueued per scheduler run, which happens every hour, popular packages go first [$VCS_REBUILDS_PER_RUN]")
 	initializeGit := flag.Bool("initializeGit", false, "Initialize or update git repositories")
 	flag.Parse()
 
@@ -71,14 +121,51 @@ func main() {
 		HetznerSSHKeyName: hetznerSSHKeyName,
 		HetznerClient:     hcloud.NewClient(hcloud.WithToken(*hetznerToken)),
 		ExternalURI:       externalURI,
-		DB:                createDatabaseEngine(driver, dsn),
+		AdminTokens:       parseAdminTokens(adminTokens),
+		DefaultTimeouts: model.Timeouts{
+			TotalSeconds:        int(buildTimeout.Seconds()),
+			DependenciesSeconds: int(dependenciesTimeout.Seconds()),
+			DownloadSeconds:     int(downloadTimeout.Seconds()),
+			BuildSeconds:        int(buildStepTimeout.Seconds()),
+			CheckSeconds:        int(checkTimeout.Seconds()),
+		},
+		MaxTotalTimeoutSeconds: int(maxBuildTimeout.Seconds()),
+		NamcapWarningsStatus:   *namcapWarningsStatus,
+		CheckReproducibility:   *checkReproducibility,
+		SupersedeRunningBuilds: *supersedeRunningBuilds,
+		SyncDatabasePath:       *syncDatabasePath,
+		SyncRepositories:       strings.Split(*syncRepositories, ","),
+		RebuildInterpreters:    strings.Split(*rebuildInterpreters, ","),
+		VCSRebuildInterval:     *vcsRebuildInterval,
+		VCSRebuildsPerRun:      *vcsRebuildsPerRun,
+		DB:                     createDatabaseEngine(driver, dsn),
+	}
+
+	if len(*mirrorUpstream) > 0 {
+		pacmanMirror, err := mirror.New(*mirrorUpstream, *mirrorCachePath, *mirrorDatabaseTTL)
+		if err != nil {
+			log.Fatal("Failed to create pacman mirror proxy: " + err.Error())
+		}
+		server.Mirror = pacmanMirror
+	}
+
+	if len(*packageList) > 0 {
+		server.PackageList = aur.NewPackageListSource(*packageList)
 	}
 
 	initializeDatabase(server.DB)
 	defer server.DB.Close()
 
+	server.LoadSyncDatabases()
+	server.BackfillSRCINFOVCS()
+
 	c := cron.New()
 	c.AddFunc("@every 5m", server.CheckVMStatus)
+	c.AddFunc("@every 30m", server.LoadSyncDatabases)
+	c.AddFunc("@every 1h", server.ReconcilePackages)
+	// Maintenance of large storages can take longer than a day
+	c.AddJob("@daily", cron.NewChain(cron.SkipIfStillRunning(cron.DefaultLogger)).Then(cron.FuncJob(server.MaintainGitStorage)))
+	c.AddJob("@every 1h", cron.NewChain(cron.SkipIfStillRunning(cron.DefaultLogger)).Then(cron.FuncJob(server.ScheduleVCSRebuilds)))
 	c.Start()
 
 	routerEngine := server.NewRouter()
@@ -89,12 +176,31 @@ func main() {
 }
 
 func initializeDatabase(engine *xorm.Engine) {
-	err := engine.Sync2(new(model.Package), new(model.Commit), new(model.Worker), new(model.Build), new(model.WorkResult))
+	err := engine.Sync2(new(model.Package), new(model.Commit), new(model.Worker), new(model.Build), new(model.WorkResult),
+		new(model.PackageBaseConfig), new(model.Artifact), new(model.NamcapFinding),
+		new(model.FilesystemChange), new(model.ReproducibilityResult),
+		new(model.CommitSRCINFO), new(model.QuarantinedPackageBase), new(model.RepositoryStats),
+		new(model.PackageBaseMerge), new(model.RepositoryPackage))
 	if err != nil {
 		log.Fatal("Failed to sync structs to database tables: " + err.Error())
 	}
 }
 
+func parseAdminTokens(adminTokens *string) map[string]string {
+	tokens := make(map[string]string)
+	if len(*adminTokens) == 0 {
+		return tokens
+	}
+	for _, pair := range strings.Split(*adminTokens, ",") {
+		nameAndToken := strings.SplitN(pair, ":", 2)
+		if len(nameAndToken) != 2 || len(nameAndToken[0]) == 0 || len(nameAndToken[1]) == 0 {
+			log.Fatal("Invalid admin token, expected name:token")
+		}
+		tokens[nameAndToken[1]] = nameAndToken[0]
+	}
+	return tokens
+}
+
 func createDatabaseEngine(driver *string, dsn *string) *xorm.Engine {
 	engine, err := xorm.NewEngine(*driver, *dsn)
 	if err != nil {
@@ -115,21 +221,21 @@ func initializeOrUpdateGitRepositories(gitStoragePath *string) {
 	for _, pkgBase := range pkgBases {
 		bar.Increment()
 
-		repo, err := aur.CloneOrFetchRepository(gitStoragePath, pkgBase)
-		if err != nil {
+		if err := aur.CloneOrFetchRepository(gitStoragePath, pkgBase); err != nil {
 			log.Printf("Failed to clone/fetch %s: %s", pkgBase, err)
 			continue
 		}
-		ref, err := repo.Head()
+		headHash, err := aur.GetHEADHash(gitStoragePath, pkgBase)
 		if err != nil {
 			log.Printf("Failed to get head of %s: %s", pkgBase, err)
 			continue
 		}
-		_, err = aur.GetCommitTAR(gitStoragePath, pkgBase, ref.Hash().String())
+		tarReader, err := aur.GetCommitTAR(gitStoragePath, pkgBase, headHash)
 		if err != nil {
 			log.Printf("Failed to create head TAR of %s: %s", pkgBase, err)
 			continue
 		}
+		tarReader.Close()
 	}
 
 	bar.Finish()
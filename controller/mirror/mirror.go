@@ -0,0 +1,236 @@
+package mirror
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A caching proxy of a pacman mirror. Workers use it as the only server in their mirrorlist,
+// f.e. `Server = http://controller:8080/mirror/$repo/os/$arch`.
+//
+// Package files never change and are cached forever. Databases are refreshed from the upstream
+// mirror once they are older than the database TTL. Other files are passed through.
+type Mirror struct {
+	// Base URL of the upstream mirror, requested paths are appended to it
+	Upstream    string
+	CachePath   string
+	DatabaseTTL time.Duration
+	Client      *http.Client
+}
+
+var errInvalidPath = errors.New("invalid path")
+
+func New(upstream string, cachePath string, databaseTTL time.Duration) (*Mirror, error) {
+	if err := os.MkdirAll(cachePath, 0755); err != nil {
+		return nil, err
+	}
+	return &Mirror{
+		Upstream:    strings.TrimSuffix(upstream, "/"),
+		CachePath:   cachePath,
+		DatabaseTTL: databaseTTL,
+		Client:      &http.Client{Timeout: 30 * time.Minute},
+	}, nil
+}
+
+func isPackage(name string) bool {
+	return strings.Contains(name, ".pkg.tar")
+}
+
+// f.e. core.db, core.files, core.db.sig, core.db.tar.gz
+func isDatabase(name string) bool {
+	name = strings.TrimSuffix(name, ".sig")
+	for _, suffix := range []string{".db", ".files", ".db.tar.gz", ".files.tar.gz", ".db.tar.zst", ".files.tar.zst"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	requestPath, err := cleanPath(r.URL.Path)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	name := path.Base(requestPath)
+
+	switch {
+	case isPackage(name):
+		m.servePackage(w, r, requestPath)
+	case isDatabase(name):
+		m.serveDatabase(w, r, requestPath)
+	default:
+		m.passThrough(w, r, requestPath)
+	}
+}
+
+// Returns the path without a leading slash. Paths must not leave the cache directory.
+func cleanPath(requestPath string) (string, error) {
+	cleaned := path.Clean("/" + requestPath)
+	if cleaned == "/" || strings.Contains(cleaned, "/.") {
+		return "", errInvalidPath
+	}
+	return strings.TrimPrefix(cleaned, "/"), nil
+}
+
+func (m *Mirror) getCacheFilePath(requestPath string) string {
+	return filepath.Join(m.CachePath, filepath.FromSlash(requestPath))
+}
+
+func (m *Mirror) servePackage(w http.ResponseWriter, r *http.Request, requestPath string) {
+	if m.serveCacheFile(w, r, requestPath) {
+		return
+	}
+	m.fetchAndServe(w, r, requestPath, time.Time{})
+}
+
+func (m *Mirror) serveDatabase(w http.ResponseWriter, r *http.Request, requestPath string) {
+	stat, err := os.Stat(m.getCacheFilePath(requestPath))
+	if err == nil && time.Since(stat.ModTime()) < m.DatabaseTTL {
+		if m.serveCacheFile(w, r, requestPath) {
+			return
+		}
+	}
+
+	var cachedAt time.Time
+	if err == nil {
+		cachedAt = stat.ModTime()
+	}
+	m.fetchAndServe(w, r, requestPath, cachedAt)
+}
+
+// Returns false if the file isn't cached
+func (m *Mirror) serveCacheFile(w http.ResponseWriter, r *http.Request, requestPath string) bool {
+	file, err := os.Open(m.getCacheFilePath(requestPath))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		return false
+	}
+
+	http.ServeContent(w, r, path.Base(requestPath), stat.ModTime(), file)
+	return true
+}
+
+func (m *Mirror) passThrough(w http.ResponseWriter, r *http.Request, requestPath string) {
+	resp, err := m.Client.Get(m.Upstream + "/" + requestPath)
+	if err != nil {
+		log.Println("Error: Failed to request upstream mirror: ", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	copyHeaders(w, resp)
+	w.WriteHeader(resp.StatusCode)
+	if r.Method == http.MethodGet {
+		_, _ = io.Copy(w, resp.Body)
+	}
+}
+
+// Requests a file from the upstream mirror and streams it to the client while writing it to the cache.
+// If the file is cached already it is only downloaded if it was modified since it was cached.
+// A cached file is served if the upstream mirror is unavailable.
+func (m *Mirror) fetchAndServe(w http.ResponseWriter, r *http.Request, requestPath string, cachedAt time.Time) {
+	cacheFilePath := m.getCacheFilePath(requestPath)
+
+	request, err := http.NewRequestWithContext(r.Context(), http.MethodGet, m.Upstream+"/"+requestPath, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !cachedAt.IsZero() {
+		request.Header.Set("If-Modified-Since", cachedAt.UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := m.Client.Do(request)
+	if err != nil {
+		log.Println("Error: Failed to request upstream mirror: ", err)
+		if !m.serveCacheFile(w, r, requestPath) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && !cachedAt.IsZero():
+		now := time.Now()
+		_ = os.Chtimes(cacheFilePath, now, now)
+		if !m.serveCacheFile(w, r, requestPath) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	case resp.StatusCode != http.StatusOK:
+		if resp.StatusCode >= 500 && m.serveCacheFile(w, r, requestPath) {
+			return
+		}
+		w.WriteHeader(resp.StatusCode)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cacheFilePath), 0755); err != nil {
+		log.Println("Error: Failed to create mirror cache directory: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Concurrent downloads of the same file write to their own temporary file, the last one wins
+	tempFile, err := ioutil.TempFile(filepath.Dir(cacheFilePath), "."+path.Base(requestPath)+".*.part")
+	if err != nil {
+		log.Println("Error: Failed to create mirror cache file: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	copyHeaders(w, resp)
+	w.WriteHeader(http.StatusOK)
+
+	var writer io.Writer = tempFile
+	if r.Method == http.MethodGet {
+		writer = io.MultiWriter(tempFile, w)
+	}
+	if _, err := io.Copy(writer, resp.Body); err != nil {
+		log.Printf("Error: Failed to download %s from upstream mirror: %s\n", requestPath, err)
+		return
+	}
+	if err := tempFile.Close(); err != nil {
+		log.Println("Error: Failed to write mirror cache file: ", err)
+		return
+	}
+
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && isPackage(requestPath) {
+		_ = os.Chtimes(tempFile.Name(), lastModified, lastModified)
+	}
+	if err := os.Rename(tempFile.Name(), cacheFilePath); err != nil {
+		log.Println("Error: Failed to write mirror cache file: ", err)
+	}
+}
+
+func copyHeaders(w http.ResponseWriter, resp *http.Response) {
+	for _, header := range []string{"Content-Length", "Content-Type", "Last-Modified"} {
+		if value := resp.Header.Get(header); len(value) > 0 {
+			w.Header().Set(header, value)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package mirror
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An upstream mirror serving files from memory, counting requests per path
+type fakeUpstream struct {
+	mutex    sync.Mutex
+	files    map[string]string
+	modTimes map[string]time.Time
+	statuses map[string]int
+	requests map[string]int
+}
+
+func newFakeUpstream(t *testing.T) (*fakeUpstream, *httptest.Server) {
+	upstream := &fakeUpstream{
+		files:    make(map[string]string),
+		modTimes: make(map[string]time.Time),
+		statuses: make(map[string]int),
+		requests: make(map[string]int),
+	}
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+	return upstream, server
+}
+
+func (u *fakeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.requests[r.URL.Path]++
+
+	if status, ok := u.statuses[r.URL.Path]; ok {
+		w.WriteHeader(status)
+		return
+	}
+	content, ok := u.files[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	modTime := u.modTimes[r.URL.Path]
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	_, _ = w.Write([]byte(content))
+}
+
+func (u *fakeUpstream) set(path string, content string, modTime time.Time) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.files[path] = content
+	u.modTimes[path] = modTime
+	delete(u.statuses, path)
+}
+
+func (u *fakeUpstream) fail(path string, status int) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.statuses[path] = status
+}
+
+func (u *fakeUpstream) requestsOf(path string) int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.requests[path]
+}
+
+func newTestMirror(t *testing.T, upstream string) *Mirror {
+	mirror, err := New(upstream, t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mirror
+}
+
+func get(t *testing.T, mirror *Mirror, path string) (int, string) {
+	recorder := httptest.NewRecorder()
+	mirror.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	body, err := ioutil.ReadAll(recorder.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Code, string(body)
+}
+
+func TestPackageIsCached(t *testing.T) {
+	const packagePath = "/core/os/x86_64/foo-1.0-1-x86_64.pkg.tar.zst"
+	upstream, server := newFakeUpstream(t)
+	upstream.set(packagePath, "foo package", time.Now().Add(-24*time.Hour))
+	mirror := newTestMirror(t, server.URL+"/")
+
+	for i := 0; i < 2; i++ {
+		if status, body := get(t, mirror, packagePath); status != http.StatusOK || body != "foo package" {
+			t.Fatalf("Request %d: got %d %q, want 200 with the package", i+1, status, body)
+		}
+	}
+	if requests := upstream.requestsOf(packagePath); requests != 1 {
+		t.Errorf("Upstream got %d requests, want 1", requests)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(mirror.CachePath, "core", "os", "x86_64", "foo-1.0-1-x86_64.pkg.tar.zst"))
+	if err != nil || string(content) != "foo package" {
+		t.Errorf("Cache file = %q, %v; want the package", content, err)
+	}
+
+	// Packages never change, they are served even if they vanish upstream
+	upstream.fail(packagePath, http.StatusNotFound)
+	if status, body := get(t, mirror, packagePath); status != http.StatusOK || body != "foo package" {
+		t.Errorf("Got %d %q after the package vanished upstream, want 200 with the package", status, body)
+	}
+}
+
+func TestDatabaseIsRefreshed(t *testing.T) {
+	const databasePath = "/core/os/x86_64/core.db"
+	upstream, server := newFakeUpstream(t)
+	upstream.set(databasePath, "first", time.Now().Add(-3*time.Hour))
+	mirror := newTestMirror(t, server.URL)
+	cacheFilePath := filepath.Join(mirror.CachePath, "core", "os", "x86_64", "core.db")
+
+	if status, body := get(t, mirror, databasePath); status != http.StatusOK || body != "first" {
+		t.Fatalf("Got %d %q, want 200 with the first database", status, body)
+	}
+
+	// Within the TTL the cached database is served, even if upstream changed
+	upstream.set(databasePath, "second", time.Now().Add(-time.Hour))
+	if status, body := get(t, mirror, databasePath); status != http.StatusOK || body != "first" {
+		t.Errorf("Got %d %q within the TTL, want 200 with the first database", status, body)
+	}
+	if requests := upstream.requestsOf(databasePath); requests != 1 {
+		t.Errorf("Upstream got %d requests within the TTL, want 1", requests)
+	}
+
+	// Expired, upstream was modified after the database was cached
+	expired := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(cacheFilePath, expired, expired); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := get(t, mirror, databasePath); status != http.StatusOK || body != "second" {
+		t.Errorf("Got %d %q after the TTL, want 200 with the second database", status, body)
+	}
+
+	// Expired, but not modified upstream: the cached database is kept and counts as fresh again
+	if err := os.Chtimes(cacheFilePath, expired, expired); err != nil {
+		t.Fatal(err)
+	}
+	upstream.set(databasePath, "third, but not modified", time.Now().Add(-3*time.Hour))
+	if status, body := get(t, mirror, databasePath); status != http.StatusOK || body != "second" {
+		t.Errorf("Got %d %q for a database not modified upstream, want 200 with the second database", status, body)
+	}
+	if stat, err := os.Stat(cacheFilePath); err != nil || time.Since(stat.ModTime()) > time.Minute {
+		t.Errorf("The cached database was not marked as fresh: %v", err)
+	}
+	if requests := upstream.requestsOf(databasePath); requests != 3 {
+		t.Errorf("Upstream got %d requests, want 3", requests)
+	}
+}
+
+func TestUpstreamErrorsArePassedThrough(t *testing.T) {
+	upstream, server := newFakeUpstream(t)
+	upstream.fail("/core/os/x86_64/core.db", http.StatusServiceUnavailable)
+	upstream.fail("/core/os/x86_64/index.html", http.StatusForbidden)
+	mirror := newTestMirror(t, server.URL)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/core/os/x86_64/missing-1.0-1-x86_64.pkg.tar.zst", http.StatusNotFound},
+		{"/core/os/x86_64/core.db", http.StatusServiceUnavailable},
+		{"/core/os/x86_64/index.html", http.StatusForbidden},
+		{"/core/os/x86_64/.hidden.db", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if status, _ := get(t, mirror, test.path); status != test.status {
+			t.Errorf("%s: got %d, want %d", test.path, status, test.status)
+		}
+	}
+
+	// Failures are never cached
+	if _, err := os.Stat(filepath.Join(mirror.CachePath, "core", "os", "x86_64", "core.db")); !os.IsNotExist(err) {
+		t.Errorf("A failed database request was cached: %v", err)
+	}
+}
+
+func TestUpstreamFailureServesStaleDatabase(t *testing.T) {
+	const databasePath = "/extra/os/x86_64/extra.db"
+	upstream, server := newFakeUpstream(t)
+	upstream.set(databasePath, "stale", time.Now().Add(-3*time.Hour))
+	mirror := newTestMirror(t, server.URL)
+
+	if status, _ := get(t, mirror, databasePath); status != http.StatusOK {
+		t.Fatalf("Got %d, want 200", status)
+	}
+	expired := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(mirror.CachePath, "extra", "os", "x86_64", "extra.db"), expired, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	upstream.fail(databasePath, http.StatusInternalServerError)
+	if status, body := get(t, mirror, databasePath); status != http.StatusOK || body != "stale" {
+		t.Errorf("Got %d %q on an upstream server error, want 200 with the stale database", status, body)
+	}
+
+	// Unreachable upstream
+	server.Close()
+	if status, body := get(t, mirror, databasePath); status != http.StatusOK || body != "stale" {
+		t.Errorf("Got %d %q with an unreachable upstream, want 200 with the stale database", status, body)
+	}
+	if status, _ := get(t, mirror, "/extra/os/x86_64/uncached-1.0-1-x86_64.pkg.tar.zst"); status != http.StatusBadGateway {
+		t.Errorf("Got %d for an uncached package with an unreachable upstream, want 502", status)
+	}
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// A package file produced by a build, one per package of split packages
+type Artifact struct {
+	Id             int64
+	BuildId        int64 `xorm:"index"`
+	CommitId       int64 `xorm:"index"`
+	FileName       string
+	Size           int64
+	SHA256         string `xorm:"'sha256'"`
+	PackageName    string
+	PackageVersion string // [epoch:]pkgver
+	PackageRelease string
+	Architecture   string
+	PkgInfo        string    `xorm:"text"` // Content of .PKGINFO
+	CreatedAt      time.Time `xorm:"created"`
+}
+
+// Returns the [epoch:]pkgver-pkgrel of the packages. Packages of a split package share it,
+// false if there are none or they report different versions.
+func GetResolvedVersion(artifacts []Artifact) (string, bool) {
+	if len(artifacts) == 0 {
+		return "", false
+	}
+	version := artifacts[0].PackageVersion + "-" + artifacts[0].PackageRelease
+	for _, artifact := range artifacts[1:] {
+		if artifact.PackageVersion+"-"+artifact.PackageRelease != version {
+			return "", false
+		}
+	}
+	return version, true
+}
@@ -6,28 +6,43 @@ type BuildStatus int8
 type BuildType int8
 
 const (
-	STATUS_PENDING  BuildStatus = 10
-	STATUS_BUILDING BuildStatus = 20
-	STATUS_TIMEOUT  BuildStatus = 30
-	STATUS_FAILED   BuildStatus = 40
-	STATUS_BUILD    BuildStatus = 50
+	STATUS_PENDING          BuildStatus = 10
+	STATUS_BUILDING         BuildStatus = 20
+	STATUS_TIMEOUT          BuildStatus = 30
+	STATUS_OUT_OF_MEMORY    BuildStatus = 35
+	STATUS_FAILED           BuildStatus = 40
+	STATUS_UNSATISFIABLE    BuildStatus = 43 // Not build, dependencies can't be satisfied
+	STATUS_REQUIRES_NETWORK BuildStatus = 45
+	STATUS_BUILD            BuildStatus = 50
+	STATUS_BUILD_WARNINGS   BuildStatus = 55 // Build, but namcap found errors or warnings
+	STATUS_CANCELLED        BuildStatus = 60 // Not build, f.e. because the package base was removed from the AUR
+	STATUS_SUPERSEDED       BuildStatus = 65 // Not build or aborted, a newer commit of the package base is built instead
 )
 
 const (
 	TYPE_PACKAGE    BuildType = 10
 	TYPE_DEPENDENCY BuildType = 20
+	TYPE_REBUILD    BuildType = 30 // Of the latest commit, since an official package it depends on changed
+	TYPE_VCS        BuildType = 40 // Of the latest commit of a VCS package, since its upstream may have changed
 )
 
 type Build struct {
-	Id                int64
-	PackageBase       string
-	PackageBaseId     int64
-	CommitId          int64
-	WorkerId          int64
-	Status            BuildStatus
-	Type              BuildType
-	DependsOnBuildIds []int64
-	CreatedAt         time.Time `xorm:"created"`
-	StartedAt         time.Time
-	FinishedAt        time.Time
+	Id                        int64
+	PackageBase               string
+	PackageBaseId             int64
+	CommitId                  int64
+	WorkerId                  int64
+	Status                    BuildStatus
+	Type                      BuildType
+	DependsOnBuildIds         []int64
+	AURDependencies           []string `xorm:"'aur_dependencies'"` // Resolved dependencies that need to be built from the AUR
+	UnsatisfiableDependencies []string // Dependencies nothing satisfies, f.e. foo>=2 if only foo 1 exists
+	SupersededByBuildId       int64    // Build of the newer commit, if superseded
+	RebuildOfBuildId          int64    // Build that was rebuilt manually, if any
+	TriggeredBy               string   // Name of the admin that triggered the build, empty for automatic builds
+	TriggerReason             string
+	ResolvedVersion           string    // [epoch:]pkgver-pkgrel of the built packages, pkgver of VCS packages is set while building
+	CreatedAt                 time.Time `xorm:"created"`
+	StartedAt                 time.Time
+	FinishedAt                time.Time
 }
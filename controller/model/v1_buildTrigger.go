@@ -0,0 +1,20 @@
+package model
+
+// Manually requested build of a package base
+type BuildTrigger struct {
+	CommitHash string // Optional, defaults to the latest commit
+	Reason     string
+}
+
+// Manually requested builds of the latest commits of multiple package bases
+type BulkBuildTrigger struct {
+	PackageBases []string
+	DependsOn    string // Optional, adds all package bases that depend on this package, f.e. python
+	Reason       string
+}
+
+type BulkBuildTriggerResult struct {
+	Builds         []Build
+	AlreadyPending []string // Package bases whose latest commit has a pending build already
+	Unknown        []string // Package bases that aren't known or were removed from the AUR
+}
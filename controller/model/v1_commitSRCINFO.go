@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// The parsed .SRCINFO of a commit. Architecture specific values are the ones of x86_64.
+type CommitSRCINFO struct {
+	CommitId      int64  `xorm:"pk"`
+	PackageBase   string `xorm:"index"`
+	PackageNames  []string
+	Version       string // [epoch:]pkgver-pkgrel
+	Architectures []string
+	Depends       []string // Of all packages
+	MakeDepends   []string
+	CheckDepends  []string
+	Provides      []string  // Of all packages
+	VCS           bool      `xorm:"'vcs' index"` // A source is checked out from a VCS, f.e. git+https://…
+	Content       string    `xorm:"text"`
+	CreatedAt     time.Time `xorm:"created"`
+}
+
+// Returns all dependencies needed to build the packages
+func (s *CommitSRCINFO) GetBuildDependencies() []string {
+	var dependencies []string
+	dependencies = append(dependencies, s.Depends...)
+	dependencies = append(dependencies, s.MakeDepends...)
+	dependencies = append(dependencies, s.CheckDepends...)
+	return dependencies
+}
+
+func (CommitSRCINFO) TableName() string {
+	return "commit_srcinfo"
+}
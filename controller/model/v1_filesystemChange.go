@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+const (
+	FILESYSTEM_CHANGE_ADDED    = "added"
+	FILESYSTEM_CHANGE_MODIFIED = "modified"
+	FILESYSTEM_CHANGE_REMOVED  = "removed"
+)
+
+// A file a build changed outside of the paths it may write to
+type FilesystemChange struct {
+	Id        int64
+	BuildId   int64 `xorm:"index"`
+	Path      string
+	Change    string    // added, modified or removed
+	FileType  string    // Type of find -printf %y, f.e. f for regular files or d for directories
+	CreatedAt time.Time `xorm:"created"`
+}
@@ -0,0 +1,21 @@
+package model
+
+type Heartbeat struct {
+	FreeSlots       int
+	RunningBuildIds []int64
+	CPUCount        int
+	LoadAverage     float64
+	MemoryTotal     int64
+	MemoryAvailable int64
+	DiskTotal       int64
+	DiskAvailable   int64
+	Sandbox         string
+	SandboxVersion  string
+	Architecture    string
+	WorkerVersion   string
+}
+
+// Sent in response to a heartbeat if the controller wants the worker to act
+type HeartbeatResponse struct {
+	CancelBuildIds []int64 // Running builds that were superseded or cancelled and should be aborted
+}
@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+const (
+	NAMCAP_SEVERITY_ERROR   = "error"
+	NAMCAP_SEVERITY_WARNING = "warning"
+	NAMCAP_SEVERITY_INFO    = "info"
+)
+
+// A finding of namcap in the PKGBUILD or a package of a build
+type NamcapFinding struct {
+	Id        int64
+	BuildId   int64     `xorm:"index"`
+	Target    string    // PKGBUILD or the file name of a package
+	Severity  string    // error, warning or info
+	Tag       string    // f.e. dependency-detected-not-included
+	Message   string    // Arguments of the tag
+	CreatedAt time.Time `xorm:"created"`
+}
+
+// Returns true if namcap found errors or warnings, infos are ignored
+func HasNamcapWarnings(findings []NamcapFinding) bool {
+	for _, finding := range findings {
+		if finding.Severity == NAMCAP_SEVERITY_ERROR || finding.Severity == NAMCAP_SEVERITY_WARNING {
+			return true
+		}
+	}
+	return false
+}
@@ -31,6 +31,8 @@ type Package struct {
 	Groups         []string  `json:"Groups"`
 	License        []string  `json:"License"`
 	Keywords       []string  `json:"Keywords"`
+	Removed        bool      `json:"Removed"` // No longer in the AUR, either deleted or merged into another package base
+	RemovedAt      time.Time `json:"RemovedAt"`
 }
 
 func NewPackageFromRPCPackage(pkg rpc.Pkg) Package {
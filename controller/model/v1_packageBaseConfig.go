@@ -0,0 +1,54 @@
+package model
+
+import "time"
+
+// Per package base overrides of the build defaults, f.e. for known heavyweight packages.
+// Zero values fall back to the defaults of the worker.
+type PackageBaseConfig struct {
+	PackageBase                string  `xorm:"pk notnull"`
+	CPUs                       float64 `xorm:"'cpus'"`
+	MemoryBytes                int64
+	PidsLimit                  int64
+	DiskBytes                  int64
+	TotalTimeoutSeconds        int
+	DependenciesTimeoutSeconds int
+	DownloadTimeoutSeconds     int
+	BuildTimeoutSeconds        int
+	CheckTimeoutSeconds        int
+	CheckReproducibility       bool // Enables the reproducibility check, even if it is disabled by default
+	VCSRebuildIntervalSeconds  int  `xorm:"'vcs_rebuild_interval_seconds'"` // Time between scheduled rebuilds of VCS packages
+	UpdatedBy                  string
+	UpdatedAt                  time.Time `xorm:"updated"`
+}
+
+func (c *PackageBaseConfig) GetResourceLimits() *ResourceLimits {
+	if c.CPUs == 0 && c.MemoryBytes == 0 && c.PidsLimit == 0 && c.DiskBytes == 0 {
+		return nil
+	}
+	return &ResourceLimits{
+		CPUs:        c.CPUs,
+		MemoryBytes: c.MemoryBytes,
+		PidsLimit:   c.PidsLimit,
+		DiskBytes:   c.DiskBytes,
+	}
+}
+
+// Returns the timeouts, overridden by the non-zero timeouts of this config
+func (c *PackageBaseConfig) ApplyTimeoutOverrides(timeouts Timeouts) Timeouts {
+	if c.TotalTimeoutSeconds > 0 {
+		timeouts.TotalSeconds = c.TotalTimeoutSeconds
+	}
+	if c.DependenciesTimeoutSeconds > 0 {
+		timeouts.DependenciesSeconds = c.DependenciesTimeoutSeconds
+	}
+	if c.DownloadTimeoutSeconds > 0 {
+		timeouts.DownloadSeconds = c.DownloadTimeoutSeconds
+	}
+	if c.BuildTimeoutSeconds > 0 {
+		timeouts.BuildSeconds = c.BuildTimeoutSeconds
+	}
+	if c.CheckTimeoutSeconds > 0 {
+		timeouts.CheckSeconds = c.CheckTimeoutSeconds
+	}
+	return timeouts
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// Packages of a package base that was deleted from the AUR moved to another package base,
+// f.e. because of a merge request or a rename. The history of the old package base belongs to the new one.
+type PackageBaseMerge struct {
+	Id                int64
+	PackageBase       string `xorm:"index"`
+	PackageBaseId     int64
+	IntoPackageBase   string    `xorm:"index"`
+	IntoPackageBaseId int64     `xorm:"index"`
+	PackageNames      []string  // That moved
+	CreatedAt         time.Time `xorm:"created"`
+}
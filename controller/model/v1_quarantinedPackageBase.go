@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// A package base the controller failed to process, f.e. because its repository can't be fetched.
+// It is processed again on its next modification or when an admin retries it.
+type QuarantinedPackageBase struct {
+	PackageBase   string `xorm:"pk notnull"`
+	PackageBaseId int64
+	Reason        string `xorm:"text"`
+	Attempts      int
+	CreatedAt     time.Time `xorm:"created"`
+	UpdatedAt     time.Time `xorm:"updated"`
+}
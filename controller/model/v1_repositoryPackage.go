@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// A package of the official repositories as of the last loaded sync databases.
+// Updates are compared with it to detect the ones that break AUR packages.
+type RepositoryPackage struct {
+	Name       string `xorm:"pk notnull"`
+	Repository string
+	Version    string // [epoch:]pkgver-pkgrel
+	Provides   []string
+	UpdatedAt  time.Time `xorm:"updated"`
+}
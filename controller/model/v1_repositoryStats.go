@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Storage usage and health of the git repository of a package base, updated by the git storage maintenance
+type RepositoryStats struct {
+	PackageBase  string `xorm:"pk notnull"`
+	SizeBytes    int64  `xorm:"index"`
+	Commits      int
+	Packs        int
+	Error        string    `xorm:"text"` // Of the last maintenance, f.e. missing or corrupt objects
+	MaintainedAt time.Time `xorm:"updated"`
+}
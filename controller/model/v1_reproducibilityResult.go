@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Result of building a commit twice and comparing the packages
+type ReproducibilityResult struct {
+	Id           int64
+	CommitId     int64 `xorm:"index"`
+	BuildId      int64 `xorm:"index"`
+	Reproducible bool
+	Differences  []string  `xorm:"text"` // f.e. "foo-1-1-x86_64.pkg.tar.zst: ./usr/bin/foo differs in sha256digest, size"
+	CreatedAt    time.Time `xorm:"created"`
+}
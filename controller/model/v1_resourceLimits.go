@@ -0,0 +1,9 @@
+package model
+
+// Resource limits of a single build. Zero values mean "no limit" or, in overrides, "use the default".
+type ResourceLimits struct {
+	CPUs        float64
+	MemoryBytes int64
+	PidsLimit   int64
+	DiskBytes   int64
+}
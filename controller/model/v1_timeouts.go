@@ -0,0 +1,10 @@
+package model
+
+// Timeouts of a build in seconds. Zero values mean "no step timeout" or, in overrides, "use the default".
+type Timeouts struct {
+	TotalSeconds        int
+	DependenciesSeconds int
+	DownloadSeconds     int
+	BuildSeconds        int
+	CheckSeconds        int
+}
@@ -5,31 +5,46 @@ import "time"
 type WorkResultStatus int8
 
 const (
-	WORK_RESULT_STATUS_INTERNAL_ERROR WorkResultStatus = 0
-	WORK_RESULT_STATUS_TIMEOUT        WorkResultStatus = 10
-	WORK_RESULT_STATUS_FAILED         WorkResultStatus = 20
-	WORK_RESULT_STATUS_SUCCESS        WorkResultStatus = 30
+	WORK_RESULT_STATUS_INTERNAL_ERROR   WorkResultStatus = 0
+	WORK_RESULT_STATUS_TIMEOUT          WorkResultStatus = 10
+	WORK_RESULT_STATUS_OUT_OF_MEMORY    WorkResultStatus = 15
+	WORK_RESULT_STATUS_FAILED           WorkResultStatus = 20
+	WORK_RESULT_STATUS_REQUIRES_NETWORK WorkResultStatus = 25 // Build failed without, but succeeded with network access
+	WORK_RESULT_STATUS_SUCCESS          WorkResultStatus = 30
 )
 
 type WorkResult struct {
-	Id                      int64
-	BuildId                 int64
-	Status                  WorkResultStatus
-	PacmanExitCode          int
-	PacmanLogBase64         string
-	MakepkgExtractExitCode  int
-	MakepkgExtractLogBase64 string
-	MakepkgBuildExitCode    int
-	MakepkgBuildLogBase64   string
-	CreatedAt               time.Time `xorm:"created"`
+	Id                           int64
+	BuildId                      int64
+	Status                       WorkResultStatus
+	PacmanExitCode               int
+	PacmanLogBase64              string
+	MakepkgExtractExitCode       int
+	MakepkgExtractLogBase64      string
+	MakepkgBuildExitCode         int
+	MakepkgBuildLogBase64        string
+	MakepkgBuildNetworkExitCode  int
+	MakepkgBuildNetworkLogBase64 string
+	TimedOutStep                 string // total, dependencies, download, build or check
+	ErrorCategory                string // timeout, out-of-memory, build, requires-network, docker-daemon, image, cancelled or internal
+	ErrorMessage                 string
+	NamcapFindings               []NamcapFinding        `xorm:"-"` // Stored in their own table
+	FilesystemChanges            []FilesystemChange     `xorm:"-"` // Stored in their own table
+	Reproducibility              *ReproducibilityResult `xorm:"-"` // Stored in its own table
+	Artifacts                    []Artifact             `xorm:"-"` // Stored in their own table
+	CreatedAt                    time.Time              `xorm:"created"`
 }
 
 func (r *WorkResult) GetBuildStatus() BuildStatus {
 	switch r.Status {
 	case WORK_RESULT_STATUS_TIMEOUT:
 		return STATUS_TIMEOUT
+	case WORK_RESULT_STATUS_OUT_OF_MEMORY:
+		return STATUS_OUT_OF_MEMORY
 	case WORK_RESULT_STATUS_FAILED:
 		return STATUS_FAILED
+	case WORK_RESULT_STATUS_REQUIRES_NETWORK:
+		return STATUS_REQUIRES_NETWORK
 	case WORK_RESULT_STATUS_SUCCESS:
 		return STATUS_BUILD
 	default:
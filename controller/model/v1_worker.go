@@ -21,15 +21,45 @@ const (
 )
 
 type Worker struct {
-	Id        int64
-	Type      WorkerType
-	Status    WorkerStatus
-	HetznerId int
-	Name      string
-	IPv4      string    `xorm:"'ipv4'"`
-	IPv6      string    `xorm:"'ipv6'"`
-	CreatedAt time.Time `xorm:"created"`
-	UpdatedAt time.Time `xorm:"updated"`
+	Id              int64
+	Type            WorkerType
+	Status          WorkerStatus
+	HetznerId       int
+	Name            string
+	IPv4            string `xorm:"'ipv4'"`
+	IPv6            string `xorm:"'ipv6'"`
+	FreeSlots       int
+	RunningBuildIds []int64
+	CPUCount        int `xorm:"'cpu_count'"`
+	LoadAverage     float64
+	MemoryTotal     int64
+	MemoryAvailable int64
+	DiskTotal       int64
+	DiskAvailable   int64
+	Sandbox         string
+	SandboxVersion  string
+	Architecture    string
+	WorkerVersion   string
+	HeartbeatAt     time.Time
+	CreatedAt       time.Time `xorm:"created"`
+	UpdatedAt       time.Time `xorm:"updated"`
+}
+
+// Applies the capacity and health information a worker sent with its heartbeat
+func (w *Worker) ApplyHeartbeat(heartbeat *Heartbeat) {
+	w.FreeSlots = heartbeat.FreeSlots
+	w.RunningBuildIds = heartbeat.RunningBuildIds
+	w.CPUCount = heartbeat.CPUCount
+	w.LoadAverage = heartbeat.LoadAverage
+	w.MemoryTotal = heartbeat.MemoryTotal
+	w.MemoryAvailable = heartbeat.MemoryAvailable
+	w.DiskTotal = heartbeat.DiskTotal
+	w.DiskAvailable = heartbeat.DiskAvailable
+	w.Sandbox = heartbeat.Sandbox
+	w.SandboxVersion = heartbeat.SandboxVersion
+	w.Architecture = heartbeat.Architecture
+	w.WorkerVersion = heartbeat.WorkerVersion
+	w.HeartbeatAt = time.Now()
 }
 
 func NewWorkerFromHetznerServer(server *hcloud.Server) Worker {
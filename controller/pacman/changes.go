@@ -0,0 +1,93 @@
+package pacman
+
+import (
+	"sort"
+	"strings"
+)
+
+// An update of an official repository package that likely breaks packages built against the previous version
+type BreakingChange struct {
+	Name       string
+	OldVersion string
+	NewVersion string
+	Sonames    []string // Provided libraries that changed, f.e. libfoo.so. Dependents may depend on them directly.
+	Reason     string
+}
+
+// Compares two states of the official repositories by package name. An update is breaking if a provided library
+// like libfoo.so=1-64 changed or went away, or if it is an interpreter whose major.minor version changed,
+// f.e. python 3.11 to 3.12. New and removed packages are not breaking changes.
+func FindBreakingChanges(oldPackages map[string]Package, newPackages map[string]Package, interpreters []string) []BreakingChange {
+	isInterpreter := make(map[string]struct{}, len(interpreters))
+	for _, interpreter := range interpreters {
+		isInterpreter[interpreter] = struct{}{}
+	}
+
+	var changes []BreakingChange
+	for name, newPackage := range newPackages {
+		oldPackage, ok := oldPackages[name]
+		if !ok || oldPackage.Version == newPackage.Version {
+			continue
+		}
+
+		change := BreakingChange{
+			Name:       name,
+			OldVersion: oldPackage.Version,
+			NewVersion: newPackage.Version,
+		}
+
+		var reasons []string
+		newSonames := getSonames(newPackage.Provides)
+		for soname, oldVersion := range getSonames(oldPackage.Provides) {
+			if newVersion, ok := newSonames[soname]; !ok || newVersion != oldVersion {
+				change.Sonames = append(change.Sonames, soname)
+			}
+		}
+		if len(change.Sonames) > 0 {
+			sort.Strings(change.Sonames)
+			reasons = append(reasons, "changed "+strings.Join(change.Sonames, ", "))
+		}
+
+		if _, ok := isInterpreter[name]; ok {
+			if oldMinor, newMinor := getMinorVersion(oldPackage.Version), getMinorVersion(newPackage.Version); oldMinor != newMinor {
+				reasons = append(reasons, "interpreter version changed from "+oldMinor+" to "+newMinor)
+			}
+		}
+
+		if len(reasons) > 0 {
+			change.Reason = strings.Join(reasons, ", ")
+			changes = append(changes, change)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// Returns the provided libraries and their versions, f.e. libfoo.so -> 1-64
+func getSonames(provides []string) map[string]string {
+	sonames := make(map[string]string)
+	for _, provision := range provides {
+		depend := ParseDepend(provision)
+		if strings.HasSuffix(depend.Name, ".so") {
+			sonames[depend.Name] = depend.Version
+		}
+	}
+	return sonames
+}
+
+// Returns the major.minor part of a [epoch:]pkgver-pkgrel version, including the epoch, f.e. 3.12 of 3.12.4-1
+func getMinorVersion(version string) string {
+	epoch := ""
+	if separator := strings.Index(version, ":"); separator >= 0 {
+		epoch, version = version[:separator+1], version[separator+1:]
+	}
+	if separator := strings.LastIndex(version, "-"); separator >= 0 {
+		version = version[:separator]
+	}
+	components := strings.SplitN(version, ".", 3)
+	if len(components) > 2 {
+		components = components[:2]
+	}
+	return epoch + strings.Join(components, ".")
+}
@@ -0,0 +1,126 @@
+package pacman
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindBreakingChanges(t *testing.T) {
+	interpreters := []string{"python", "perl"}
+
+	tests := []struct {
+		name       string
+		oldPackage *Package
+		newPackage *Package
+		expected   []BreakingChange
+	}{
+		{"soname version changed",
+			&Package{Name: "openssl", Version: "1.1.1.k-1", Provides: []string{"libssl.so=1.1-64", "libcrypto.so=1.1-64"}},
+			&Package{Name: "openssl", Version: "3.0.0-1", Provides: []string{"libssl.so=3-64", "libcrypto.so=3-64"}},
+			[]BreakingChange{{Name: "openssl", OldVersion: "1.1.1.k-1", NewVersion: "3.0.0-1",
+				Sonames: []string{"libcrypto.so", "libssl.so"}, Reason: "changed libcrypto.so, libssl.so"}}},
+		{"soname removed",
+			&Package{Name: "libfoo", Version: "1.0-1", Provides: []string{"libfoo.so=1-64", "libfoo-extra.so=1-64"}},
+			&Package{Name: "libfoo", Version: "1.1-1", Provides: []string{"libfoo.so=1-64"}},
+			[]BreakingChange{{Name: "libfoo", OldVersion: "1.0-1", NewVersion: "1.1-1",
+				Sonames: []string{"libfoo-extra.so"}, Reason: "changed libfoo-extra.so"}}},
+		{"soname unchanged",
+			&Package{Name: "libfoo", Version: "1.0-1", Provides: []string{"libfoo.so=1-64", "foo"}},
+			&Package{Name: "libfoo", Version: "1.0-2", Provides: []string{"libfoo.so=1-64"}},
+			nil},
+		{"interpreter minor version changed",
+			&Package{Name: "python", Version: "3.11.9-1", Provides: []string{"python3"}},
+			&Package{Name: "python", Version: "3.12.4-1", Provides: []string{"python3"}},
+			[]BreakingChange{{Name: "python", OldVersion: "3.11.9-1", NewVersion: "3.12.4-1",
+				Reason: "interpreter version changed from 3.11 to 3.12"}}},
+		{"interpreter patch version changed",
+			&Package{Name: "python", Version: "3.12.3-1"},
+			&Package{Name: "python", Version: "3.12.4-2"},
+			nil},
+		{"interpreter epoch changed",
+			&Package{Name: "perl", Version: "5.34.0-1"},
+			&Package{Name: "perl", Version: "1:5.34.0-1"},
+			[]BreakingChange{{Name: "perl", OldVersion: "5.34.0-1", NewVersion: "1:5.34.0-1",
+				Reason: "interpreter version changed from 5.34 to 1:5.34"}}},
+		{"other package minor version changed",
+			&Package{Name: "ruby", Version: "3.0.1-1"},
+			&Package{Name: "ruby", Version: "3.1.0-1"},
+			nil},
+		{"new package",
+			nil,
+			&Package{Name: "libbar", Version: "1.0-1", Provides: []string{"libbar.so=1-64"}},
+			nil},
+		{"removed package",
+			&Package{Name: "libbar", Version: "1.0-1", Provides: []string{"libbar.so=1-64"}},
+			nil,
+			nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldPackages := make(map[string]Package)
+			if test.oldPackage != nil {
+				oldPackages[test.oldPackage.Name] = *test.oldPackage
+			}
+			newPackages := make(map[string]Package)
+			if test.newPackage != nil {
+				newPackages[test.newPackage.Name] = *test.newPackage
+			}
+
+			if changes := FindBreakingChanges(oldPackages, newPackages, interpreters); !reflect.DeepEqual(changes, test.expected) {
+				t.Errorf("FindBreakingChanges() = %+v, want %+v", changes, test.expected)
+			}
+		})
+	}
+}
+
+func TestFindBreakingChangesOfDatabases(t *testing.T) {
+	oldPackages := make(map[string]Package)
+	for _, pkg := range loadTestDatabase(t, "core").Packages {
+		oldPackages[pkg.Name] = pkg
+	}
+	updated, err := LoadDatabase("core", "testdata/sync-updated/core.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPackages := make(map[string]Package)
+	for _, pkg := range updated.Packages {
+		newPackages[pkg.Name] = pkg
+	}
+
+	// bash got a patch release only
+	var names []string
+	for _, change := range FindBreakingChanges(oldPackages, newPackages, []string{"python"}) {
+		names = append(names, change.Name)
+	}
+	if expected := []string{"openssl", "python"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Breaking changes of %v, want %v", names, expected)
+	}
+}
+
+func TestGetSonames(t *testing.T) {
+	sonames := getSonames([]string{"libssl.so=1.1-64", "libfoo.so", "sh", "java-runtime=11", "libbar.so.1"})
+	if expected := map[string]string{"libssl.so": "1.1-64", "libfoo.so": ""}; !reflect.DeepEqual(sonames, expected) {
+		t.Errorf("getSonames() = %v, want %v", sonames, expected)
+	}
+}
+
+func TestGetMinorVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected string
+	}{
+		{"3.12.4-1", "3.12"},
+		{"3.12-1", "3.12"},
+		{"5.34.0", "5.34"},
+		{"1:5.34.0-1", "1:5.34"},
+		{"2-1", "2"},
+		{"3.0.0.rc1-1", "3.0"},
+	}
+
+	for _, test := range tests {
+		if minor := getMinorVersion(test.version); minor != test.expected {
+			t.Errorf("getMinorVersion(%q) = %q, want %q", test.version, minor, test.expected)
+		}
+	}
+}
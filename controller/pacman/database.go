@@ -0,0 +1,170 @@
+package pacman
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// A package of a sync database or the AUR
+type Package struct {
+	Name     string
+	Version  string // [epoch:]pkgver-pkgrel
+	Provides []string
+}
+
+// Finds packages that satisfy a dependency
+type PackageSource interface {
+	FindSatisfiers(depend Depend) ([]Package, error)
+}
+
+// A sync database of an official repository, f.e. core.db
+type Database struct {
+	Name      string
+	Packages  []Package // In the order of the database
+	byName    map[string]int
+	providers map[string][]int // Provided name -> packages
+}
+
+var errUnsupportedCompression = errors.New("Unsupported database compression, only gzip and uncompressed databases are supported")
+
+// Loads a sync database file, f.e. /var/lib/pacman/sync/core.db
+func LoadDatabase(name string, filePath string) (*Database, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadDatabase(name, file)
+}
+
+// Reads a sync database, a tar archive with a desc file per package
+func ReadDatabase(name string, reader io.Reader) (*Database, error) {
+	bufferedReader := bufio.NewReader(reader)
+	magic, err := bufferedReader.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var archiveReader io.Reader = bufferedReader
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gzipReader, err := gzip.NewReader(bufferedReader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		archiveReader = gzipReader
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}), bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X'}):
+		return nil, errUnsupportedCompression
+	}
+
+	database := Database{
+		Name:      name,
+		byName:    make(map[string]int),
+		providers: make(map[string][]int),
+	}
+
+	// Entry directory -> package
+	entries := make(map[string]int)
+	tarReader := tar.NewReader(archiveReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Older databases store provisions in a separate depends file
+		if header.Typeflag != tar.TypeReg || (path.Base(header.Name) != "desc" && path.Base(header.Name) != "depends") {
+			continue
+		}
+
+		fields, err := parseDescFile(tarReader)
+		if err != nil {
+			return nil, err
+		}
+		database.addEntry(entries, path.Dir(header.Name), fields)
+	}
+
+	for i, pkg := range database.Packages {
+		database.byName[pkg.Name] = i
+		for _, provides := range pkg.Provides {
+			providedName := ParseDepend(provides).Name
+			database.providers[providedName] = append(database.providers[providedName], i)
+		}
+	}
+
+	return &database, nil
+}
+
+// Merges the fields of a desc or depends file into the package of the entry directory, f.e. bash-5.1.008-1
+func (d *Database) addEntry(entries map[string]int, entry string, fields map[string][]string) {
+	i, ok := entries[entry]
+	if !ok {
+		i = len(d.Packages)
+		entries[entry] = i
+		d.Packages = append(d.Packages, Package{})
+	}
+	pkg := &d.Packages[i]
+
+	if len(fields["NAME"]) > 0 {
+		pkg.Name = fields["NAME"][0]
+	}
+	if len(fields["VERSION"]) > 0 {
+		pkg.Version = fields["VERSION"][0]
+	}
+	pkg.Provides = append(pkg.Provides, fields["PROVIDES"]...)
+}
+
+// Parses the %KEY% sections of a desc file, values are separated by newlines and sections by empty lines
+func parseDescFile(reader io.Reader) (map[string][]string, error) {
+	fields := make(map[string][]string)
+	key := ""
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0:
+			key = ""
+		case len(key) == 0 && len(line) > 2 && strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%"):
+			key = strings.Trim(line, "%")
+		case len(key) > 0:
+			fields[key] = append(fields[key], line)
+		}
+	}
+	return fields, scanner.Err()
+}
+
+// Returns the package of a name
+func (d *Database) GetPackage(name string) (Package, bool) {
+	i, ok := d.byName[name]
+	if !ok {
+		return Package{}, false
+	}
+	return d.Packages[i], true
+}
+
+// Returns the package of the dependency name if it satisfies the dependency, otherwise all satisfying providers,
+// in the order of the database
+func (d *Database) FindSatisfiers(depend Depend) ([]Package, error) {
+	if pkg, ok := d.GetPackage(depend.Name); ok && depend.SatisfiedBy(pkg) {
+		return []Package{pkg}, nil
+	}
+
+	var satisfiers []Package
+	for _, i := range d.providers[depend.Name] {
+		if depend.SatisfiedBy(d.Packages[i]) {
+			satisfiers = append(satisfiers, d.Packages[i])
+		}
+	}
+	return satisfiers, nil
+}
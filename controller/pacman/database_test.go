@@ -0,0 +1,93 @@
+package pacman
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Sync databases of the fixtures, in the order of pacman.conf
+var testRepositories = []string{"core", "extra", "community"}
+
+func loadTestDatabase(t *testing.T, name string) *Database {
+	database, err := LoadDatabase(name, filepath.Join("testdata", "sync", name+".db"))
+	if err != nil {
+		t.Fatalf("Failed to load %s.db: %s", name, err)
+	}
+	return database
+}
+
+func TestLoadDatabase(t *testing.T) {
+	core := loadTestDatabase(t, "core")
+	if len(core.Packages) != 5 {
+		t.Errorf("core has %d packages, want 5", len(core.Packages))
+	}
+
+	tests := []struct {
+		repository string
+		expected   Package
+	}{
+		{"core", Package{Name: "bash", Version: "5.1.008-1", Provides: []string{"sh"}}},
+		{"core", Package{Name: "zlib", Version: "1:1.2.11-4"}},
+		{"core", Package{Name: "openssl", Version: "1.1.1.k-1", Provides: []string{"libssl.so=1.1-64", "libcrypto.so=1.1-64"}}},
+		{"extra", Package{Name: "jre11-openjdk", Version: "11.0.11.u9-1", Provides: []string{"java-runtime=11"}}},
+		// Uncompressed, the provisions are in a separate depends file
+		{"community", Package{Name: "nodejs", Version: "16.0.0-1", Provides: []string{"nodejs-lts=16"}}},
+	}
+
+	for _, test := range tests {
+		pkg, ok := loadTestDatabase(t, test.repository).GetPackage(test.expected.Name)
+		if !ok {
+			t.Errorf("%s is missing in %s", test.expected.Name, test.repository)
+			continue
+		}
+		if !reflect.DeepEqual(pkg, test.expected) {
+			t.Errorf("%s of %s = %+v, want %+v", test.expected.Name, test.repository, pkg, test.expected)
+		}
+	}
+
+	if _, ok := core.GetPackage("sh"); ok {
+		t.Error("GetPackage returned a provider")
+	}
+}
+
+func TestReadDatabaseUnsupportedCompression(t *testing.T) {
+	zstdMagic := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}
+	if _, err := ReadDatabase("core", bytes.NewReader(zstdMagic)); err != errUnsupportedCompression {
+		t.Errorf("ReadDatabase() = %v, want %v", err, errUnsupportedCompression)
+	}
+}
+
+func TestFindSatisfiers(t *testing.T) {
+	extra := loadTestDatabase(t, "extra")
+
+	tests := []struct {
+		depend   string
+		expected []string
+	}{
+		// In the order of the database
+		{"java-runtime", []string{"jre-openjdk", "jre11-openjdk"}},
+		{"java-runtime>=11", []string{"jre-openjdk", "jre11-openjdk"}},
+		{"java-runtime>11", []string{"jre-openjdk"}},
+		{"java-runtime=11", []string{"jre11-openjdk"}},
+		{"java-runtime<11", nil},
+		{"qt5-base>5.15.2", []string{"qt5-base"}},
+		{"qt5-base>=5.15.3", nil},
+		{"bash", []string{"bash-completion"}},
+	}
+
+	for _, test := range tests {
+		satisfiers, err := extra.FindSatisfiers(ParseDepend(test.depend))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, satisfier := range satisfiers {
+			names = append(names, satisfier.Name)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("FindSatisfiers(%s) = %v, want %v", test.depend, names, test.expected)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package pacman
+
+import (
+	"strings"
+)
+
+// Version constraint operators of dependencies, f.e. foo>=1.2
+const (
+	OPERATOR_ANY           = ""
+	OPERATOR_EQUAL         = "="
+	OPERATOR_GREATER_EQUAL = ">="
+	OPERATOR_LESS_EQUAL    = "<="
+	OPERATOR_GREATER       = ">"
+	OPERATOR_LESS          = "<"
+)
+
+// A dependency or provision like foo, foo>=1.2 or libfoo.so=1-64
+type Depend struct {
+	Name     string
+	Operator string
+	Version  string
+}
+
+// Parses a dependency like alpm_dep_from_string does. Descriptions of optional dependencies are removed.
+func ParseDepend(depend string) Depend {
+	if separator := strings.Index(depend, ": "); separator >= 0 {
+		depend = depend[:separator]
+	}
+	depend = strings.TrimSpace(depend)
+
+	operatorIndex := strings.IndexAny(depend, "<>=")
+	if operatorIndex < 0 {
+		return Depend{Name: depend}
+	}
+
+	operator := depend[operatorIndex : operatorIndex+1]
+	if operatorIndex+1 < len(depend) && depend[operatorIndex+1] == '=' && operator != OPERATOR_EQUAL {
+		operator += "="
+	}
+
+	return Depend{
+		Name:     depend[:operatorIndex],
+		Operator: operator,
+		Version:  depend[operatorIndex+len(operator):],
+	}
+}
+
+func (d Depend) String() string {
+	return d.Name + d.Operator + d.Version
+}
+
+// Returns true if the version fulfils the version constraint of the dependency
+func (d Depend) satisfiesVersion(version string) bool {
+	if d.Operator == OPERATOR_ANY {
+		return true
+	}
+	result := Vercmp(version, d.Version)
+	switch d.Operator {
+	case OPERATOR_EQUAL:
+		return result == 0
+	case OPERATOR_GREATER_EQUAL:
+		return result >= 0
+	case OPERATOR_LESS_EQUAL:
+		return result <= 0
+	case OPERATOR_GREATER:
+		return result > 0
+	case OPERATOR_LESS:
+		return result < 0
+	}
+	return false
+}
+
+// Returns true if a package satisfies the dependency, either by its name and version or by one of its provisions.
+// Provisions without a version only satisfy dependencies without a version constraint.
+func (d Depend) SatisfiedBy(pkg Package) bool {
+	if pkg.Name == d.Name && d.satisfiesVersion(pkg.Version) {
+		return true
+	}
+	for _, provides := range pkg.Provides {
+		provision := ParseDepend(provides)
+		if provision.Name != d.Name {
+			continue
+		}
+		if d.Operator == OPERATOR_ANY {
+			return true
+		}
+		if provision.Operator == OPERATOR_EQUAL && d.satisfiesVersion(provision.Version) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package pacman
+
+import "testing"
+
+func TestParseDepend(t *testing.T) {
+	tests := []struct {
+		depend   string
+		expected Depend
+	}{
+		{"foo", Depend{Name: "foo"}},
+		{"foo=1.2-3", Depend{Name: "foo", Operator: OPERATOR_EQUAL, Version: "1.2-3"}},
+		{"foo>=1.2", Depend{Name: "foo", Operator: OPERATOR_GREATER_EQUAL, Version: "1.2"}},
+		{"foo<=1.2", Depend{Name: "foo", Operator: OPERATOR_LESS_EQUAL, Version: "1.2"}},
+		{"foo>1:1.2", Depend{Name: "foo", Operator: OPERATOR_GREATER, Version: "1:1.2"}},
+		{"foo<1.2", Depend{Name: "foo", Operator: OPERATOR_LESS, Version: "1.2"}},
+		{"libfoo.so=1-64", Depend{Name: "libfoo.so", Operator: OPERATOR_EQUAL, Version: "1-64"}},
+		{"python-foo: for the foo plugin", Depend{Name: "python-foo"}},
+		{"foo>=1.2: needs the new API", Depend{Name: "foo", Operator: OPERATOR_GREATER_EQUAL, Version: "1.2"}},
+		{" foo ", Depend{Name: "foo"}},
+		{"", Depend{}},
+	}
+
+	for _, test := range tests {
+		depend := ParseDepend(test.depend)
+		if depend != test.expected {
+			t.Errorf("ParseDepend(%q) = %+v, want %+v", test.depend, depend, test.expected)
+		}
+	}
+}
+
+func TestDependString(t *testing.T) {
+	for _, depend := range []string{"foo", "foo>=1.2", "foo<1:2", "libfoo.so=1-64"} {
+		if parsed := ParseDepend(depend).String(); parsed != depend {
+			t.Errorf("ParseDepend(%q).String() = %q", depend, parsed)
+		}
+	}
+}
+
+func TestSatisfiedBy(t *testing.T) {
+	foo := Package{Name: "foo", Version: "1.2-1", Provides: []string{"libfoo.so=1-64", "bar", "baz>=2"}}
+
+	tests := []struct {
+		depend   string
+		expected bool
+	}{
+		{"foo", true},
+		{"foo=1.2", true},
+		{"foo=1.2-1", true},
+		{"foo=1.2-2", false},
+		{"foo>=1.2", true},
+		{"foo>1.2", false},
+		{"foo<1.10", true},
+		{"foo<=1.1", false},
+		{"foo>=1:1.0", false},
+		// Versioned provisions satisfy versioned dependencies
+		{"libfoo.so", true},
+		{"libfoo.so=1-64", true},
+		{"libfoo.so>=1", true},
+		{"libfoo.so=2-64", false},
+		// Unversioned provisions only satisfy unversioned dependencies
+		{"bar", true},
+		{"bar>=1", false},
+		// Like pacman, only provisions with = have a version
+		{"baz", true},
+		{"baz>=2", false},
+		{"qux", false},
+	}
+
+	for _, test := range tests {
+		if satisfied := ParseDepend(test.depend).SatisfiedBy(foo); satisfied != test.expected {
+			t.Errorf("%s satisfied by %s %s = %t, want %t", test.depend, foo.Name, foo.Version, satisfied, test.expected)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package pacman
+
+// Result of the dependency resolution
+type Resolution struct {
+	RepositoryPackages []string // Concrete names of official repository packages, can be installed with pacman -S
+	AURPackages        []string // Concrete names of AUR packages that satisfy dependencies
+	Unsatisfiable      []string // Dependencies nothing satisfies, f.e. foo>=2 if only foo 1 exists
+}
+
+// Resolves dependencies like foo>=1.2 or sh to concrete package names, like pacman does:
+// Packages named like the dependency win over providers and repositories are searched in order.
+// Dependencies no repository satisfies are searched in the AUR, which may be nil.
+func Resolve(depends []string, repositories []PackageSource, aur PackageSource) (Resolution, error) {
+	var resolution Resolution
+	seen := make(map[string]struct{})
+	add := func(names *[]string, name string) {
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			*names = append(*names, name)
+		}
+	}
+
+	for _, dependString := range depends {
+		depend := ParseDepend(dependString)
+		if len(depend.Name) == 0 {
+			continue
+		}
+
+		satisfier, err := findSatisfier(depend, repositories)
+		if err != nil {
+			return resolution, err
+		}
+		if len(satisfier) > 0 {
+			add(&resolution.RepositoryPackages, satisfier)
+			continue
+		}
+
+		if aur != nil {
+			satisfier, err = findSatisfier(depend, []PackageSource{aur})
+			if err != nil {
+				return resolution, err
+			}
+			if len(satisfier) > 0 {
+				add(&resolution.AURPackages, satisfier)
+				continue
+			}
+		}
+
+		resolution.Unsatisfiable = append(resolution.Unsatisfiable, depend.String())
+	}
+
+	return resolution, nil
+}
+
+// Returns the name of the first package that satisfies the dependency, packages named like the dependency win.
+// Returns an empty string if there is none.
+func findSatisfier(depend Depend, sources []PackageSource) (string, error) {
+	var providers []Package
+	for _, source := range sources {
+		satisfiers, err := source.FindSatisfiers(depend)
+		if err != nil {
+			return "", err
+		}
+		for _, satisfier := range satisfiers {
+			if satisfier.Name == depend.Name {
+				return satisfier.Name, nil
+			}
+		}
+		providers = append(providers, satisfiers...)
+	}
+	if len(providers) > 0 {
+		return providers[0].Name, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,88 @@
+package pacman
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Packages of the AUR, in the order the AUR source of the controller returns them
+type testPackageSource []Package
+
+func (s testPackageSource) FindSatisfiers(depend Depend) ([]Package, error) {
+	var satisfiers []Package
+	for _, pkg := range s {
+		if depend.SatisfiedBy(pkg) {
+			satisfiers = append(satisfiers, pkg)
+		}
+	}
+	return satisfiers, nil
+}
+
+func loadTestRepositories(t *testing.T) []PackageSource {
+	var repositories []PackageSource
+	for _, name := range testRepositories {
+		repositories = append(repositories, loadTestDatabase(t, name))
+	}
+	return repositories
+}
+
+func TestResolve(t *testing.T) {
+	repositories := loadTestRepositories(t)
+	aur := testPackageSource{
+		{Name: "foo-git", Version: "r12.abcdef-1", Provides: []string{"foo=1.2"}},
+		{Name: "python-bar", Version: "2.0-1"},
+		{Name: "python-bar-git", Version: "2.1.r3-1", Provides: []string{"python-bar=2.1"}},
+		{Name: "jre17-openjdk-bin", Version: "17.0.0-1", Provides: []string{"java-runtime=17"}},
+	}
+
+	tests := []struct {
+		name     string
+		depends  []string
+		expected Resolution
+	}{
+		{"names", []string{"glibc", "yarn"}, Resolution{RepositoryPackages: []string{"glibc", "yarn"}}},
+		{"provider", []string{"sh"}, Resolution{RepositoryPackages: []string{"bash"}}},
+		// bash-completion of extra provides bash as well
+		{"name wins over provider", []string{"bash"}, Resolution{RepositoryPackages: []string{"bash"}}},
+		{"first provider", []string{"java-runtime"}, Resolution{RepositoryPackages: []string{"jre-openjdk"}}},
+		{"versioned provider", []string{"java-runtime=11", "java-runtime>=12"}, Resolution{RepositoryPackages: []string{"jre11-openjdk", "jre-openjdk"}}},
+		{"repositories win over the AUR", []string{"java-runtime>=16"}, Resolution{RepositoryPackages: []string{"jre-openjdk"}}},
+		{"versioned provider of the AUR", []string{"java-runtime>=17"}, Resolution{AURPackages: []string{"jre17-openjdk-bin"}}},
+		{"epoch", []string{"zlib>=1.2.12", "zlib<2:1"}, Resolution{RepositoryPackages: []string{"zlib"}}},
+		{"soname", []string{"libssl.so=1.1-64"}, Resolution{RepositoryPackages: []string{"openssl"}}},
+		{"provision of a depends file", []string{"nodejs-lts>=16"}, Resolution{RepositoryPackages: []string{"nodejs"}}},
+		{"optional dependency description", []string{"python: for the scripts"}, Resolution{RepositoryPackages: []string{"python"}}},
+		{"AUR provider", []string{"foo>=1"}, Resolution{AURPackages: []string{"foo-git"}}},
+		{"AUR name wins over provider", []string{"python-bar"}, Resolution{AURPackages: []string{"python-bar"}}},
+		{"AUR versioned provider", []string{"python-bar>=2.1"}, Resolution{AURPackages: []string{"python-bar-git"}}},
+		{"duplicates", []string{"sh", "bash", "foo", "foo-git"}, Resolution{RepositoryPackages: []string{"bash"}, AURPackages: []string{"foo-git"}}},
+		{"version too new", []string{"python>=3.10"}, Resolution{Unsatisfiable: []string{"python>=3.10"}}},
+		{"version too old", []string{"qt5-base<5"}, Resolution{Unsatisfiable: []string{"qt5-base<5"}}},
+		// Only provisions with = have a version
+		{"unversioned provision", []string{"python3>=3"}, Resolution{Unsatisfiable: []string{"python3>=3"}}},
+		{"missing", []string{"glibc", "does-not-exist", "java-runtime<8"}, Resolution{RepositoryPackages: []string{"glibc"}, Unsatisfiable: []string{"does-not-exist", "java-runtime<8"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolution, err := Resolve(test.depends, repositories, aur)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resolution, test.expected) {
+				t.Errorf("Resolve(%v) = %+v, want %+v", test.depends, resolution, test.expected)
+			}
+		})
+	}
+}
+
+func TestResolveWithoutAUR(t *testing.T) {
+	resolution, err := Resolve([]string{"sh", "foo"}, loadTestRepositories(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Resolution{RepositoryPackages: []string{"bash"}, Unsatisfiable: []string{"foo"}}
+	if !reflect.DeepEqual(resolution, expected) {
+		t.Errorf("Resolve() = %+v, want %+v", resolution, expected)
+	}
+}
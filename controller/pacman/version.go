@@ -0,0 +1,152 @@
+package pacman
+
+import (
+	"strings"
+)
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || isAlpha(c)
+}
+
+// Compares two versions like vercmp(8) and alpm_pkg_vercmp do: [epoch:]pkgver[-pkgrel].
+// Returns -1 if a is older than b, 0 if they are equal and 1 if a is newer than b.
+// The release is only compared if both versions have one.
+func Vercmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+
+	epochA, versionA, releaseA := parseEVR(a)
+	epochB, versionB, releaseB := parseEVR(b)
+
+	result := rpmvercmp(epochA, epochB)
+	if result == 0 {
+		result = rpmvercmp(versionA, versionB)
+		if result == 0 && len(releaseA) > 0 && len(releaseB) > 0 {
+			result = rpmvercmp(releaseA, releaseB)
+		}
+	}
+	return result
+}
+
+// Splits [epoch:]pkgver[-pkgrel], the epoch defaults to 0
+func parseEVR(evr string) (string, string, string) {
+	digits := 0
+	for digits < len(evr) && isDigit(evr[digits]) {
+		digits++
+	}
+
+	epoch := "0"
+	version := evr
+	if digits < len(evr) && evr[digits] == ':' {
+		if digits > 0 {
+			epoch = evr[:digits]
+		}
+		version = evr[digits+1:]
+	}
+
+	release := ""
+	if separator := strings.LastIndexByte(version, '-'); separator >= 0 {
+		release = version[separator+1:]
+		version = version[:separator]
+	}
+
+	return epoch, version, release
+}
+
+// Port of rpmvercmp of libalpm. Compares alternating numeric and alphabetic segments of two versions.
+func rpmvercmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+
+	one, two := 0, 0
+	segmentEnd1, segmentEnd2 := 0, 0
+
+	for one < len(a) && two < len(b) {
+		for one < len(a) && !isAlnum(a[one]) {
+			one++
+		}
+		for two < len(b) && !isAlnum(b[two]) {
+			two++
+		}
+
+		if one >= len(a) || two >= len(b) {
+			break
+		}
+
+		// If the separator lengths differ, we are finished
+		if one-segmentEnd1 != two-segmentEnd2 {
+			if one-segmentEnd1 < two-segmentEnd2 {
+				return -1
+			}
+			return 1
+		}
+
+		segmentEnd1, segmentEnd2 = one, two
+		isNumeric := isDigit(a[one])
+		if isNumeric {
+			for segmentEnd1 < len(a) && isDigit(a[segmentEnd1]) {
+				segmentEnd1++
+			}
+			for segmentEnd2 < len(b) && isDigit(b[segmentEnd2]) {
+				segmentEnd2++
+			}
+		} else {
+			for segmentEnd1 < len(a) && isAlpha(a[segmentEnd1]) {
+				segmentEnd1++
+			}
+			for segmentEnd2 < len(b) && isAlpha(b[segmentEnd2]) {
+				segmentEnd2++
+			}
+		}
+
+		// Segments of different types, numeric ones are newer
+		if two == segmentEnd2 {
+			if isNumeric {
+				return 1
+			}
+			return -1
+		}
+
+		segment1 := a[one:segmentEnd1]
+		segment2 := b[two:segmentEnd2]
+
+		if isNumeric {
+			segment1 = strings.TrimLeft(segment1, "0")
+			segment2 = strings.TrimLeft(segment2, "0")
+			// The longer number is larger
+			if len(segment1) > len(segment2) {
+				return 1
+			}
+			if len(segment2) > len(segment1) {
+				return -1
+			}
+		}
+
+		if result := strings.Compare(segment1, segment2); result != 0 {
+			return result
+		}
+
+		one, two = segmentEnd1, segmentEnd2
+	}
+
+	if one >= len(a) && two >= len(b) {
+		return 0
+	}
+
+	// A remaining alphabetic segment never beats an empty string:
+	// If a is empty and b is not alphabetic or a is alphabetic, b is newer. Otherwise a is newer.
+	if (one >= len(a) && !isAlpha(b[two])) || (one < len(a) && isAlpha(a[one])) {
+		return -1
+	}
+	return 1
+}
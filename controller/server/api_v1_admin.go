@@ -0,0 +1,384 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// Maximum amount of package bases a bulk build may trigger
+const MAX_BULK_BUILD_PACKAGE_BASES = 5000
+
+// @Summary Get the build configuration overrides of a package base
+// @Produce json
+// @Success 200 {object} model.PackageBaseConfig
+// @Failure 401
+// @Failure 404
+// @Param packageBase path string true "Package base"
+// @Router /v1/admin/packageBaseConfig/{packageBase} [get]
+// @Security AdminToken
+// @Tags Admin
+func (s *Server) apiV1AdminGetPackageBaseConfig(c *gin.Context) {
+	config := model.PackageBaseConfig{
+		PackageBase: c.Param("packageBase"),
+	}
+	configExists, err := s.DB.Get(&config)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get package base config from database: "+err.Error()))
+		return
+	}
+	if !configExists {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+// @Summary Set the build configuration overrides of a package base
+// @Description Zero values fall back to the defaults of the worker.
+// @Success 204
+// @Failure 400
+// @Failure 401
+// @Accept json
+// @Param packageBase path string true "Package base"
+// @Param config body model.PackageBaseConfig true "Overrides, the package base field is ignored"
+// @Router /v1/admin/packageBaseConfig/{packageBase} [put]
+// @Security AdminToken
+// @Tags Admin
+func (s *Server) apiV1AdminPutPackageBaseConfig(c *gin.Context) {
+	var config model.PackageBaseConfig
+	if err := c.BindJSON(&config); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if config.CPUs < 0 || config.MemoryBytes < 0 || config.PidsLimit < 0 || config.DiskBytes < 0 {
+		c.AbortWithError(http.StatusBadRequest, errors.New("Negative limits are not allowed"))
+		return
+	}
+	if config.TotalTimeoutSeconds < 0 || config.DependenciesTimeoutSeconds < 0 || config.DownloadTimeoutSeconds < 0 ||
+		config.BuildTimeoutSeconds < 0 || config.CheckTimeoutSeconds < 0 {
+		c.AbortWithError(http.StatusBadRequest, errors.New("Negative timeouts are not allowed"))
+		return
+	}
+	if config.VCSRebuildIntervalSeconds < 0 {
+		c.AbortWithError(http.StatusBadRequest, errors.New("Negative intervals are not allowed"))
+		return
+	}
+
+	config.PackageBase = c.Param("packageBase")
+	config.UpdatedBy = c.GetString(ADMIN_NAME_CONTEXT_KEY)
+
+	// Clearing a limit requires writing zero values. AllCols would apply to a condition bean as well.
+	updateCount, err := s.DB.AllCols().Where("package_base = ?", config.PackageBase).Update(&config)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to update package base config in database: "+err.Error()))
+		return
+	}
+	if updateCount == 0 {
+		if _, err := s.DB.Insert(&config); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to insert package base config into database: "+err.Error()))
+			return
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Remove the build configuration overrides of a package base
+// @Success 204
+// @Failure 401
+// @Param packageBase path string true "Package base"
+// @Router /v1/admin/packageBaseConfig/{packageBase} [delete]
+// @Security AdminToken
+// @Tags Admin
+func (s *Server) apiV1AdminDeletePackageBaseConfig(c *gin.Context) {
+	if _, err := s.DB.Delete(&model.PackageBaseConfig{PackageBase: c.Param("packageBase")}); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to delete package base config from database: "+err.Error()))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary List package bases the controller failed to process
+// @Description They are processed again on their next modification or when retried.
+// @Produce json
+// @Success 200 {array} model.QuarantinedPackageBase
+// @Failure 401
+// @Router /v1/admin/quarantine [get]
+// @Security AdminToken
+// @Tags Admin
+func (s *Server) apiV1AdminGetQuarantine(c *gin.Context) {
+	quarantined := make([]model.QuarantinedPackageBase, 0)
+	if err := s.DB.Desc("updated_at").Find(&quarantined); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get quarantined package bases from database: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, quarantined)
+}
+
+// @Summary Process a quarantined package base again
+// @Description Releases it from quarantine on success. Otherwise it stays quarantined with the new reason.
+// @Success 204
+// @Failure 401
+// @Failure 404 Package base isn't quarantined
+// @Failure 409 Processing failed again
+// @Param packageBase path string true "Package base"
+// @Router /v1/admin/quarantine/{packageBase}/retry [post]
+// @Security AdminToken
+// @Tags Admin
+func (s *Server) apiV1AdminRetryQuarantined(c *gin.Context) {
+	quarantined := model.QuarantinedPackageBase{
+		PackageBase: c.Param("packageBase"),
+	}
+	exists, err := s.DB.Get(&quarantined)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get quarantined package base from database: "+err.Error()))
+		return
+	}
+	if !exists {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if err := s.processOrQuarantinePackageBase(quarantined.PackageBase, quarantined.PackageBaseId); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	stillQuarantined, err := s.DB.Exist(&model.QuarantinedPackageBase{PackageBase: quarantined.PackageBase})
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get quarantined package base from database: "+err.Error()))
+		return
+	}
+	if stillQuarantined {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Build a package base
+// @Description Builds the latest commit or the given one. The admin and the reason are recorded on the build.
+// @Accept json
+// @Produce json
+// @Success 201 {object} model.Build
+// @Failure 400 Reason is missing
+// @Failure 401
+// @Failure 404 Package base or commit not found
+// @Failure 409 Commit has a pending build already
+// @Param packageBase path string true "Package base"
+// @Param trigger body model.BuildTrigger true "Commit and reason"
+// @Router /v1/admin/packageBase/{packageBase}/build [post]
+// @Security AdminToken
+// @Tags Admin
+func (s *Server) apiV1AdminTriggerBuild(c *gin.Context) {
+	trigger, ok := bindBuildTrigger(c)
+	if !ok {
+		return
+	}
+
+	packageBase := c.Param("packageBase")
+	packageBaseId, exists, err := s.getPackageBaseId(packageBase)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get package base from database: "+err.Error()))
+		return
+	}
+	if !exists {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	var commit model.Commit
+	if len(trigger.CommitHash) > 0 {
+		exists, err = s.DB.Cols("id").Where("package_base_id = ? AND hash = ?", packageBaseId, trigger.CommitHash).Get(&commit)
+	} else {
+		exists, err = s.getLastCommitOfPackageBaseId(packageBaseId).Cols("id").Get(&commit)
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get commit from database: "+err.Error()))
+		return
+	}
+	if !exists {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	build := model.Build{
+		PackageBase:   packageBase,
+		PackageBaseId: packageBaseId,
+		CommitId:      commit.Id,
+		TriggeredBy:   c.GetString(ADMIN_NAME_CONTEXT_KEY),
+		TriggerReason: trigger.Reason,
+	}
+	inserted, err := s.insertTriggeredBuild(&build)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to insert build into database: "+err.Error()))
+		return
+	}
+	if !inserted {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	c.JSON(http.StatusCreated, build)
+}
+
+// @Summary Build the commit of a finished build again
+// @Description F.e. after a failure that was fixed elsewhere. The admin and the reason are recorded on the new build.
+// @Accept json
+// @Produce json
+// @Success 201 {object} model.Build
+// @Failure 400 Reason is missing
+// @Failure 401
+// @Failure 404
+// @Failure 409 Build isn't finished or its commit has a pending build already
+// @Param id path int true "Build ID"
+// @Param trigger body model.BuildTrigger true "Reason, the commit hash is ignored"
+// @Router /v1/admin/build/{id}/rebuild [post]
+// @Security AdminToken
+// @Tags Admin
+func (s *Server) apiV1AdminRebuild(c *gin.Context) {
+	build, ok := s.getBuildOfRequest(c)
+	if !ok {
+		return
+	}
+	trigger, ok := bindBuildTrigger(c)
+	if !ok {
+		return
+	}
+
+	if isQueuedBuild(&build) || build.Status == model.STATUS_BUILDING {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	rebuild := model.Build{
+		PackageBase:      build.PackageBase,
+		PackageBaseId:    build.PackageBaseId,
+		CommitId:         build.CommitId,
+		RebuildOfBuildId: build.Id,
+		TriggeredBy:      c.GetString(ADMIN_NAME_CONTEXT_KEY),
+		TriggerReason:    trigger.Reason,
+	}
+	inserted, err := s.insertTriggeredBuild(&rebuild)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to insert build into database: "+err.Error()))
+		return
+	}
+	if !inserted {
+		c.AbortWithStatus(http.StatusConflict)
+		return
+	}
+
+	c.JSON(http.StatusCreated, rebuild)
+}
+
+// @Summary Build the latest commits of multiple package bases
+// @Description F.e. all package bases that depend on python after a python update.
+// @Description Package bases whose latest commit has a pending build already are skipped.
+// @Accept json
+// @Produce json
+// @Success 200 {object} model.BulkBuildTriggerResult
+// @Failure 400 Reason or package bases are missing, or too many package bases
+// @Failure 401
+// @Param trigger body model.BulkBuildTrigger true "Package bases and reason"
+// @Router /v1/admin/builds [post]
+// @Security AdminToken
+// @Tags Admin
+func (s *Server) apiV1AdminTriggerBuilds(c *gin.Context) {
+	var trigger model.BulkBuildTrigger
+	if err := c.BindJSON(&trigger); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if len(strings.TrimSpace(trigger.Reason)) == 0 {
+		c.AbortWithError(http.StatusBadRequest, errors.New("A reason is required"))
+		return
+	}
+
+	packageBases := trigger.PackageBases
+	if len(trigger.DependsOn) > 0 {
+		dependents, err := s.getDependentPackageBases(trigger.DependsOn)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get dependent package bases from database: "+err.Error()))
+			return
+		}
+		packageBases = append(packageBases, dependents...)
+	}
+	if len(packageBases) == 0 || len(packageBases) > MAX_BULK_BUILD_PACKAGE_BASES {
+		c.AbortWithError(http.StatusBadRequest, errors.New("Package bases outside of range {1,5000}"))
+		return
+	}
+
+	result := model.BulkBuildTriggerResult{
+		Builds:         make([]model.Build, 0),
+		AlreadyPending: make([]string, 0),
+		Unknown:        make([]string, 0),
+	}
+	seen := make(map[string]struct{})
+	for _, packageBase := range packageBases {
+		if _, ok := seen[packageBase]; ok {
+			continue
+		}
+		seen[packageBase] = struct{}{}
+
+		packageBaseId, exists, err := s.getPackageBaseId(packageBase)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get package base from database: "+err.Error()))
+			return
+		}
+		var commit model.Commit
+		if exists {
+			exists, err = s.getLastCommitOfPackageBaseId(packageBaseId).Cols("id").Get(&commit)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get commit from database: "+err.Error()))
+				return
+			}
+		}
+		if !exists {
+			result.Unknown = append(result.Unknown, packageBase)
+			continue
+		}
+
+		build := model.Build{
+			PackageBase:   packageBase,
+			PackageBaseId: packageBaseId,
+			CommitId:      commit.Id,
+			TriggeredBy:   c.GetString(ADMIN_NAME_CONTEXT_KEY),
+			TriggerReason: trigger.Reason,
+		}
+		inserted, err := s.insertTriggeredBuild(&build)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to insert build into database: "+err.Error()))
+			return
+		}
+		if !inserted {
+			result.AlreadyPending = append(result.AlreadyPending, packageBase)
+			continue
+		}
+		result.Builds = append(result.Builds, build)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Binds the build trigger of the request body, a reason is required
+func bindBuildTrigger(c *gin.Context) (model.BuildTrigger, bool) {
+	var trigger model.BuildTrigger
+	if err := c.BindJSON(&trigger); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return trigger, false
+	}
+	if len(strings.TrimSpace(trigger.Reason)) == 0 {
+		c.AbortWithError(http.StatusBadRequest, errors.New("A reason is required"))
+		return trigger, false
+	}
+	return trigger, true
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// @Summary Get the packages a build produced
+// @Description Split packages produce one artifact per package.
+// @Produce json
+// @Success 200 {array} model.Artifact
+// @Failure 400
+// @Failure 404
+// @Param id path int true "Build ID"
+// @Router /v1/build/{id}/artifacts [get]
+// @Tags V1
+func (s *Server) apiV1BuildGetArtifacts(c *gin.Context) {
+	build, ok := s.getBuildOfRequest(c)
+	if !ok {
+		return
+	}
+
+	artifacts := make([]model.Artifact, 0)
+	if err := s.DB.Where("build_id = ?", build.Id).Asc("file_name").Find(&artifacts); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get artifacts from database: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, artifacts)
+}
+
+// @Summary Get the namcap findings of a build
+// @Description Findings of the PKGBUILD have the target "PKGBUILD", others the file name of a package.
+// @Produce json
+// @Success 200 {array} model.NamcapFinding
+// @Failure 400
+// @Failure 404
+// @Param id path int true "Build ID"
+// @Router /v1/build/{id}/namcap [get]
+// @Tags V1
+func (s *Server) apiV1BuildGetNamcapFindings(c *gin.Context) {
+	build, ok := s.getBuildOfRequest(c)
+	if !ok {
+		return
+	}
+
+	findings := make([]model.NamcapFinding, 0)
+	if err := s.DB.Where("build_id = ?", build.Id).Asc("id").Find(&findings); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get namcap findings from database: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, findings)
+}
+
+// @Summary Get the files a build changed outside of the paths it may write to
+// @Description Changes by the build user outside of its home directory and temporary directories are a security concern.
+// @Produce json
+// @Success 200 {array} model.FilesystemChange
+// @Failure 400
+// @Failure 404
+// @Param id path int true "Build ID"
+// @Router /v1/build/{id}/filesystemChanges [get]
+// @Tags V1
+func (s *Server) apiV1BuildGetFilesystemChanges(c *gin.Context) {
+	build, ok := s.getBuildOfRequest(c)
+	if !ok {
+		return
+	}
+
+	changes := make([]model.FilesystemChange, 0)
+	if err := s.DB.Where("build_id = ?", build.Id).Asc("path").Find(&changes); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get filesystem changes from database: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+// Returns the build of the id parameter. Aborts the request and returns false if it doesn't exist.
+func (s *Server) getBuildOfRequest(c *gin.Context) (model.Build, bool) {
+	buildId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return model.Build{}, false
+	}
+
+	build := model.Build{
+		Id: buildId,
+	}
+	buildExists, err := s.DB.Get(&build)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get build from database: "+err.Error()))
+		return build, false
+	}
+	if !buildExists {
+		c.AbortWithStatus(http.StatusNotFound)
+		return build, false
+	}
+
+	return build, true
+}
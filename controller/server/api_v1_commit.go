@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// @Summary Get the reproducibility results of a commit
+// @Description Every build with a reproducibility check adds a result, newest first.
+// @Produce json
+// @Success 200 {array} model.ReproducibilityResult
+// @Failure 400
+// @Failure 404
+// @Param id path int true "Commit ID"
+// @Router /v1/commit/{id}/reproducibility [get]
+// @Tags V1
+func (s *Server) apiV1CommitGetReproducibilityResults(c *gin.Context) {
+	commit, ok := s.getCommitOfRequest(c)
+	if !ok {
+		return
+	}
+
+	results := make([]model.ReproducibilityResult, 0)
+	if err := s.DB.Where("commit_id = ?", commit.Id).Desc("created_at").Find(&results); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get reproducibility results from database: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// @Summary Get the parsed .SRCINFO of a commit
+// @Description Architecture specific values are the ones of x86_64, the content contains all of them.
+// @Produce json
+// @Success 200 {object} model.CommitSRCINFO
+// @Failure 400
+// @Failure 404 Commit not found or it has no readable .SRCINFO
+// @Param id path int true "Commit ID"
+// @Router /v1/commit/{id}/srcinfo [get]
+// @Tags V1
+func (s *Server) apiV1CommitGetSRCINFO(c *gin.Context) {
+	commit, ok := s.getCommitOfRequest(c)
+	if !ok {
+		return
+	}
+
+	var packageBase string
+	if _, err := s.DB.Table("package").Cols("package_base").Where("package_base_id = ?", commit.PackageBaseId).Get(&packageBase); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get package base from database: "+err.Error()))
+		return
+	}
+
+	commitSRCINFO, err := s.getCommitSRCINFO(packageBase, &commit)
+	if err != nil {
+		c.AbortWithError(http.StatusNotFound, errors.New("Failed to get .SRCINFO: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, commitSRCINFO)
+}
+
+// Returns the commit of the id parameter. Aborts the request and returns false if it doesn't exist.
+func (s *Server) getCommitOfRequest(c *gin.Context) (model.Commit, bool) {
+	commitId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return model.Commit{}, false
+	}
+
+	commit := model.Commit{
+		Id: commitId,
+	}
+	commitExists, err := s.DB.Get(&commit)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get commit from database: "+err.Error()))
+		return commit, false
+	}
+	if !commitExists {
+		c.AbortWithStatus(http.StatusNotFound)
+		return commit, false
+	}
+
+	return commit, true
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+const MAX_BUILDS_LIMIT = 1000
+
+// @Summary Get the builds of a package base, newest first
+// @Description Includes the builds of package bases that were merged into it.
+// @Produce json
+// @Success 200 {array} model.Build
+// @Failure 400
+// @Failure 404
+// @Param packageBase path string true "Package base"
+// @Param limit query int false "Maximum number of builds, up to 1000" default(100)
+// @Param offset query int false "Number of builds to skip" default(0)
+// @Router /v1/packageBase/{packageBase}/builds [get]
+// @Tags V1
+func (s *Server) apiV1PackageBaseGetBuilds(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > MAX_BUILDS_LIMIT {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var packageBaseId int64
+	exists, err := s.DB.Table("package").Cols("package_base_id").Where("package_base = ?", c.Param("packageBase")).Get(&packageBaseId)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get package base from database: "+err.Error()))
+		return
+	}
+	if !exists {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	packageBaseIds, err := s.getPackageBaseIdsWithMerged(packageBaseId)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get merged package bases from database: "+err.Error()))
+		return
+	}
+
+	builds := make([]model.Build, 0)
+	if err := s.DB.In("package_base_id", packageBaseIds).Desc("created_at").Limit(limit, offset).Find(&builds); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get builds from database: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, builds)
+}
+
+// @Summary Get the merges of a package base, from and into it
+// @Description Package bases that were deleted from the AUR while their packages moved to another one.
+// @Produce json
+// @Success 200 {array} model.PackageBaseMerge
+// @Param packageBase path string true "Package base"
+// @Router /v1/packageBase/{packageBase}/merges [get]
+// @Tags V1
+func (s *Server) apiV1PackageBaseGetMerges(c *gin.Context) {
+	packageBase := c.Param("packageBase")
+
+	merges := make([]model.PackageBaseMerge, 0)
+	if err := s.DB.Where("package_base = ? OR into_package_base = ?", packageBase, packageBase).Desc("created_at").Find(&merges); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get merges from database: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, merges)
+}
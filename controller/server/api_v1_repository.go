@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+const MAX_REPOSITORY_STATS_LIMIT = 1000
+
+// @Summary Get the storage usage and health of git repositories, largest first
+// @Description Updated by the daily git storage maintenance.
+// @Produce json
+// @Success 200 {array} model.RepositoryStats
+// @Failure 400
+// @Param limit query int false "Maximum number of repositories, up to 1000" default(100)
+// @Param offset query int false "Number of repositories to skip" default(0)
+// @Router /v1/repositoryStats [get]
+// @Tags V1
+func (s *Server) apiV1GetRepositoryStats(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > MAX_REPOSITORY_STATS_LIMIT {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	stats := make([]model.RepositoryStats, 0)
+	if err := s.DB.Desc("size_bytes").Limit(limit, offset).Find(&stats); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get repository stats from database: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// @Summary Get the storage usage and health of the git repository of a package base
+// @Produce json
+// @Success 200 {object} model.RepositoryStats
+// @Failure 404 Repository not maintained yet
+// @Param packageBase path string true "Package base"
+// @Router /v1/repositoryStats/{packageBase} [get]
+// @Tags V1
+func (s *Server) apiV1GetRepositoryStatsOfPackageBase(c *gin.Context) {
+	stats := model.RepositoryStats{
+		PackageBase: c.Param("packageBase"),
+	}
+	exists, err := s.DB.Get(&stats)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get repository stats from database: "+err.Error()))
+		return
+	}
+	if !exists {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
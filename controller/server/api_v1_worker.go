@@ -1,14 +1,14 @@
 package server
 
 import (
-	"encoding/base64"
 	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hashworks/aur-ci/controller/aur"
 	"github.com/hashworks/aur-ci/controller/model"
 )
 
@@ -61,7 +61,57 @@ func (s *Server) apiV1WorkerReportWorkResult(c *gin.Context) {
 		return
 	}
 
+	for _, artifact := range workResult.Artifacts {
+		artifact.BuildId = build.Id
+		artifact.CommitId = build.CommitId
+		if _, err := s.DB.Insert(artifact); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to insert artifact into database: "+err.Error()))
+			return
+		}
+	}
+
+	for _, finding := range workResult.NamcapFindings {
+		finding.BuildId = build.Id
+		if _, err := s.DB.Insert(finding); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to insert namcap finding into database: "+err.Error()))
+			return
+		}
+	}
+
+	for _, change := range workResult.FilesystemChanges {
+		change.BuildId = build.Id
+		if _, err := s.DB.Insert(change); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to insert filesystem change into database: "+err.Error()))
+			return
+		}
+	}
+
+	if workResult.Reproducibility != nil {
+		workResult.Reproducibility.CommitId = build.CommitId
+		workResult.Reproducibility.BuildId = build.Id
+		if _, err := s.DB.Insert(workResult.Reproducibility); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to insert reproducibility result into database: "+err.Error()))
+			return
+		}
+	}
+
+	// The result is kept, but the build was aborted on purpose
+	if build.Status == model.STATUS_SUPERSEDED || build.Status == model.STATUS_CANCELLED {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	// VCS packages set their pkgver while building, so it isn't the one of the .SRCINFO
+	if resolvedVersion, ok := model.GetResolvedVersion(workResult.Artifacts); ok {
+		build.ResolvedVersion = resolvedVersion
+	} else if len(workResult.Artifacts) > 0 {
+		log.Printf("Warning: Packages of build %d of %s report different versions, not recording one\n", build.Id, build.PackageBase)
+	}
+
 	build.Status = workResult.GetBuildStatus()
+	if build.Status == model.STATUS_BUILD && s.NamcapWarningsStatus && model.HasNamcapWarnings(workResult.NamcapFindings) {
+		build.Status = model.STATUS_BUILD_WARNINGS
+	}
 	if build.Status != model.STATUS_PENDING {
 		build.FinishedAt = time.Now()
 	}
@@ -78,11 +128,13 @@ func (s *Server) apiV1WorkerReportWorkResult(c *gin.Context) {
 }
 
 // @Summary Endpoint for workers to request work.
+// @Description Supports long-polling: If no work is available the request is held open for up to `wait` seconds.
 // @Produce json
 // @Success 200 {array} model.Work
 // @Failure 400
 // @Failure 404 Worker not found, send heartbeat first
 // @Param amount query int false "Work amount to request, default 1"
+// @Param wait query int false "Seconds to wait for work if none is available, default 0, max 60"
 // @Router /v1/worker/requestWork [get]
 // @Tags V1
 func (s *Server) apiV1WorkerRequestWork(c *gin.Context) {
@@ -102,9 +154,6 @@ func (s *Server) apiV1WorkerRequestWork(c *gin.Context) {
 		return
 	}
 
-	// TODO: Handle multiple workers requesting work at the same time.
-	// Multithread lock? Wait or tell them to try again?
-
 	amount, err := strconv.ParseInt(c.DefaultQuery("amount", "1"), 10, 32)
 	if err != nil {
 		c.AbortWithStatus(http.StatusBadRequest)
@@ -114,81 +163,204 @@ func (s *Server) apiV1WorkerRequestWork(c *gin.Context) {
 		amount = 1
 	}
 
-	var pendingBuilds []model.Build
-	if err := s.searchPendingPackageBuilds().Limit(int(amount)).Find(&pendingBuilds); err != nil {
-		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get build from database: "+err.Error()))
+	wait, err := strconv.ParseInt(c.DefaultQuery("wait", "0"), 10, 32)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
+	if wait < 0 {
+		wait = 0
+	} else if wait > MAX_REQUEST_WORK_WAIT_SECONDS {
+		wait = MAX_REQUEST_WORK_WAIT_SECONDS
+	}
+	deadline := time.Now().Add(time.Duration(wait) * time.Second)
+
+	if !workerHasCapacity(&worker) {
+		log.Printf("Warning: Worker %s reported too little disk space or memory, not assigning any work.\n", worker.Name)
+		c.JSON(http.StatusOK, make([]model.Work, 0))
+		return
+	}
+
+	for {
+		workList, err := s.assignPendingBuilds(&worker, int(amount))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if len(workList) > 0 || !time.Now().Before(deadline) {
+			c.JSON(http.StatusOK, workList)
+			return
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			// The worker gave up, nothing has been assigned to it
+			return
+		case <-time.After(REQUEST_WORK_POLL_INTERVAL):
+		}
+	}
+}
+
+// Returns the dependencies of the commit that is built. Falls back to the current dependencies of the AUR RPC
+// if the commit has no readable .SRCINFO.
+func (s *Server) getBuildDependencies(build *model.Build, commit *model.Commit) ([]string, error) {
+	commitSRCINFO, err := s.getCommitSRCINFO(build.PackageBase, commit)
+	if err == nil {
+		return commitSRCINFO.GetBuildDependencies(), nil
+	}
+	log.Printf("Warning: Failed to get .SRCINFO of commit %s of %s, using current dependencies: %s\n", commit.Hash, build.PackageBase, err)
+
+	var dependencies []string
+	var pkg model.Package
+	if _, err := s.DB.Table("package").
+		Cols("depends", "make_depends", "check_depends").
+		Where("package_base_id = ?", build.PackageBaseId).
+		Get(&pkg); err != nil {
+		return dependencies, err
+	}
+	dependencies = append(dependencies, pkg.Depends...)
+	dependencies = append(dependencies, pkg.MakeDepends...)
+	dependencies = append(dependencies, pkg.CheckDepends...)
+	return dependencies, nil
+}
+
+// Updates a build that was selected as pending, with the given columns or all non-zero fields.
+// Returns false if it isn't pending anymore, it was superseded or cancelled while it was prepared.
+func (s *Server) updatePendingBuild(build *model.Build, cols ...string) (bool, error) {
+	session := s.DB.Where("id = ? AND status = ?", build.Id, model.STATUS_PENDING)
+	if len(cols) > 0 {
+		session = session.Cols(cols...)
+	}
+	updated, err := session.Update(build)
+	if err != nil {
+		return false, err
+	}
+	if updated == 0 {
+		log.Printf("Build %d of %s isn't pending anymore, skipping it\n", build.Id, build.PackageBase)
+		return false, nil
+	}
+	return true, nil
+}
+
+// Assigns up to amount pending builds to the worker and returns them as work.
+// Only one assignment may run at a time, otherwise multiple workers could receive the same build.
+func (s *Server) assignPendingBuilds(worker *model.Worker, amount int) ([]model.Work, error) {
+	s.assignMutex.Lock()
+	defer s.assignMutex.Unlock()
 
 	workList := make([]model.Work, 0)
 
+	var pendingBuilds []model.Build
+	if err := s.searchPendingPackageBuilds().Limit(amount).Find(&pendingBuilds); err != nil {
+		return workList, errors.New("Failed to get build from database: " + err.Error())
+	}
+
 	for i := range pendingBuilds {
 
 		pendingBuilds[i].WorkerId = worker.Id
 		pendingBuilds[i].Status = model.STATUS_BUILDING
 		pendingBuilds[i].StartedAt = time.Now()
 
-		var dependencies []string
-
-		var pkg model.Package
-		if _, err := s.DB.Table("package").
-			Cols("depends", "make_depends", "check_depends").
-			Where("package_base_id = ?", pendingBuilds[i].PackageBaseId).
-			Get(&pkg); err != nil {
+		var commit model.Commit
+		if _, err := s.DB.Table("commit").
+			Cols("id", "hash", "committer_when").
+			Where("id = ?", pendingBuilds[i].CommitId).
+			Get(&commit); err != nil {
 			// TODO: Rollback?
-			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get dependencies: "+err.Error()))
-			return
+			return workList, errors.New("Failed to get commit: " + err.Error())
 		}
-		for _, dependency := range pkg.Depends {
-			dependencies = append(dependencies, dependency)
+
+		dependencies, err := s.getBuildDependencies(&pendingBuilds[i], &commit)
+		if err != nil {
+			// TODO: Rollback?
+			return workList, errors.New("Failed to get dependencies: " + err.Error())
 		}
-		for _, dependency := range pkg.MakeDepends {
-			dependencies = append(dependencies, dependency)
+
+		resolution, err := s.resolveDependencies(dependencies)
+		if err != nil {
+			// TODO: Rollback?
+			return workList, errors.New("Failed to resolve dependencies: " + err.Error())
 		}
-		for _, dependency := range pkg.CheckDepends {
-			dependencies = append(dependencies, dependency)
+		pendingBuilds[i].AURDependencies = resolution.AURPackages
+
+		if len(resolution.Unsatisfiable) > 0 {
+			// No worker could build it, we don't have to try
+			pendingBuilds[i].WorkerId = 0
+			pendingBuilds[i].Status = model.STATUS_UNSATISFIABLE
+			pendingBuilds[i].UnsatisfiableDependencies = resolution.Unsatisfiable
+			pendingBuilds[i].FinishedAt = pendingBuilds[i].StartedAt
+			updated, err := s.updatePendingBuild(&pendingBuilds[i], "worker_id", "status", "aur_dependencies", "unsatisfiable_dependencies", "started_at", "finished_at")
+			if err != nil {
+				return workList, errors.New("Failed to update build in database: " + err.Error())
+			}
+			if !updated {
+				continue
+			}
+			log.Printf("Build %d of %s has unsatisfiable dependencies: %s\n", pendingBuilds[i].Id, pendingBuilds[i].PackageBase, strings.Join(resolution.Unsatisfiable, ", "))
+			continue
 		}
 
 		// TODO: Get all dependency builds and priorise them – this can be recursive!
 
-		var commitHash string
-		if _, err := s.DB.Table("commit").
-			Cols("hash").
-			Where("id = ?", pendingBuilds[i].CommitId).
-			Get(&commitHash); err != nil {
+		packageBaseDataBase64, err := s.getCommitTARBase64(pendingBuilds[i].PackageBase, commit.Hash)
+		if err != nil {
 			// TODO: Rollback?
-			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get commit: "+err.Error()))
-			return
+			return workList, errors.New("Failed to get commit tar: " + err.Error())
 		}
 
-		tarBytes, err := aur.GetCommitTAR(s.GitStoragePath, pendingBuilds[i].PackageBase, commitHash)
+		packageBaseConfig := model.PackageBaseConfig{
+			PackageBase: pendingBuilds[i].PackageBase,
+		}
+		if _, err := s.DB.Get(&packageBaseConfig); err != nil {
+			// TODO: Rollback?
+			return workList, errors.New("Failed to get package base config: " + err.Error())
+		}
+
+		timeouts, err := s.getBuildTimeouts(pendingBuilds[i].PackageBaseId, &packageBaseConfig)
 		if err != nil {
 			// TODO: Rollback?
-			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get commit tar: "+err.Error()))
-			return
+			return workList, errors.New("Failed to get build timeouts: " + err.Error())
 		}
 
-		if _, err := s.DB.Update(&pendingBuilds[i], model.Build{Id: pendingBuilds[i].Id}); err != nil {
+		updated, err := s.updatePendingBuild(&pendingBuilds[i])
+		if err != nil {
 			// TODO: Rollback?
-			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to update build in database: "+err.Error()))
-			return
+			return workList, errors.New("Failed to update build in database: " + err.Error())
+		}
+		if !updated {
+			continue
 		}
 
-		workList = append(workList, model.Work{
+		work := model.Work{
 			BuildId:               pendingBuilds[i].Id,
 			PackageBase:           pendingBuilds[i].PackageBase,
-			Dependencies:          dependencies,
-			PackageBaseDataBase64: base64.StdEncoding.EncodeToString(tarBytes),
-		})
+			Dependencies:          resolution.RepositoryPackages,
+			PackageBaseDataBase64: packageBaseDataBase64,
+			ResourceLimits:        packageBaseConfig.GetResourceLimits(),
+			Timeouts:              &timeouts,
+		}
+		if s.CheckReproducibility || packageBaseConfig.CheckReproducibility {
+			// Both builds need the same, reproducible timestamp
+			work.CheckReproducibility = true
+			work.SourceDateEpoch = commit.CommitterWhen.Unix()
+		}
+		workList = append(workList, work)
 	}
 
-	c.JSON(http.StatusOK, workList)
+	return workList, nil
 }
 
 // @Summary Receives a heartbeat from a worker. Can also be used to register a new worker.
+// @Description Workers should send their capacity and health with it, which is used to schedule builds.
+// @Description If running builds of the worker were superseded or cancelled their IDs are returned, they should be aborted.
+// @Produce json
+// @Success 200 {object} model.HeartbeatResponse
 // @Success 204
 // @Failure 400
+// @Accept json
 // @Param hostname path string true "Hostname"
+// @Param heartbeat body model.Heartbeat false "Capacity and health of the worker"
 // @Router /v1/worker/heartbeat/{hostname} [post]
 // @Tags V1
 func (s *Server) apiV1WorkerHeartbeat(c *gin.Context) {
@@ -198,6 +370,16 @@ func (s *Server) apiV1WorkerHeartbeat(c *gin.Context) {
 		return
 	}
 
+	// Older workers send a heartbeat without a body
+	var heartbeat *model.Heartbeat
+	if c.Request.ContentLength != 0 {
+		heartbeat = &model.Heartbeat{}
+		if err := c.BindJSON(heartbeat); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
 	ip := c.ClientIP()
 
 	// TODO: We should handle IPv6 addresses as well
@@ -212,24 +394,45 @@ func (s *Server) apiV1WorkerHeartbeat(c *gin.Context) {
 		return
 	}
 
+	if heartbeat != nil {
+		worker.ApplyHeartbeat(heartbeat)
+	}
+
 	if !workerExists {
-		worker := model.Worker{
-			Name:   hostname,
-			IPv4:   ip,
-			Status: model.WORKER_STATUS_RUNNING,
-			Type:   model.WORKER_TYPE_OTHER,
-		}
+		worker.Name = hostname
+		worker.Status = model.WORKER_STATUS_RUNNING
+		worker.Type = model.WORKER_TYPE_OTHER
 		if _, err := s.DB.Insert(&worker); err != nil {
 			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to insert worker into database: "+err.Error()))
 			return
 		}
 	} else {
 		worker.Status = model.WORKER_STATUS_RUNNING
-		if _, err := s.DB.Update(&worker, model.Worker{Id: worker.Id}); err != nil {
+		// Capacity values can drop to zero, which xorm would skip otherwise
+		if _, err := s.DB.ID(worker.Id).MustCols(WORKER_HEARTBEAT_COLUMNS...).Update(&worker); err != nil {
 			c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to update worker in database: "+err.Error()))
 			return
 		}
 	}
 
-	c.Status(http.StatusNoContent)
+	if heartbeat == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	s.markLostBuildsOfWorkerAsPending(&worker)
+
+	cancelBuildIds, err := s.getBuildIdsToCancel(&worker)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("Failed to get builds to cancel from database: "+err.Error()))
+		return
+	}
+	if len(cancelBuildIds) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, model.HeartbeatResponse{
+		CancelBuildIds: cancelBuildIds,
+	})
 }
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// Uses the sync database fixtures of the pacman package
+func loadTestSyncDatabases(t *testing.T, s *Server) {
+	s.SyncDatabasePath = "../pacman/testdata/sync"
+	s.SyncRepositories = []string{"core", "extra", "community"}
+	s.LoadSyncDatabases()
+	if len(s.syncDatabases) != len(s.SyncRepositories) {
+		t.Fatalf("Loaded %d of %d sync databases", len(s.syncDatabases), len(s.SyncRepositories))
+	}
+}
+
+func TestAssignPendingBuildsUnsatisfiable(t *testing.T) {
+	s := newTestServer(t)
+	loadTestSyncDatabases(t, s)
+
+	commit := model.Commit{PackageBaseId: 1, Hash: "0123456789abcdef0123456789abcdef01234567", CommitterWhen: time.Now()}
+	insert(t, s,
+		&model.Package{Name: "foo", PackageBase: "foo", PackageBaseId: 1, Version: "1.0-1"},
+		&model.Package{Name: "bar-git", PackageBase: "bar-git", PackageBaseId: 2, Version: "r1.abc-1", Provides: []string{"bar=1.1"}},
+		&commit,
+	)
+	insert(t, s,
+		&model.CommitSRCINFO{
+			CommitId:     commit.Id,
+			PackageBase:  "foo",
+			Depends:      []string{"sh", "java-runtime>=11", "bar>=1", "python>=3.10"},
+			MakeDepends:  []string{"does-not-exist"},
+			CheckDepends: []string{"qt5-base>5.15.2"},
+		},
+	)
+	build := model.Build{PackageBase: "foo", PackageBaseId: 1, CommitId: commit.Id, Status: model.STATUS_PENDING, Type: model.TYPE_PACKAGE}
+	insert(t, s, &build)
+
+	workList, err := s.assignPendingBuilds(&model.Worker{Id: 3}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workList) > 0 {
+		t.Fatalf("Got work for a build with unsatisfiable dependencies: %+v", workList)
+	}
+
+	var stored model.Build
+	if _, err := s.DB.ID(build.Id).Get(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != model.STATUS_UNSATISFIABLE {
+		t.Errorf("Status = %d, want %d", stored.Status, model.STATUS_UNSATISFIABLE)
+	}
+	if expected := []string{"python>=3.10", "does-not-exist"}; !reflect.DeepEqual(stored.UnsatisfiableDependencies, expected) {
+		t.Errorf("UnsatisfiableDependencies = %v, want %v", stored.UnsatisfiableDependencies, expected)
+	}
+	if expected := []string{"bar-git"}; !reflect.DeepEqual(stored.AURDependencies, expected) {
+		t.Errorf("AURDependencies = %v, want %v", stored.AURDependencies, expected)
+	}
+	if stored.WorkerId != 0 || stored.FinishedAt.IsZero() {
+		t.Errorf("WorkerId = %d, FinishedAt = %s; want no worker and a finish time", stored.WorkerId, stored.FinishedAt)
+	}
+
+	// Not assigned again
+	if workList, err := s.assignPendingBuilds(&model.Worker{Id: 3}, 1); err != nil || len(workList) > 0 {
+		t.Errorf("assignPendingBuilds() = %v, %v; want no work", workList, err)
+	}
+}
+
+func TestAssignPendingBuildsSkipsSupersededBuild(t *testing.T) {
+	s := newTestServer(t)
+
+	older := model.Build{PackageBase: "foo", PackageBaseId: 1, CommitId: 1, Status: model.STATUS_PENDING, Type: model.TYPE_PACKAGE}
+	insert(t, s, &older)
+
+	var pendingBuilds []model.Build
+	if err := s.searchPendingPackageBuilds().Find(&pendingBuilds); err != nil || len(pendingBuilds) != 1 {
+		t.Fatalf("Selected %d pending builds, %v; want 1", len(pendingBuilds), err)
+	}
+
+	// A newer commit is processed while the selected build is prepared
+	newer := model.Build{PackageBase: "foo", PackageBaseId: 1, CommitId: 2, Status: model.STATUS_PENDING, Type: model.TYPE_PACKAGE}
+	insert(t, s, &newer)
+	if err := s.supersedeBuilds(&newer); err != nil {
+		t.Fatal(err)
+	}
+
+	pendingBuilds[0].WorkerId = 3
+	pendingBuilds[0].Status = model.STATUS_BUILDING
+	pendingBuilds[0].StartedAt = time.Now()
+	if updated, err := s.updatePendingBuild(&pendingBuilds[0]); err != nil || updated {
+		t.Fatalf("updatePendingBuild() = %t, %v; want false", updated, err)
+	}
+
+	var stored model.Build
+	if _, err := s.DB.ID(older.Id).Get(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != model.STATUS_SUPERSEDED || stored.WorkerId != 0 {
+		t.Errorf("Status = %d, WorkerId = %d; want %d without a worker", stored.Status, stored.WorkerId, model.STATUS_SUPERSEDED)
+	}
+}
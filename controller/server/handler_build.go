@@ -0,0 +1,120 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/model"
+	"github.com/hashworks/aur-ci/controller/pacman"
+)
+
+// Amount of past successful builds to consider when estimating the duration of a build
+const BUILD_DURATION_HISTORY_SIZE = 10
+
+// Factor applied to the longest past build duration to get the total timeout
+const BUILD_DURATION_HISTORY_FACTOR = 2
+
+// Returns the timeouts of the next build of a package base.
+// The total timeout is derived from past build durations unless overridden, but never below the default
+// and never above the maximum. Step timeouts are the defaults unless overridden.
+// Past builds of package bases that were merged into it count as well.
+func (s *Server) getBuildTimeouts(packageBaseId int64, packageBaseConfig *model.PackageBaseConfig) (model.Timeouts, error) {
+	timeouts := s.DefaultTimeouts
+
+	if packageBaseConfig.TotalTimeoutSeconds == 0 {
+		packageBaseIds, err := s.getPackageBaseIdsWithMerged(packageBaseId)
+		if err != nil {
+			return timeouts, err
+		}
+
+		var pastBuilds []model.Build
+		if err := s.searchSuccessfulBuildsOfPackageBaseIds(packageBaseIds).
+			Cols("started_at", "finished_at").
+			Limit(BUILD_DURATION_HISTORY_SIZE).
+			Find(&pastBuilds); err != nil {
+			return timeouts, err
+		}
+
+		var longestDuration time.Duration
+		for _, pastBuild := range pastBuilds {
+			if duration := pastBuild.FinishedAt.Sub(pastBuild.StartedAt); duration > longestDuration {
+				longestDuration = duration
+			}
+		}
+
+		if estimatedSeconds := int(longestDuration.Seconds()) * BUILD_DURATION_HISTORY_FACTOR; estimatedSeconds > timeouts.TotalSeconds {
+			timeouts.TotalSeconds = estimatedSeconds
+		}
+	}
+
+	timeouts = packageBaseConfig.ApplyTimeoutOverrides(timeouts)
+
+	if s.MaxTotalTimeoutSeconds > 0 && timeouts.TotalSeconds > s.MaxTotalTimeoutSeconds {
+		timeouts.TotalSeconds = s.MaxTotalTimeoutSeconds
+	}
+
+	return timeouts, nil
+}
+
+// Inserts a pending build of a commit that was requested manually or is a rebuild, TYPE_PACKAGE by default.
+// Returns false if the commit has a pending build in the queue already, a second one would build the same.
+// Holds the assign mutex, so concurrent triggers can't both miss the pending build and neither can an assignment.
+func (s *Server) insertTriggeredBuild(build *model.Build) (bool, error) {
+	s.assignMutex.Lock()
+	defer s.assignMutex.Unlock()
+
+	pending, err := s.DB.Table("build").Where("commit_id = ?", build.CommitId).
+		And(QUEUED_BUILD_CONDITION, queuedBuildConditionArgs()...).
+		Exist()
+	if err != nil || pending {
+		return false, err
+	}
+
+	build.Status = model.STATUS_PENDING
+	if build.Type == 0 {
+		build.Type = model.TYPE_PACKAGE
+	}
+	if _, err := s.DB.Insert(build); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Returns the ID of a package base that has packages in the AUR
+func (s *Server) getPackageBaseId(packageBase string) (int64, bool, error) {
+	var packageBaseId int64
+	exists, err := s.DB.Table("package").Cols("package_base_id").
+		Where("package_base = ? AND removed = ?", packageBase, false).
+		Get(&packageBaseId)
+	return packageBaseId, exists, err
+}
+
+// Returns the package bases with packages that depend on the package to run, build or check them
+func (s *Server) getDependentPackageBases(name string) ([]string, error) {
+	var packages []model.Package
+	pattern := "%\"" + name + "%"
+	if err := s.DB.Cols("package_base", "depends", "make_depends", "check_depends").
+		Where("removed = ? AND (depends LIKE ? OR make_depends LIKE ? OR check_depends LIKE ?)", false, pattern, pattern, pattern).
+		Find(&packages); err != nil {
+		return nil, err
+	}
+
+	packageBases := make(map[string]struct{})
+	for _, pkg := range packages {
+		// The pattern matches other packages with the same prefix as well
+		for _, depends := range [][]string{pkg.Depends, pkg.MakeDepends, pkg.CheckDepends} {
+			for _, depend := range depends {
+				if pacman.ParseDepend(depend).Name == name {
+					packageBases[pkg.PackageBase] = struct{}{}
+				}
+			}
+		}
+	}
+
+	sortedPackageBases := make([]string, 0, len(packageBases))
+	for packageBase := range packageBases {
+		sortedPackageBases = append(sortedPackageBases, packageBase)
+	}
+	sort.Strings(sortedPackageBases)
+	return sortedPackageBases, nil
+}
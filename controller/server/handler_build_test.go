@@ -0,0 +1,100 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+func TestInsertTriggeredBuildConcurrently(t *testing.T) {
+	s := newTestServer(t)
+	const triggers = 50
+
+	var wg sync.WaitGroup
+	inserted := make(chan bool, triggers)
+	for i := 0; i < triggers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := s.insertTriggeredBuild(&model.Build{CommitId: 1})
+			if err != nil {
+				t.Errorf("insertTriggeredBuild() = %s", err)
+			}
+			inserted <- ok
+		}()
+	}
+	wg.Wait()
+	close(inserted)
+
+	insertedCount := 0
+	for ok := range inserted {
+		if ok {
+			insertedCount++
+		}
+	}
+	if insertedCount != 1 {
+		t.Errorf("%d triggers inserted a build, want 1", insertedCount)
+	}
+	if pending, err := s.DB.Count(&model.Build{CommitId: 1, Status: model.STATUS_PENDING}); err != nil || pending != 1 {
+		t.Errorf("%d pending builds, %v; want 1", pending, err)
+	}
+}
+
+// Inserts a pending build created the given time ago
+func insertPendingBuild(t *testing.T, s *Server, buildType model.BuildType, age time.Duration) *model.Build {
+	build := &model.Build{PackageBase: "foo", PackageBaseId: 1, CommitId: 1, Status: model.STATUS_PENDING, Type: buildType}
+	insert(t, s, build)
+	setBuildAge(t, s, build, age)
+	return build
+}
+
+func setBuildAge(t *testing.T, s *Server, build *model.Build, age time.Duration) {
+	// xorm never updates created columns
+	build.CreatedAt = time.Now().Add(-age)
+	if _, err := s.DB.Exec("UPDATE build SET created_at = ? WHERE id = ?", build.CreatedAt.Format("2006-01-02 15:04:05"), build.Id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueuedBuilds(t *testing.T) {
+	tests := []struct {
+		name      string
+		buildType model.BuildType
+		age       time.Duration
+		queued    bool
+	}{
+		{"new package build", model.TYPE_PACKAGE, time.Hour, true},
+		{"stale package build", model.TYPE_PACKAGE, 2 * PENDING_PACKAGE_BUILD_MAX_AGE, false},
+		{"stale rebuild", model.TYPE_REBUILD, 2 * PENDING_PACKAGE_BUILD_MAX_AGE, true},
+		{"stale VCS build", model.TYPE_VCS, 2 * PENDING_PACKAGE_BUILD_MAX_AGE, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			build := insertPendingBuild(t, s, test.buildType, test.age)
+
+			if queued := isQueuedBuild(build); queued != test.queued {
+				t.Errorf("isQueuedBuild() = %t, want %t", queued, test.queued)
+			}
+			var pendingBuilds []model.Build
+			if err := s.searchPendingPackageBuilds().Find(&pendingBuilds); err != nil {
+				t.Fatal(err)
+			}
+			if queued := len(pendingBuilds) == 1; queued != test.queued {
+				t.Errorf("Selected %d pending builds, want it to be queued: %t", len(pendingBuilds), test.queued)
+			}
+
+			// Pending builds out of the queue don't block triggered builds
+			inserted, err := s.insertTriggeredBuild(&model.Build{PackageBase: "foo", PackageBaseId: 1, CommitId: 1, Type: model.TYPE_REBUILD})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inserted == test.queued {
+				t.Errorf("insertTriggeredBuild() = %t, want %t", inserted, !test.queued)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/hashworks/aur-ci/controller/aur"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// Returns the parsed .SRCINFO of a commit. Reads it from the repository and stores it if it isn't stored yet.
+func (s *Server) getCommitSRCINFO(packageBase string, commit *model.Commit) (*model.CommitSRCINFO, error) {
+	commitSRCINFO := model.CommitSRCINFO{
+		CommitId: commit.Id,
+	}
+	exists, err := s.DB.Get(&commitSRCINFO)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return &commitSRCINFO, nil
+	}
+
+	srcinfo, content, err := aur.ReadSRCINFO(s.GitStoragePath, packageBase, commit.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	commitSRCINFO = srcinfo.NewCommitSRCINFO(commit.Id, content)
+	if _, err := s.DB.Insert(&commitSRCINFO); err != nil {
+		return nil, err
+	}
+	return &commitSRCINFO, nil
+}
+
+// Returns the tarball of a commit base64 encoded, as workers expect it. Encodes while reading the cached tarball.
+func (s *Server) getCommitTARBase64(packageBase string, commitHash string) (string, error) {
+	tarReader, err := aur.GetCommitTAR(s.GitStoragePath, packageBase, commitHash)
+	if err != nil {
+		return "", err
+	}
+	defer tarReader.Close()
+
+	var encoded strings.Builder
+	encoder := base64.NewEncoder(base64.StdEncoding, &encoded)
+	if _, err := io.Copy(encoder, tarReader); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return encoded.String(), nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/hashworks/aur-ci/controller/model"
+	"github.com/hashworks/aur-ci/controller/pacman"
+	"xorm.io/xorm"
+)
+
+// Finds AUR packages that satisfy a dependency by their name or provisions, packages removed from the AUR don't
+type aurPackageSource struct {
+	db *xorm.Engine
+}
+
+func (a aurPackageSource) FindSatisfiers(depend pacman.Depend) ([]pacman.Package, error) {
+	var packages []model.Package
+	// Provisions are stored as JSON, f.e. ["foo=1.2","bar"]. The pattern finds candidates, the resolver checks them.
+	if err := a.db.Table("package").
+		Cols("name", "version", "provides").
+		Where("(name = ? OR provides LIKE ?) AND removed = ?", depend.Name, "%\""+depend.Name+"%", false).
+		Asc("name").
+		Find(&packages); err != nil {
+		return nil, err
+	}
+
+	var satisfiers []pacman.Package
+	for _, pkg := range packages {
+		candidate := pacman.Package{
+			Name:     pkg.Name,
+			Version:  pkg.Version,
+			Provides: pkg.Provides,
+		}
+		if depend.SatisfiedBy(candidate) {
+			satisfiers = append(satisfiers, candidate)
+		}
+	}
+	return satisfiers, nil
+}
+
+// Loads the sync databases of the official repositories, f.e. core.db, from the sync database path.
+// Meant to run regularly, since the files are updated by pacman -Sy or similar.
+// Package bases that depend on official packages with breaking updates are rebuilt.
+func (s *Server) LoadSyncDatabases() {
+	if len(s.SyncDatabasePath) == 0 {
+		return
+	}
+
+	databases := make([]*pacman.Database, 0, len(s.SyncRepositories))
+	packageSources := make([]pacman.PackageSource, 0, len(s.SyncRepositories))
+	for _, repository := range s.SyncRepositories {
+		database, err := pacman.LoadDatabase(repository, filepath.Join(s.SyncDatabasePath, repository+".db"))
+		if err != nil {
+			log.Printf("Error: Failed to load sync database of %s: %s\n", repository, err)
+			continue
+		}
+		databases = append(databases, database)
+		packageSources = append(packageSources, database)
+	}
+
+	complete := len(databases) == len(s.SyncRepositories)
+
+	s.syncDatabasesMutex.Lock()
+	// Keep the previous databases unless all could be loaded, dependencies of missing ones would be unsatisfiable.
+	// Without previous databases a partial set is better than none.
+	if complete || (len(s.syncDatabases) == 0 && len(packageSources) > 0) {
+		s.syncDatabases = packageSources
+	} else {
+		log.Printf("Warning: Loaded %d of %d sync databases, keeping the previous ones\n", len(packageSources), len(s.SyncRepositories))
+	}
+	s.syncDatabasesMutex.Unlock()
+
+	// Packages of missing databases would look removed and reappear as new ones later
+	if complete {
+		s.rebuildDependentsOfChangedPackages(databases)
+	}
+}
+
+// Resolves dependencies to concrete package names. Without sync databases the dependencies are passed as they are.
+func (s *Server) resolveDependencies(dependencies []string) (pacman.Resolution, error) {
+	s.syncDatabasesMutex.RLock()
+	defer s.syncDatabasesMutex.RUnlock()
+
+	if len(s.syncDatabases) == 0 {
+		return pacman.Resolution{
+			RepositoryPackages: dependencies,
+		}, nil
+	}
+	return pacman.Resolve(dependencies, s.syncDatabases, aurPackageSource{db: s.DB})
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// Returns a sync database directory with only core.db of the fixtures
+func createPartialSyncDatabasePath(t *testing.T) string {
+	dir := t.TempDir()
+	data, err := ioutil.ReadFile("../pacman/testdata/sync/core.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "core.db"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadSyncDatabasesKeepsPreviousOnPartialLoad(t *testing.T) {
+	s := newTestServer(t)
+	loadTestSyncDatabases(t, s)
+
+	s.SyncDatabasePath = createPartialSyncDatabasePath(t)
+	s.LoadSyncDatabases()
+	if len(s.syncDatabases) != 3 {
+		t.Errorf("%d sync databases after a partial load, want the previous 3", len(s.syncDatabases))
+	}
+}
+
+func TestLoadSyncDatabasesFallsBackToPartialOnFirstLoad(t *testing.T) {
+	s := newTestServer(t)
+	s.SyncDatabasePath = createPartialSyncDatabasePath(t)
+	s.SyncRepositories = []string{"core", "extra", "community"}
+
+	s.LoadSyncDatabases()
+	if len(s.syncDatabases) != 1 {
+		t.Errorf("%d sync databases after a partial first load, want 1", len(s.syncDatabases))
+	}
+}
+
+func TestResolveDependenciesIgnoresRemovedAURPackages(t *testing.T) {
+	s := newTestServer(t)
+	loadTestSyncDatabases(t, s)
+	insert(t, s,
+		&model.Package{Name: "foo", PackageBase: "foo", PackageBaseId: 1, Version: "1.0-1"},
+		&model.Package{Name: "bar-git", PackageBase: "bar-git", PackageBaseId: 2, Version: "r1.abc-1", Provides: []string{"bar=1.1"},
+			Removed: true, RemovedAt: time.Now()},
+	)
+
+	resolution, err := s.resolveDependencies([]string{"foo", "bar>=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"foo"}; !reflect.DeepEqual(resolution.AURPackages, expected) {
+		t.Errorf("AURPackages = %v, want %v", resolution.AURPackages, expected)
+	}
+	if expected := []string{"bar>=1"}; !reflect.DeepEqual(resolution.Unsatisfiable, expected) {
+		t.Errorf("Unsatisfiable = %v, want %v", resolution.Unsatisfiable, expected)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/aur"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// Cached tarballs that weren't used for this long are removed
+const TAR_CACHE_MAX_AGE = 7 * 24 * time.Hour
+
+// Maintains the git storage: Deletes repositories of package bases that were removed from the AUR,
+// repacks and verifies the others and stores their stats. Meant to run regularly, but never concurrently.
+func (s *Server) MaintainGitStorage() {
+	start := time.Now()
+
+	packageBases, err := aur.ListRepositories(s.GitStoragePath)
+	if err != nil {
+		log.Println("Error: Failed to list git repositories: " + err.Error())
+		return
+	}
+
+	// Reconciling with the AUR package list marks packages as removed, truncated lists are ignored there
+	removedPackageBases, err := s.getRemovedPackageBases()
+	if err != nil {
+		log.Println("Error: Failed to get removed package bases, not deleting their repositories: " + err.Error())
+	}
+
+	deleted, failed := 0, 0
+	for _, packageBase := range packageBases {
+		if _, ok := removedPackageBases[packageBase]; ok {
+			if err := s.deleteRepository(packageBase); err != nil {
+				log.Printf("Error: Failed to delete repository of removed package base %s: %s\n", packageBase, err)
+			} else {
+				deleted++
+			}
+			continue
+		}
+
+		stats, err := aur.MaintainRepository(s.GitStoragePath, packageBase)
+		if err != nil {
+			log.Printf("Warning: Maintenance of repository %s failed: %s\n", packageBase, err)
+			stats.Error = err.Error()
+			failed++
+		}
+		if err := s.storeRepositoryStats(&stats); err != nil {
+			log.Printf("Error: Failed to store stats of repository %s: %s\n", packageBase, err)
+		}
+	}
+
+	removedTARs, err := aur.CleanTARCache(s.GitStoragePath, TAR_CACHE_MAX_AGE)
+	if err != nil {
+		log.Println("Error: Failed to clean tarball cache: " + err.Error())
+	}
+
+	log.Printf("Maintained %d git repositories in %s, %d failed, deleted %d removed ones and %d unused tarballs\n",
+		len(packageBases)-deleted, time.Since(start).Round(time.Second), failed, deleted, removedTARs)
+}
+
+// Returns package bases that were removed from the AUR or merged into others: They have removed packages
+// or a merge, but no packages that are in the AUR.
+func (s *Server) getRemovedPackageBases() (map[string]struct{}, error) {
+	var removed, merged, active []string
+	if err := s.DB.Table("package").Distinct("package_base").Where("removed = ?", true).Find(&removed); err != nil {
+		return nil, err
+	}
+	if err := s.DB.Table("package_base_merge").Distinct("package_base").Find(&merged); err != nil {
+		return nil, err
+	}
+	if err := s.DB.Table("package").Distinct("package_base").Where("removed = ?", false).Find(&active); err != nil {
+		return nil, err
+	}
+
+	removedPackageBases := make(map[string]struct{}, len(removed)+len(merged))
+	for _, packageBase := range append(removed, merged...) {
+		removedPackageBases[packageBase] = struct{}{}
+	}
+	for _, packageBase := range active {
+		delete(removedPackageBases, packageBase)
+	}
+	return removedPackageBases, nil
+}
+
+func (s *Server) deleteRepository(packageBase string) error {
+	if err := aur.DeleteRepository(s.GitStoragePath, packageBase); err != nil {
+		return err
+	}
+	if _, err := s.DB.Delete(&model.RepositoryStats{PackageBase: packageBase}); err != nil {
+		return err
+	}
+	_, err := s.DB.Delete(&model.QuarantinedPackageBase{PackageBase: packageBase})
+	return err
+}
+
+func (s *Server) storeRepositoryStats(stats *model.RepositoryStats) error {
+	// The error has to be cleared after a successful maintenance
+	updateCount, err := s.DB.AllCols().Where("package_base = ?", stats.PackageBase).Update(stats)
+	if err != nil {
+		return err
+	}
+	if updateCount == 0 {
+		_, err = s.DB.Insert(stats)
+	}
+	return err
+}
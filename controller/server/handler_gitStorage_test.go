@@ -0,0 +1,36 @@
+package server
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/hashworks/aur-ci/controller/aur"
+)
+
+func TestMaintainGitStorageDeletesRemovedPackageBases(t *testing.T) {
+	s := newTestServer(t)
+	s.PackageList = &aur.FilePackageListSource{Path: filepath.Join("testdata", "packages-meta-v1.json")}
+	insertReconcileTestPackages(t, s)
+	s.ReconcilePackages()
+
+	// untracked isn't known at all
+	for _, packageBase := range []string{"foo", "bar", "baz", "qux", "multi", "multi-b", "old", "gone", "untracked"} {
+		if _, err := git.PlainInit(filepath.Join(*s.GitStoragePath, packageBase+".git"), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.MaintainGitStorage()
+
+	packageBases, err := aur.ListRepositories(s.GitStoragePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(packageBases)
+	if expected := []string{"foo", "multi", "multi-b", "old", "qux", "untracked"}; !reflect.DeepEqual(packageBases, expected) {
+		t.Errorf("Repositories after the maintenance: %v, want %v", packageBases, expected)
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/aur"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// The repository of a package base can't be processed. Unlike database errors this won't fix itself,
+// so the package base is quarantined instead of failing the whole request.
+type repositoryError struct {
+	message string
+	err     error
+}
+
+func (e *repositoryError) Error() string {
+	return e.message + ": " + e.err.Error()
+}
+
+func (e *repositoryError) Unwrap() error {
+	return e.err
+}
+
+// Fetches the repository of a package base, stores new commits and adds a build of the latest one
+func (s *Server) processPackageBase(packageBase string, packageBaseId int64) error {
+	if err := aur.CloneOrFetchRepository(s.GitStoragePath, packageBase); err != nil {
+		return &repositoryError{"Failed to clone or fetch repository", err}
+	}
+
+	var lastHash string
+	_, err := s.getLastCommitOfPackageBaseId(packageBaseId).Cols("hash").Get(&lastHash)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to select last hash of package base %s (id %d): %s", packageBase, packageBaseId, err))
+	}
+
+	newCommits, err := aur.GetCommitsUntilHash(s.GitStoragePath, packageBase, packageBaseId, lastHash)
+	if err != nil {
+		return &repositoryError{"Failed to get commits", err}
+	}
+	if len(newCommits) == 0 {
+		return nil
+	}
+
+	// Fails early for repositories we can't build, the tarball is cached for the worker
+	tarReader, err := aur.GetCommitTAR(s.GitStoragePath, packageBase, newCommits[0].Hash)
+	if err != nil {
+		return &repositoryError{"Failed to create tarball of commit " + newCommits[0].Hash, err}
+	}
+	tarReader.Close()
+
+	_, err = s.DB.Insert(newCommits)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to insert new commits of package base %s (id %d): %s", packageBase, packageBaseId, err))
+	}
+
+	// TODO: Depends list! This can be recursive, move to function
+	// Get list of all depends and make_depends (by getting all packages of a packageBaseId)
+	// Filter depends/make_depends by AUR packages
+	// Get last commit for every depends/make_depends
+	// Add build task for last_commit if it doesn't exist already
+	// Save list of build task ids
+
+	return s.insertBuildOfLastCommit(packageBase, packageBaseId)
+}
+
+// Inserts a pending build of the latest commit of the package base, which supersedes older builds
+func (s *Server) insertBuildOfLastCommit(packageBase string, packageBaseId int64) error {
+	var lastCommit model.Commit
+	_, err := s.getLastCommitOfPackageBaseId(packageBaseId).Cols("id", "hash").Get(&lastCommit)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to select last commit id of package base %s (id %d): %s", packageBase, packageBaseId, err))
+	}
+
+	// Stored for querying, building the commit uses it as well
+	if _, err := s.getCommitSRCINFO(packageBase, &lastCommit); err != nil {
+		log.Printf("Warning: Failed to parse .SRCINFO of commit %s of %s: %s", lastCommit.Hash, packageBase, err)
+	}
+
+	build := model.Build{
+		PackageBase:   packageBase,
+		PackageBaseId: packageBaseId,
+		CommitId:      lastCommit.Id,
+		Status:        model.STATUS_PENDING,
+		Type:          model.TYPE_PACKAGE,
+	}
+	_, err = s.DB.Insert(&build)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to insert new build task of package base %s (id %d): %s", packageBase, packageBaseId, err))
+	}
+
+	if err := s.supersedeBuilds(&build); err != nil {
+		return errors.New(fmt.Sprintf("Failed to supersede older builds of package base %s (id %d): %s", packageBase, packageBaseId, err))
+	}
+	return nil
+}
+
+// Marks older pending builds of the package base as superseded by the build, they would build an outdated commit.
+// Running builds are superseded as well if enabled, their workers abort them on their next heartbeat.
+func (s *Server) supersedeBuilds(build *model.Build) error {
+	statuses := []model.BuildStatus{model.STATUS_PENDING}
+	if s.SupersedeRunningBuilds {
+		statuses = append(statuses, model.STATUS_BUILDING)
+	}
+
+	superseded, err := s.DB.Where("package_base_id = ? AND id < ?", build.PackageBaseId, build.Id).
+		In("status", statuses).
+		Cols("status", "superseded_by_build_id", "finished_at").
+		Update(&model.Build{Status: model.STATUS_SUPERSEDED, SupersededByBuildId: build.Id, FinishedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	if superseded > 0 {
+		log.Printf("Build %d of package base %s superseded %d older builds\n", build.Id, build.PackageBase, superseded)
+	}
+	return nil
+}
+
+// Processes a package base. Quarantines it if its repository can't be processed, releases it if it could.
+// Only returns errors that aren't caused by the repository.
+func (s *Server) processOrQuarantinePackageBase(packageBase string, packageBaseId int64) error {
+	err := s.processPackageBase(packageBase, packageBaseId)
+
+	var repoErr *repositoryError
+	if errors.As(err, &repoErr) {
+		log.Printf("Warning: Quarantining package base %s: %s", packageBase, repoErr)
+		return s.quarantinePackageBase(packageBase, packageBaseId, repoErr.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.DB.Delete(&model.QuarantinedPackageBase{PackageBase: packageBase}); err != nil {
+		return errors.New("Failed to release package base from quarantine: " + err.Error())
+	}
+	return nil
+}
+
+func (s *Server) quarantinePackageBase(packageBase string, packageBaseId int64, reason string) error {
+	quarantined := model.QuarantinedPackageBase{
+		PackageBase: packageBase,
+	}
+	exists, err := s.DB.Get(&quarantined)
+	if err != nil {
+		return errors.New("Failed to get quarantined package base: " + err.Error())
+	}
+
+	quarantined.PackageBaseId = packageBaseId
+	quarantined.Reason = reason
+	quarantined.Attempts++
+	if exists {
+		_, err = s.DB.Update(&quarantined, model.QuarantinedPackageBase{PackageBase: packageBase})
+	} else {
+		_, err = s.DB.Insert(&quarantined)
+	}
+	if err != nil {
+		return errors.New("Failed to quarantine package base: " + err.Error())
+	}
+	return nil
+}
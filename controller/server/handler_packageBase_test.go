@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// Sends a heartbeat of the worker reporting the running builds and returns the response
+func sendTestHeartbeat(t *testing.T, s *Server, worker *model.Worker, runningBuildIds []int64) (int, model.HeartbeatResponse) {
+	body, err := json.Marshal(model.Heartbeat{FreeSlots: 1, RunningBuildIds: runningBuildIds})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Params = gin.Params{{Key: "hostname", Value: worker.Name}}
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/worker/heartbeat/"+worker.Name, bytes.NewReader(body))
+	c.Request.RemoteAddr = worker.IPv4 + ":1234"
+	s.apiV1WorkerHeartbeat(c)
+
+	// A status without a body isn't written to the recorder
+	var response model.HeartbeatResponse
+	if c.Writer.Status() == http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c.Writer.Status(), response
+}
+
+func TestInsertBuildOfLastCommitSupersedesBuilds(t *testing.T) {
+	for _, supersedeRunningBuilds := range []bool{false, true} {
+		t.Run(fmt.Sprintf("supersede running builds %t", supersedeRunningBuilds), func(t *testing.T) {
+			s := newTestServer(t)
+			s.SupersedeRunningBuilds = supersedeRunningBuilds
+
+			worker := model.Worker{Name: "worker", IPv4: "192.0.2.1", Status: model.WORKER_STATUS_RUNNING}
+			oldCommit := model.Commit{PackageBaseId: 1, Hash: "0000000000000000000000000000000000000001", CommitterWhen: time.Now().Add(-time.Hour)}
+			insert(t, s, &worker, &oldCommit)
+			pending := []*model.Build{
+				{PackageBase: "foo", PackageBaseId: 1, CommitId: oldCommit.Id, Status: model.STATUS_PENDING, Type: model.TYPE_PACKAGE},
+				{PackageBase: "foo", PackageBaseId: 1, CommitId: oldCommit.Id, Status: model.STATUS_PENDING, Type: model.TYPE_REBUILD},
+			}
+			running := &model.Build{PackageBase: "foo", PackageBaseId: 1, CommitId: oldCommit.Id, Status: model.STATUS_BUILDING, Type: model.TYPE_PACKAGE,
+				WorkerId: worker.Id, StartedAt: time.Now()}
+			other := &model.Build{PackageBase: "bar", PackageBaseId: 2, CommitId: 99, Status: model.STATUS_PENDING, Type: model.TYPE_PACKAGE}
+			insert(t, s, pending[0], pending[1], running, other)
+
+			// A newer commit was fetched
+			insert(t, s, &model.Commit{PackageBaseId: 1, Hash: "0000000000000000000000000000000000000002", CommitterWhen: time.Now()})
+			if err := s.insertBuildOfLastCommit("foo", 1); err != nil {
+				t.Fatal(err)
+			}
+			var newBuild model.Build
+			if _, err := s.DB.Where("package_base_id = ? AND status = ?", 1, model.STATUS_PENDING).Get(&newBuild); err != nil || newBuild.Id == 0 {
+				t.Fatalf("No pending build of the newer commit: %v", err)
+			}
+
+			getBuild := func(id int64) model.Build {
+				var build model.Build
+				if _, err := s.DB.ID(id).Get(&build); err != nil {
+					t.Fatal(err)
+				}
+				return build
+			}
+			for _, build := range pending {
+				if stored := getBuild(build.Id); stored.Status != model.STATUS_SUPERSEDED || stored.SupersededByBuildId != newBuild.Id {
+					t.Errorf("Pending build %d has status %d superseded by %d, want %d superseded by %d",
+						build.Id, stored.Status, stored.SupersededByBuildId, model.STATUS_SUPERSEDED, newBuild.Id)
+				}
+			}
+			if stored := getBuild(other.Id); stored.Status != model.STATUS_PENDING {
+				t.Errorf("Build of another package base has status %d, want %d", stored.Status, model.STATUS_PENDING)
+			}
+
+			status, response := sendTestHeartbeat(t, s, &worker, []int64{running.Id})
+			stored := getBuild(running.Id)
+			if supersedeRunningBuilds {
+				if stored.Status != model.STATUS_SUPERSEDED || stored.SupersededByBuildId != newBuild.Id {
+					t.Errorf("Running build has status %d superseded by %d, want %d superseded by %d",
+						stored.Status, stored.SupersededByBuildId, model.STATUS_SUPERSEDED, newBuild.Id)
+				}
+				if expected := []int64{running.Id}; status != http.StatusOK || !reflect.DeepEqual(response.CancelBuildIds, expected) {
+					t.Errorf("Heartbeat returned %d with %v, want 200 with %v", status, response.CancelBuildIds, expected)
+				}
+			} else {
+				if stored.Status != model.STATUS_BUILDING {
+					t.Errorf("Running build has status %d, want %d", stored.Status, model.STATUS_BUILDING)
+				}
+				if status != http.StatusNoContent {
+					t.Errorf("Heartbeat returned %d with %v, want 204", status, response.CancelBuildIds)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/aur"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// Changes of the packages of a package base that are no longer in it
+type packageBaseChanges struct {
+	packageBaseId int64
+	removed       []string
+	moved         map[string][]aur.PackageListEntry // New package base -> packages
+}
+
+// Compares the packages with the AUR package list. Packages that are no longer in the AUR are marked as removed,
+// packages that are in another package base now are moved. If a package base is gone its pending builds are
+// cancelled and the package bases its packages moved to are recorded as merges. Removed packages that are in the
+// list again, f.e. restored after a deletion by mistake, are revived. Meant to run regularly.
+func (s *Server) ReconcilePackages() {
+	if s.PackageList == nil {
+		return
+	}
+
+	entries, err := s.PackageList.GetPackageList()
+	if err != nil {
+		log.Println("Error: Failed to get AUR package list: " + err.Error())
+		return
+	}
+
+	var packages []model.Package
+	if err := s.DB.Table("package").Cols("name", "package_base", "package_base_id", "removed").Find(&packages); err != nil {
+		log.Println("Error: Failed to get packages from database: " + err.Error())
+		return
+	}
+
+	knownPackages := 0
+	for _, pkg := range packages {
+		if !pkg.Removed {
+			knownPackages++
+		}
+	}
+	// A truncated list must not remove everything
+	if len(entries) < knownPackages/2 {
+		log.Printf("Warning: The AUR package list has only %d packages for %d known ones, not reconciling\n", len(entries), knownPackages)
+		return
+	}
+
+	entriesByName := make(map[string]aur.PackageListEntry, len(entries))
+	aurPackageBases := make(map[string]struct{})
+	for _, entry := range entries {
+		entriesByName[entry.Name] = entry
+		aurPackageBases[entry.PackageBase] = struct{}{}
+	}
+
+	changes := make(map[string]*packageBaseChanges)
+	for _, pkg := range packages {
+		entry, ok := entriesByName[pkg.Name]
+		if pkg.Removed {
+			if ok {
+				if err := s.revivePackage(&pkg, entry); err != nil {
+					log.Printf("Error: Failed to revive package %s: %s\n", pkg.Name, err)
+				}
+			}
+			continue
+		}
+		if ok && entry.PackageBase == pkg.PackageBase {
+			continue
+		}
+		change, exists := changes[pkg.PackageBase]
+		if !exists {
+			change = &packageBaseChanges{
+				packageBaseId: pkg.PackageBaseId,
+				moved:         make(map[string][]aur.PackageListEntry),
+			}
+			changes[pkg.PackageBase] = change
+		}
+		if ok {
+			change.moved[entry.PackageBase] = append(change.moved[entry.PackageBase], entry)
+		} else {
+			change.removed = append(change.removed, pkg.Name)
+		}
+	}
+
+	for packageBase, change := range changes {
+		_, exists := aurPackageBases[packageBase]
+		if err := s.applyPackageBaseChanges(packageBase, change, !exists); err != nil {
+			log.Printf("Error: Failed to apply changes of package base %s: %s\n", packageBase, err)
+		}
+	}
+}
+
+// Marks a removed package as in the AUR again, in the package base of the list entry
+func (s *Server) revivePackage(pkg *model.Package, entry aur.PackageListEntry) error {
+	if _, err := s.DB.Cols("removed", "removed_at", "package_base", "package_base_id").
+		Where("name = ?", pkg.Name).
+		Update(&model.Package{PackageBase: entry.PackageBase, PackageBaseId: entry.PackageBaseID}); err != nil {
+		return err
+	}
+	log.Printf("Package %s of package base %s is in the AUR again\n", pkg.Name, entry.PackageBase)
+	return nil
+}
+
+func (s *Server) applyPackageBaseChanges(packageBase string, change *packageBaseChanges, packageBaseRemoved bool) error {
+	now := time.Now()
+
+	for _, name := range change.removed {
+		if _, err := s.DB.Cols("removed", "removed_at").
+			Update(&model.Package{Removed: true, RemovedAt: now}, model.Package{Name: name}); err != nil {
+			return err
+		}
+		log.Printf("Package %s of package base %s was removed from the AUR\n", name, packageBase)
+	}
+
+	for intoPackageBase, entries := range change.moved {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+			if _, err := s.DB.Cols("package_base", "package_base_id").
+				Update(&model.Package{PackageBase: entry.PackageBase, PackageBaseId: entry.PackageBaseID}, model.Package{Name: entry.Name}); err != nil {
+				return err
+			}
+		}
+
+		if packageBaseRemoved {
+			if _, err := s.DB.Insert(&model.PackageBaseMerge{
+				PackageBase:       packageBase,
+				PackageBaseId:     change.packageBaseId,
+				IntoPackageBase:   intoPackageBase,
+				IntoPackageBaseId: entries[0].PackageBaseID,
+				PackageNames:      names,
+			}); err != nil {
+				return err
+			}
+			log.Printf("Package base %s was merged into %s\n", packageBase, intoPackageBase)
+		}
+	}
+
+	if !packageBaseRemoved {
+		return nil
+	}
+
+	cancelled, err := s.DB.Where("package_base_id = ? AND status = ?", change.packageBaseId, model.STATUS_PENDING).
+		Cols("status", "finished_at").
+		Update(&model.Build{Status: model.STATUS_CANCELLED, FinishedAt: now})
+	if err != nil {
+		return err
+	}
+	if cancelled > 0 {
+		log.Printf("Cancelled %d pending builds of removed package base %s\n", cancelled, packageBase)
+	}
+
+	// It won't be processed again, the git storage maintenance deletes its repository
+	_, err = s.DB.Delete(&model.QuarantinedPackageBase{PackageBase: packageBase})
+	return err
+}
+
+// Returns the ID of the package base and the IDs of all package bases that were merged into it, recursively
+func (s *Server) getPackageBaseIdsWithMerged(packageBaseId int64) ([]int64, error) {
+	packageBaseIds := []int64{packageBaseId}
+	seen := map[int64]struct{}{packageBaseId: {}}
+
+	for frontier := packageBaseIds; len(frontier) > 0; {
+		var merges []model.PackageBaseMerge
+		if err := s.DB.Cols("package_base_id").In("into_package_base_id", frontier).Find(&merges); err != nil {
+			return nil, err
+		}
+		frontier = nil
+		for _, merge := range merges {
+			if _, ok := seen[merge.PackageBaseId]; !ok {
+				seen[merge.PackageBaseId] = struct{}{}
+				frontier = append(frontier, merge.PackageBaseId)
+				packageBaseIds = append(packageBaseIds, merge.PackageBaseId)
+			}
+		}
+	}
+
+	return packageBaseIds, nil
+}
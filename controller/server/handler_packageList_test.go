@@ -0,0 +1,154 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/aur"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// Known packages before reconciling with testdata/packages-meta-v1.json
+func insertReconcileTestPackages(t *testing.T, s *Server) {
+	removedAt := time.Now().Add(-24 * time.Hour)
+	for _, pkg := range []model.Package{
+		{Name: "foo", PackageBase: "foo", PackageBaseId: 1},
+		// Deleted
+		{Name: "bar", PackageBase: "bar", PackageBaseId: 2},
+		// Merged into qux, baz-docs was deleted along the way
+		{Name: "baz", PackageBase: "baz", PackageBaseId: 3},
+		{Name: "baz-docs", PackageBase: "baz", PackageBaseId: 3},
+		// Split into its own package base, multi still exists
+		{Name: "multi-a", PackageBase: "multi", PackageBaseId: 5},
+		{Name: "multi-b", PackageBase: "multi", PackageBaseId: 5},
+		// Restored after it was deleted
+		{Name: "old", PackageBase: "old", PackageBaseId: 7, Removed: true, RemovedAt: removedAt},
+		{Name: "gone", PackageBase: "gone", PackageBaseId: 8, Removed: true, RemovedAt: removedAt},
+	} {
+		pkg := pkg
+		pkg.Version = "1-1"
+		insert(t, s, &pkg)
+	}
+
+	for packageBaseId, packageBase := range map[int64]string{1: "foo", 2: "bar", 3: "baz", 5: "multi"} {
+		insert(t, s, &model.Build{PackageBase: packageBase, PackageBaseId: packageBaseId, Status: model.STATUS_PENDING, Type: model.TYPE_PACKAGE})
+	}
+}
+
+func getTestPackage(t *testing.T, s *Server, name string) model.Package {
+	var pkg model.Package
+	exists, err := s.DB.Where("name = ?", name).Get(&pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatalf("Package %s does not exist", name)
+	}
+	return pkg
+}
+
+func getTestBuildStatus(t *testing.T, s *Server, packageBaseId int64) model.BuildStatus {
+	var build model.Build
+	if _, err := s.DB.Where("package_base_id = ?", packageBaseId).Get(&build); err != nil {
+		t.Fatal(err)
+	}
+	return build.Status
+}
+
+func TestReconcilePackages(t *testing.T) {
+	s := newTestServer(t)
+	s.PackageList = &aur.FilePackageListSource{Path: filepath.Join("testdata", "packages-meta-v1.json")}
+	insertReconcileTestPackages(t, s)
+
+	s.ReconcilePackages()
+
+	tests := []struct {
+		name          string
+		packageBase   string
+		packageBaseId int64
+		removed       bool
+	}{
+		{"foo", "foo", 1, false},
+		{"bar", "bar", 2, true},
+		{"baz", "qux", 4, false},
+		{"baz-docs", "baz", 3, true},
+		{"multi-a", "multi", 5, false},
+		{"multi-b", "multi-b", 6, false},
+		{"old", "old", 7, false},
+		{"gone", "gone", 8, true},
+	}
+	for _, test := range tests {
+		pkg := getTestPackage(t, s, test.name)
+		if pkg.PackageBase != test.packageBase || pkg.PackageBaseId != test.packageBaseId || pkg.Removed != test.removed {
+			t.Errorf("%s: package base %s (%d), removed %t; want %s (%d), removed %t", test.name,
+				pkg.PackageBase, pkg.PackageBaseId, pkg.Removed, test.packageBase, test.packageBaseId, test.removed)
+		}
+		if pkg.Removed == pkg.RemovedAt.IsZero() {
+			t.Errorf("%s: removed %t, but removed at %s", test.name, pkg.Removed, pkg.RemovedAt)
+		}
+	}
+
+	// Only builds of package bases that are gone are cancelled
+	for packageBaseId, status := range map[int64]model.BuildStatus{
+		1: model.STATUS_PENDING,
+		2: model.STATUS_CANCELLED,
+		3: model.STATUS_CANCELLED,
+		5: model.STATUS_PENDING,
+	} {
+		if buildStatus := getTestBuildStatus(t, s, packageBaseId); buildStatus != status {
+			t.Errorf("Build of package base %d has status %d, want %d", packageBaseId, buildStatus, status)
+		}
+	}
+
+	var merges []model.PackageBaseMerge
+	if err := s.DB.Find(&merges); err != nil {
+		t.Fatal(err)
+	}
+	if len(merges) != 1 {
+		t.Fatalf("Got %d merges, want 1: %+v", len(merges), merges)
+	}
+	merge := merges[0]
+	if merge.PackageBase != "baz" || merge.PackageBaseId != 3 || merge.IntoPackageBase != "qux" || merge.IntoPackageBaseId != 4 ||
+		!reflect.DeepEqual(merge.PackageNames, []string{"baz"}) {
+		t.Errorf("Merge = %+v, want baz (3) into qux (4) with baz", merge)
+	}
+	// The history of the merged package base belongs to the new one
+	packageBaseIds, err := s.getPackageBaseIdsWithMerged(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(packageBaseIds, []int64{4, 3}) {
+		t.Errorf("getPackageBaseIdsWithMerged(4) = %v, want [4 3]", packageBaseIds)
+	}
+
+	// Reconciling again changes nothing
+	s.ReconcilePackages()
+	if count, err := s.DB.Count(new(model.PackageBaseMerge)); err != nil || count != 1 {
+		t.Errorf("Got %d merges after reconciling again, want 1: %v", count, err)
+	}
+	if pkg := getTestPackage(t, s, "old"); pkg.Removed {
+		t.Error("old was removed again")
+	}
+}
+
+func TestReconcilePackagesIgnoresTruncatedList(t *testing.T) {
+	s := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "packages-meta-v1.json")
+	if err := ioutil.WriteFile(path, []byte(`[{"Name": "foo", "PackageBaseID": 1, "PackageBase": "foo"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.PackageList = &aur.FilePackageListSource{Path: path}
+	insertReconcileTestPackages(t, s)
+
+	s.ReconcilePackages()
+
+	if pkg := getTestPackage(t, s, "bar"); pkg.Removed {
+		t.Error("bar was removed by a truncated package list")
+	}
+	if status := getTestBuildStatus(t, s, 2); status != model.STATUS_PENDING {
+		t.Errorf("Build of bar has status %d, want %d", status, model.STATUS_PENDING)
+	}
+}
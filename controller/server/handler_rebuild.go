@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hashworks/aur-ci/controller/model"
+	"github.com/hashworks/aur-ci/controller/pacman"
+)
+
+// Amount of official packages inserted with a single statement
+const REPOSITORY_PACKAGE_INSERT_BATCH_SIZE = 100
+
+// Compares the official packages of the sync databases with the stored ones and enqueues rebuilds of AUR package bases
+// that depend on packages with breaking changes, f.e. a new soname. Stores the new state afterwards.
+// Nothing is rebuilt on the first run, since there is nothing to compare with.
+func (s *Server) rebuildDependentsOfChangedPackages(databases []*pacman.Database) {
+	var storedPackages []model.RepositoryPackage
+	if err := s.DB.Find(&storedPackages); err != nil {
+		log.Println("Error: Failed to get official packages from database: " + err.Error())
+		return
+	}
+
+	oldPackages := make(map[string]pacman.Package, len(storedPackages))
+	for _, pkg := range storedPackages {
+		oldPackages[pkg.Name] = pacman.Package{Name: pkg.Name, Version: pkg.Version, Provides: pkg.Provides}
+	}
+	newPackages := make(map[string]pacman.Package)
+	repositories := make(map[string]string)
+	for _, database := range databases {
+		for _, pkg := range database.Packages {
+			// Like pacman, the first repository wins
+			if _, ok := newPackages[pkg.Name]; !ok {
+				newPackages[pkg.Name] = pkg
+				repositories[pkg.Name] = database.Name
+			}
+		}
+	}
+
+	if len(oldPackages) > 0 {
+		changes := pacman.FindBreakingChanges(oldPackages, newPackages, s.RebuildInterpreters)
+		if len(changes) > 0 {
+			s.enqueueRebuilds(changes)
+		}
+	}
+
+	if err := s.storeRepositoryPackages(oldPackages, newPackages, repositories); err != nil {
+		log.Println("Error: Failed to store official packages: " + err.Error())
+	}
+}
+
+// Enqueues rebuilds of the latest commits of all package bases that depend on the changed packages or their sonames
+func (s *Server) enqueueRebuilds(changes []pacman.BreakingChange) {
+	reasons := make(map[string][]string)
+	for _, change := range changes {
+		reason := fmt.Sprintf("%s %s -> %s: %s", change.Name, change.OldVersion, change.NewVersion, change.Reason)
+		log.Printf("Official package %s\n", reason)
+
+		dependents := make(map[string]struct{})
+		for _, name := range append([]string{change.Name}, change.Sonames...) {
+			packageBases, err := s.getDependentPackageBases(name)
+			if err != nil {
+				log.Printf("Error: Failed to get dependents of %s: %s\n", name, err)
+				continue
+			}
+			for _, packageBase := range packageBases {
+				dependents[packageBase] = struct{}{}
+			}
+		}
+		for packageBase := range dependents {
+			reasons[packageBase] = append(reasons[packageBase], reason)
+		}
+	}
+
+	packageBases := make([]string, 0, len(reasons))
+	for packageBase := range reasons {
+		packageBases = append(packageBases, packageBase)
+	}
+	sort.Strings(packageBases)
+
+	enqueued := 0
+	for _, packageBase := range packageBases {
+		packageBaseId, exists, err := s.getPackageBaseId(packageBase)
+		if err != nil {
+			log.Printf("Error: Failed to get package base %s from database: %s\n", packageBase, err)
+			continue
+		}
+		var commit model.Commit
+		if exists {
+			exists, err = s.getLastCommitOfPackageBaseId(packageBaseId).Cols("id").Get(&commit)
+			if err != nil {
+				log.Printf("Error: Failed to get last commit of package base %s from database: %s\n", packageBase, err)
+				continue
+			}
+		}
+		if !exists {
+			continue
+		}
+
+		inserted, err := s.insertTriggeredBuild(&model.Build{
+			PackageBase:   packageBase,
+			PackageBaseId: packageBaseId,
+			CommitId:      commit.Id,
+			Type:          model.TYPE_REBUILD,
+			TriggerReason: "Rebuild for " + strings.Join(reasons[packageBase], "; "),
+		})
+		if err != nil {
+			log.Printf("Error: Failed to insert rebuild of package base %s: %s\n", packageBase, err)
+			continue
+		}
+		if inserted {
+			enqueued++
+		}
+	}
+
+	log.Printf("Enqueued rebuilds of %d package bases for %d changed official packages\n", enqueued, len(changes))
+}
+
+// Stores the official packages that are new or changed and deletes the ones that are gone
+func (s *Server) storeRepositoryPackages(oldPackages map[string]pacman.Package, newPackages map[string]pacman.Package, repositories map[string]string) error {
+	var inserts []model.RepositoryPackage
+	for name, newPackage := range newPackages {
+		repositoryPackage := model.RepositoryPackage{
+			Name:       name,
+			Repository: repositories[name],
+			Version:    newPackage.Version,
+			Provides:   newPackage.Provides,
+		}
+
+		oldPackage, ok := oldPackages[name]
+		if !ok {
+			inserts = append(inserts, repositoryPackage)
+			continue
+		}
+		if oldPackage.Version == newPackage.Version {
+			continue
+		}
+		// Provisions can drop to none. AllCols applies to a condition bean as well, so the condition is explicit.
+		if _, err := s.DB.AllCols().Where("name = ?", name).Update(&repositoryPackage); err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(inserts); start += REPOSITORY_PACKAGE_INSERT_BATCH_SIZE {
+		end := start + REPOSITORY_PACKAGE_INSERT_BATCH_SIZE
+		if end > len(inserts) {
+			end = len(inserts)
+		}
+		if _, err := s.DB.Insert(inserts[start:end]); err != nil {
+			return err
+		}
+	}
+
+	for name := range oldPackages {
+		if _, ok := newPackages[name]; !ok {
+			if _, err := s.DB.Delete(&model.RepositoryPackage{Name: name}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+func TestRebuildDependentsOfChangedPackages(t *testing.T) {
+	s := newTestServer(t)
+	s.RebuildInterpreters = []string{"python"}
+	// Stores the first state, there is nothing to compare with
+	loadTestSyncDatabases(t, s)
+
+	dependencies := map[string][]string{
+		"foo": {"openssl"},
+		"bar": {"libcrypto.so=1.1-64"},
+		"baz": {"bash"},
+		"qux": {"python>=3.9"},
+	}
+	for i, packageBase := range []string{"foo", "bar", "baz", "qux"} {
+		insert(t, s,
+			&model.Package{Name: packageBase, PackageBase: packageBase, PackageBaseId: int64(i + 1), Version: "1.0-1", Depends: dependencies[packageBase]},
+			&model.Commit{PackageBaseId: int64(i + 1), Hash: fmt.Sprintf("%040d", i), CommitterWhen: time.Now()},
+		)
+	}
+	if count, err := s.DB.Count(&model.Build{}); err != nil || count > 0 {
+		t.Fatalf("%d builds before the update, %v; want none", count, err)
+	}
+
+	// openssl got a new soname, python a new minor version and bash a patch release
+	s.SyncDatabasePath = "../pacman/testdata/sync-updated"
+	s.LoadSyncDatabases()
+
+	var builds []model.Build
+	if err := s.DB.Asc("package_base").Find(&builds); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"bar": "Rebuild for openssl 1.1.1.k-1 -> 3.0.0-1: changed libcrypto.so, libssl.so",
+		"foo": "Rebuild for openssl 1.1.1.k-1 -> 3.0.0-1: changed libcrypto.so, libssl.so",
+		"qux": "Rebuild for python 3.9.2-1 -> 3.10.0-1: interpreter version changed from 3.9 to 3.10",
+	}
+	if len(builds) != len(expected) {
+		t.Fatalf("%d builds were enqueued, want %d: %+v", len(builds), len(expected), builds)
+	}
+	for _, build := range builds {
+		if build.Type != model.TYPE_REBUILD || build.Status != model.STATUS_PENDING {
+			t.Errorf("Build of %s has type %d and status %d, want a pending rebuild", build.PackageBase, build.Type, build.Status)
+		}
+		if build.TriggerReason != expected[build.PackageBase] {
+			t.Errorf("TriggerReason of %s = %q, want %q", build.PackageBase, build.TriggerReason, expected[build.PackageBase])
+		}
+	}
+
+	var openssl model.RepositoryPackage
+	if _, err := s.DB.Where("name = ?", "openssl").Get(&openssl); err != nil || openssl.Version != "3.0.0-1" {
+		t.Errorf("Stored openssl %s, %v; want 3.0.0-1", openssl.Version, err)
+	}
+
+	// The same state again enqueues nothing
+	s.LoadSyncDatabases()
+	if count, err := s.DB.Count(&model.Build{}); err != nil || count != int64(len(expected)) {
+		t.Errorf("%d builds after loading the same state again, %v; want %d", count, err, len(expected))
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/aur"
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+// .SRCINFOs are parsed in batches of this size when backfilling the VCS flag
+const VCS_BACKFILL_BATCH_SIZE = 500
+
+// A package base of VCS packages, the popularity is the highest of its packages
+type vcsPackageBase struct {
+	packageBase   string
+	packageBaseId int64
+	popularity    float64
+}
+
+// Enqueues rebuilds of VCS package bases whose last build is older than their rebuild interval, since their upstream
+// may have changed without an AUR commit. Popular package bases go first, at most VCSRebuildsPerRun per run.
+// Meant to run regularly, but never concurrently.
+func (s *Server) ScheduleVCSRebuilds() {
+	if s.VCSRebuildInterval <= 0 || s.VCSRebuildsPerRun <= 0 {
+		return
+	}
+
+	packageBases, err := s.getVCSPackageBases()
+	if err != nil {
+		log.Println("Error: Failed to get VCS package bases: " + err.Error())
+		return
+	}
+
+	enqueued := 0
+	for _, packageBase := range packageBases {
+		if enqueued >= s.VCSRebuildsPerRun {
+			break
+		}
+
+		due, err := s.isVCSRebuildDue(&packageBase)
+		if err != nil {
+			log.Printf("Error: Failed to check if a rebuild of VCS package base %s is due: %s\n", packageBase.packageBase, err)
+			continue
+		}
+		if !due {
+			continue
+		}
+
+		var commit model.Commit
+		exists, err := s.getLastCommitOfPackageBaseId(packageBase.packageBaseId).Cols("id").Get(&commit)
+		if err != nil {
+			log.Printf("Error: Failed to get last commit of package base %s from database: %s\n", packageBase.packageBase, err)
+			continue
+		}
+		if !exists {
+			continue
+		}
+
+		inserted, err := s.insertTriggeredBuild(&model.Build{
+			PackageBase:   packageBase.packageBase,
+			PackageBaseId: packageBase.packageBaseId,
+			CommitId:      commit.Id,
+			Type:          model.TYPE_VCS,
+			TriggerReason: "Scheduled rebuild of VCS package",
+		})
+		if err != nil {
+			log.Printf("Error: Failed to insert rebuild of VCS package base %s: %s\n", packageBase.packageBase, err)
+			continue
+		}
+		if inserted {
+			enqueued++
+		}
+	}
+
+	log.Printf("Enqueued rebuilds of %d of %d VCS package bases\n", enqueued, len(packageBases))
+}
+
+// Sets the VCS flag of stored .SRCINFOs that were parsed before the flag existed. Only .SRCINFOs that mention
+// a VCS protocol are parsed again, so running it again is cheap. Meant to run at startup.
+func (s *Server) BackfillSRCINFOVCS() {
+	var conditions []string
+	var args []interface{}
+	for _, protocol := range aur.VCS_PROTOCOLS {
+		conditions = append(conditions, "content LIKE ?", "content LIKE ?")
+		args = append(args, "%"+protocol+"+%", "%"+protocol+"://%")
+	}
+	candidates := "(" + strings.Join(conditions, " OR ") + ")"
+
+	updated := 0
+	lastCommitId := int64(0)
+	for {
+		var commitSRCINFOs []model.CommitSRCINFO
+		if err := s.DB.Cols("commit_id", "content").
+			Where("vcs = ? AND commit_id > ?", false, lastCommitId).
+			And(candidates, args...).
+			Asc("commit_id").
+			Limit(VCS_BACKFILL_BATCH_SIZE).
+			Find(&commitSRCINFOs); err != nil {
+			log.Println("Error: Failed to get .SRCINFOs from database: " + err.Error())
+			return
+		}
+		if len(commitSRCINFOs) == 0 {
+			break
+		}
+		lastCommitId = commitSRCINFOs[len(commitSRCINFOs)-1].CommitId
+
+		var vcsCommitIds []int64
+		for _, commitSRCINFO := range commitSRCINFOs {
+			srcinfo, err := aur.ParseSRCINFO(strings.NewReader(commitSRCINFO.Content))
+			if err != nil {
+				log.Printf("Warning: Failed to parse stored .SRCINFO of commit %d: %s\n", commitSRCINFO.CommitId, err)
+				continue
+			}
+			if srcinfo.HasVCSSource() {
+				vcsCommitIds = append(vcsCommitIds, commitSRCINFO.CommitId)
+			}
+		}
+		if len(vcsCommitIds) == 0 {
+			continue
+		}
+
+		count, err := s.DB.Cols("vcs").In("commit_id", vcsCommitIds).Update(&model.CommitSRCINFO{VCS: true})
+		if err != nil {
+			log.Println("Error: Failed to update .SRCINFOs in database: " + err.Error())
+			return
+		}
+		updated += int(count)
+	}
+
+	if updated > 0 {
+		log.Printf("Marked %d stored .SRCINFOs as having VCS sources\n", updated)
+	}
+}
+
+// Returns the package bases that are named like VCS packages or whose latest .SRCINFO has VCS sources,
+// the most popular first
+func (s *Server) getVCSPackageBases() ([]vcsPackageBase, error) {
+	var packages []model.Package
+	if err := s.DB.Table("package").Cols("package_base", "package_base_id", "popularity").
+		Where("removed = ?", false).
+		Find(&packages); err != nil {
+		return nil, err
+	}
+
+	var withVCSSources []string
+	if err := s.DB.Table("commit_srcinfo").Distinct("package_base").Where("vcs = ?", true).Find(&withVCSSources); err != nil {
+		return nil, err
+	}
+	mightHaveVCSSources := make(map[string]struct{}, len(withVCSSources))
+	for _, packageBase := range withVCSSources {
+		mightHaveVCSSources[packageBase] = struct{}{}
+	}
+
+	packageBases := make(map[string]*vcsPackageBase)
+	var candidates []*vcsPackageBase
+	for _, pkg := range packages {
+		if packageBase, ok := packageBases[pkg.PackageBase]; ok {
+			if pkg.Popularity > packageBase.popularity {
+				packageBase.popularity = pkg.Popularity
+			}
+			continue
+		}
+		packageBase := &vcsPackageBase{
+			packageBase:   pkg.PackageBase,
+			packageBaseId: pkg.PackageBaseId,
+			popularity:    pkg.Popularity,
+		}
+		packageBases[pkg.PackageBase] = packageBase
+		candidates = append(candidates, packageBase)
+	}
+
+	var vcsPackageBases []vcsPackageBase
+	for _, packageBase := range candidates {
+		if !aur.IsVCSPackageBaseName(packageBase.packageBase) {
+			if _, ok := mightHaveVCSSources[packageBase.packageBase]; !ok {
+				continue
+			}
+			// Older commits might have had VCS sources
+			hasVCSSources, err := s.latestSRCINFOHasVCSSources(packageBase.packageBaseId)
+			if err != nil {
+				return nil, err
+			}
+			if !hasVCSSources {
+				continue
+			}
+		}
+		vcsPackageBases = append(vcsPackageBases, *packageBase)
+	}
+
+	sort.Slice(vcsPackageBases, func(i, j int) bool {
+		if vcsPackageBases[i].popularity != vcsPackageBases[j].popularity {
+			return vcsPackageBases[i].popularity > vcsPackageBases[j].popularity
+		}
+		return vcsPackageBases[i].packageBase < vcsPackageBases[j].packageBase
+	})
+	return vcsPackageBases, nil
+}
+
+func (s *Server) latestSRCINFOHasVCSSources(packageBaseId int64) (bool, error) {
+	var commit model.Commit
+	exists, err := s.getLastCommitOfPackageBaseId(packageBaseId).Cols("id").Get(&commit)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	var hasVCSSources bool
+	_, err = s.DB.Table("commit_srcinfo").Cols("vcs").Where("commit_id = ?", commit.Id).Get(&hasVCSSources)
+	return hasVCSSources, err
+}
+
+// Returns true if the last build of the package base is finished or out of the queue and older than its rebuild interval
+func (s *Server) isVCSRebuildDue(packageBase *vcsPackageBase) (bool, error) {
+	var lastBuild model.Build
+	exists, err := s.DB.Cols("status", "type", "created_at").
+		Where("package_base_id = ?", packageBase.packageBaseId).
+		Desc("created_at").
+		Get(&lastBuild)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		// Not processed yet, the first build comes with its first commit
+		return false, nil
+	}
+	if isQueuedBuild(&lastBuild) || lastBuild.Status == model.STATUS_BUILDING {
+		return false, nil
+	}
+
+	interval := s.VCSRebuildInterval
+	packageBaseConfig := model.PackageBaseConfig{
+		PackageBase: packageBase.packageBase,
+	}
+	if _, err := s.DB.Cols("vcs_rebuild_interval_seconds").Get(&packageBaseConfig); err != nil {
+		return false, err
+	}
+	if packageBaseConfig.VCSRebuildIntervalSeconds > 0 {
+		interval = time.Duration(packageBaseConfig.VCSRebuildIntervalSeconds) * time.Second
+	}
+
+	return time.Since(lastBuild.CreatedAt) >= interval, nil
+}
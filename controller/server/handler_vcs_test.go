@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashworks/aur-ci/controller/model"
+)
+
+func testSRCINFO(packageBase string, pkgdesc string, source string) string {
+	return fmt.Sprintf("pkgbase = %s\n\tpkgdesc = %s\n\tpkgver = 1.0\n\tpkgrel = 1\n\tarch = x86_64\n\tsource = %s\n\npkgname = %s\n",
+		packageBase, pkgdesc, source, packageBase)
+}
+
+func TestBackfillSRCINFOVCS(t *testing.T) {
+	s := newTestServer(t)
+
+	// Parsed before the VCS flag existed
+	tests := []struct {
+		packageBase string
+		content     string
+		vcs         bool
+	}{
+		{"foo", testSRCINFO("foo", "Foo", "foo::git+https://example.com/foo.git#branch=main"), true},
+		{"bar", testSRCINFO("bar", "Bar", "svn://example.com/bar/trunk"), true},
+		{"baz", testSRCINFO("baz", "Mirrors git+https repositories", "https://example.com/baz-1.0.tar.gz"), false},
+		{"qux", testSRCINFO("qux", "Qux", "https://example.com/qux-1.0.tar.gz"), false},
+	}
+	for i, test := range tests {
+		commit := model.Commit{PackageBaseId: int64(i + 1), Hash: fmt.Sprintf("%040d", i), CommitterWhen: time.Now()}
+		insert(t, s,
+			&model.Package{Name: test.packageBase, PackageBase: test.packageBase, PackageBaseId: int64(i + 1), Version: "1.0-1"},
+			&commit,
+		)
+		insert(t, s, &model.CommitSRCINFO{CommitId: commit.Id, PackageBase: test.packageBase, Content: test.content})
+	}
+
+	s.BackfillSRCINFOVCS()
+
+	for _, test := range tests {
+		var commitSRCINFO model.CommitSRCINFO
+		if _, err := s.DB.Cols("vcs").Where("package_base = ?", test.packageBase).Get(&commitSRCINFO); err != nil {
+			t.Fatal(err)
+		}
+		if commitSRCINFO.VCS != test.vcs {
+			t.Errorf("%s: VCS = %t, want %t", test.packageBase, commitSRCINFO.VCS, test.vcs)
+		}
+	}
+
+	packageBases, err := s.getVCSPackageBases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, packageBase := range packageBases {
+		names = append(names, packageBase.packageBase)
+	}
+	if len(names) != 2 || names[0] != "bar" || names[1] != "foo" {
+		t.Errorf("getVCSPackageBases() = %v, want [bar foo]", names)
+	}
+}
+
+func TestIsVCSRebuildDueIgnoresBuildsOutOfTheQueue(t *testing.T) {
+	s := newTestServer(t)
+	s.VCSRebuildInterval = time.Hour
+	packageBase := &vcsPackageBase{packageBase: "foo", packageBaseId: 1}
+
+	build := insertPendingBuild(t, s, model.TYPE_PACKAGE, time.Hour)
+	if due, err := s.isVCSRebuildDue(packageBase); err != nil || due {
+		t.Errorf("isVCSRebuildDue() = %t, %v with a queued build; want false", due, err)
+	}
+
+	setBuildAge(t, s, build, 2*PENDING_PACKAGE_BUILD_MAX_AGE)
+	if due, err := s.isVCSRebuildDue(packageBase); err != nil || !due {
+		t.Errorf("isVCSRebuildDue() = %t, %v with a build out of the queue; want true", due, err)
+	}
+}
@@ -14,6 +14,16 @@ const MAX_VM_COUNT = 1
 const BUILD_QUEUE_TRESHOLD = 10
 const HETZNER_SERVER_TYPE = "cpx11"    // https://api.hetzner.cloud/v1/server_types
 const HETZNER_SERVER_LOCATION = "nbg1" // https://api.hetzner.cloud/v1/locations
+const MAX_REQUEST_WORK_WAIT_SECONDS = 60
+const REQUEST_WORK_POLL_INTERVAL = time.Second
+const MIN_WORKER_DISK_AVAILABLE = 5 * 1024 * 1024 * 1024   // Bytes
+const MIN_WORKER_MEMORY_AVAILABLE = 1 * 1024 * 1024 * 1024 // Bytes
+const LOST_BUILD_GRACE_PERIOD = 2 * time.Minute
+
+var WORKER_HEARTBEAT_COLUMNS = []string{
+	"free_slots", "running_build_ids", "cpu_count", "load_average", "memory_total", "memory_available",
+	"disk_total", "disk_available", "sandbox", "sandbox_version", "architecture", "worker_version",
+}
 
 func (s *Server) markBuildsOfWorkerAsPending(worker *model.Worker) {
 	_, err := s.DB.
@@ -29,6 +39,68 @@ func (s *Server) markBuildsOfWorkerAsPending(worker *model.Worker) {
 	}
 }
 
+// Marks builds that are assigned to the worker but not reported as running in its last heartbeat as pending.
+// Builds assigned shortly before the heartbeat was sent are ignored.
+func (s *Server) markLostBuildsOfWorkerAsPending(worker *model.Worker) {
+	var assignedBuilds []model.Build
+	err := s.DB.
+		Where("worker_id = ? AND status = ? AND DATETIME(started_at) < ?",
+			worker.Id,
+			model.STATUS_BUILDING,
+			time.Now().UTC().Add(-LOST_BUILD_GRACE_PERIOD)).
+		Find(&assignedBuilds)
+	if err != nil {
+		log.Println("Error: Failed to find builds of worker:", err)
+		return
+	}
+
+ASSIGNED_BUILDS:
+	for _, assignedBuild := range assignedBuilds {
+		for _, runningBuildId := range worker.RunningBuildIds {
+			if assignedBuild.Id == runningBuildId {
+				continue ASSIGNED_BUILDS
+			}
+		}
+
+		log.Printf("Warning: Worker %s lost build %d of package base %s, marking it as pending.\n", worker.Name, assignedBuild.Id, assignedBuild.PackageBase)
+
+		// MustCols would apply to a condition bean as well
+		_, err := s.DB.ID(assignedBuild.Id).MustCols("worker_id").Update(&model.Build{
+			Status:   model.STATUS_PENDING,
+			WorkerId: 0,
+		})
+		if err != nil {
+			log.Println("Error: Failed to update lost build of worker:", err)
+		}
+	}
+}
+
+// Returns the IDs of builds the worker reported as running that were superseded or cancelled in the meantime
+func (s *Server) getBuildIdsToCancel(worker *model.Worker) ([]int64, error) {
+	buildIds := []int64{}
+	if len(worker.RunningBuildIds) == 0 {
+		return buildIds, nil
+	}
+	err := s.DB.Table("build").Cols("id").
+		Where("worker_id = ?", worker.Id).
+		In("id", worker.RunningBuildIds).
+		In("status", model.STATUS_SUPERSEDED, model.STATUS_CANCELLED).
+		Find(&buildIds)
+	return buildIds, err
+}
+
+// Returns false if the last heartbeat of the worker reported too little resources to start another build.
+// Workers that don't report their resources are always considered to have capacity.
+func workerHasCapacity(worker *model.Worker) bool {
+	if worker.DiskTotal > 0 && worker.DiskAvailable < MIN_WORKER_DISK_AVAILABLE {
+		return false
+	}
+	if worker.MemoryTotal > 0 && worker.MemoryAvailable < MIN_WORKER_MEMORY_AVAILABLE {
+		return false
+	}
+	return true
+}
+
 // Remove Hetzner VMs that are older than 55 minutes
 // Also marks their builds as pending, putting them back into the queue.
 // TODO: This way builds that should receive a "timeout" state might get back into the queue. Mark as "timeout" if runtime > threshold?
@@ -1,11 +1,17 @@
 package server
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-contrib/cache/persistence"
 	"github.com/gin-gonic/gin"
+	"github.com/hashworks/aur-ci/controller/aur"
+	"github.com/hashworks/aur-ci/controller/model"
+	"github.com/hashworks/aur-ci/controller/pacman"
 	"github.com/hetznercloud/hcloud-go/hcloud"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"github.com/swaggo/gin-swagger/swaggerFiles"
@@ -13,12 +19,28 @@ import (
 )
 
 type Server struct {
-	DB                *xorm.Engine
-	HetznerClient     *hcloud.Client
-	HetznerSSHKeyName *string
-	ExternalURI       *string
-	GitStoragePath    *string
-	cacheStore        *persistence.InMemoryStore
+	DB                     *xorm.Engine
+	HetznerClient          *hcloud.Client
+	HetznerSSHKeyName      *string
+	ExternalURI            *string
+	GitStoragePath         *string
+	AdminTokens            map[string]string // token -> admin name
+	DefaultTimeouts        model.Timeouts
+	MaxTotalTimeoutSeconds int
+	Mirror                 http.Handler          // Optional caching proxy of a pacman mirror
+	NamcapWarningsStatus   bool                  // Mark builds with namcap errors or warnings as STATUS_BUILD_WARNINGS
+	CheckReproducibility   bool                  // Build all packages twice, otherwise only the ones configured to
+	SupersedeRunningBuilds bool                  // Abort running builds of a package base if a newer commit arrives
+	SyncDatabasePath       string                // Directory of sync databases like core.db, empty to pass dependencies unresolved
+	SyncRepositories       []string              // Official repositories in the order of pacman.conf
+	RebuildInterpreters    []string              // Official packages whose dependents are rebuilt on major.minor updates
+	PackageList            aur.PackageListSource // Optional, used to detect removed and merged packages
+	VCSRebuildInterval     time.Duration         // Default time between scheduled rebuilds of VCS packages, 0 to disable
+	VCSRebuildsPerRun      int                   // Maximum amount of VCS rebuilds enqueued per scheduler run
+	cacheStore             *persistence.InMemoryStore
+	assignMutex            sync.Mutex // Held while pending builds are assigned or triggered builds are inserted
+	syncDatabases          []pacman.PackageSource
+	syncDatabasesMutex     sync.RWMutex
 }
 
 func CORS() gin.HandlerFunc {
@@ -28,6 +50,26 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
+const ADMIN_NAME_CONTEXT_KEY = "admin"
+
+// Requires a valid admin token in the Authorization header ("Bearer <token>").
+// The name of the admin is stored in the context, so we can record who did what.
+func (s *Server) AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if len(token) > 0 {
+			for adminToken, adminName := range s.AdminTokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+					c.Set(ADMIN_NAME_CONTEXT_KEY, adminName)
+					c.Next()
+					return
+				}
+			}
+		}
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
+
 func (s *Server) NewRouter() *gin.Engine {
 	router := gin.Default()
 
@@ -46,10 +88,44 @@ func (s *Server) NewRouter() *gin.Engine {
 	apiV1 := api.Group("/v1")
 	apiV1.POST("/reportPackageModification", s.apiV1ReportPackageModification)
 
+	buildV1 := apiV1.Group("/build")
+	buildV1.GET("/:id/artifacts", s.apiV1BuildGetArtifacts)
+	buildV1.GET("/:id/namcap", s.apiV1BuildGetNamcapFindings)
+	buildV1.GET("/:id/filesystemChanges", s.apiV1BuildGetFilesystemChanges)
+
+	commitV1 := apiV1.Group("/commit")
+	commitV1.GET("/:id/reproducibility", s.apiV1CommitGetReproducibilityResults)
+	commitV1.GET("/:id/srcinfo", s.apiV1CommitGetSRCINFO)
+
+	packageBaseV1 := apiV1.Group("/packageBase")
+	packageBaseV1.GET("/:packageBase/builds", s.apiV1PackageBaseGetBuilds)
+	packageBaseV1.GET("/:packageBase/merges", s.apiV1PackageBaseGetMerges)
+
+	repositoryStatsV1 := apiV1.Group("/repositoryStats")
+	repositoryStatsV1.GET("", s.apiV1GetRepositoryStats)
+	repositoryStatsV1.GET("/:packageBase", s.apiV1GetRepositoryStatsOfPackageBase)
+
 	workerV1 := apiV1.Group("/worker")
 	workerV1.POST("/heartbeat/:hostname", s.apiV1WorkerHeartbeat)
 	workerV1.GET("/requestWork", s.apiV1WorkerRequestWork)
 	workerV1.PUT("/reportWorkResult", s.apiV1WorkerReportWorkResult)
 
+	adminV1 := apiV1.Group("/admin")
+	adminV1.Use(s.AdminAuth())
+	adminV1.GET("/packageBaseConfig/:packageBase", s.apiV1AdminGetPackageBaseConfig)
+	adminV1.PUT("/packageBaseConfig/:packageBase", s.apiV1AdminPutPackageBaseConfig)
+	adminV1.DELETE("/packageBaseConfig/:packageBase", s.apiV1AdminDeletePackageBaseConfig)
+	adminV1.GET("/quarantine", s.apiV1AdminGetQuarantine)
+	adminV1.POST("/quarantine/:packageBase/retry", s.apiV1AdminRetryQuarantined)
+	adminV1.POST("/packageBase/:packageBase/build", s.apiV1AdminTriggerBuild)
+	adminV1.POST("/build/:id/rebuild", s.apiV1AdminRebuild)
+	adminV1.POST("/builds", s.apiV1AdminTriggerBuilds)
+
+	if s.Mirror != nil {
+		mirrorHandler := gin.WrapH(http.StripPrefix("/mirror", s.Mirror))
+		router.GET("/mirror/*path", mirrorHandler)
+		router.HEAD("/mirror/*path", mirrorHandler)
+	}
+
 	return router
 }
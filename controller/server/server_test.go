@@ -0,0 +1,43 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/hashworks/aur-ci/controller/model"
+	_ "github.com/mattn/go-sqlite3"
+	"xorm.io/xorm"
+)
+
+// Returns a server with an empty sqlite database and git storage
+func newTestServer(t *testing.T) *Server {
+	dir := t.TempDir()
+	engine, err := xorm.NewEngine("sqlite3", filepath.Join(dir, "aur-ci.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { engine.Close() })
+
+	// Like initializeDatabase of main
+	if err := engine.Sync2(new(model.Package), new(model.Commit), new(model.Worker), new(model.Build), new(model.WorkResult),
+		new(model.PackageBaseConfig), new(model.Artifact), new(model.NamcapFinding),
+		new(model.FilesystemChange), new(model.ReproducibilityResult),
+		new(model.CommitSRCINFO), new(model.QuarantinedPackageBase), new(model.RepositoryStats),
+		new(model.PackageBaseMerge), new(model.RepositoryPackage)); err != nil {
+		t.Fatal(err)
+	}
+
+	gitStoragePath := filepath.Join(dir, "git")
+	return &Server{
+		DB:             engine,
+		GitStoragePath: &gitStoragePath,
+	}
+}
+
+func insert(t *testing.T, s *Server, beans ...interface{}) {
+	for _, bean := range beans {
+		if _, err := s.DB.Insert(bean); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
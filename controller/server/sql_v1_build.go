@@ -7,8 +7,30 @@ import (
 	"xorm.io/xorm"
 )
 
+// Pending builds of new commits drop out of the queue after a day, rebuilds come in bulk and stay until they are built
+const PENDING_PACKAGE_BUILD_MAX_AGE = 24 * time.Hour
+
+// Condition of pending builds that are in the queue, see isQueuedBuild
+const QUEUED_BUILD_CONDITION = "status = ? AND (type <> ? OR created_at > ?)"
+
+func queuedBuildConditionArgs() []interface{} {
+	return []interface{}{model.STATUS_PENDING, model.TYPE_PACKAGE, time.Now().Add(-PENDING_PACKAGE_BUILD_MAX_AGE)}
+}
+
+// Returns true if the build is pending and in the queue, see QUEUED_BUILD_CONDITION
+func isQueuedBuild(build *model.Build) bool {
+	return build.Status == model.STATUS_PENDING &&
+		(build.Type != model.TYPE_PACKAGE || time.Since(build.CreatedAt) < PENDING_PACKAGE_BUILD_MAX_AGE)
+}
+
 func (s *Server) searchPendingPackageBuilds() *xorm.Session {
-	// Limited to the last 24h
-	return s.DB.Table("build").Where("status = ? AND type = ? AND created_at > ?", model.STATUS_PENDING, model.TYPE_PACKAGE, time.Now().AddDate(0, 0, -1)).
-		Asc("created_at")
+	// Builds of new commits go first
+	return s.DB.Table("build").Where(QUEUED_BUILD_CONDITION, queuedBuildConditionArgs()...).
+		In("type", model.TYPE_PACKAGE, model.TYPE_REBUILD, model.TYPE_VCS).
+		Asc("type", "created_at")
+}
+
+func (s *Server) searchSuccessfulBuildsOfPackageBaseIds(packageBaseIds []int64) *xorm.Session {
+	return s.DB.Table("build").In("package_base_id", packageBaseIds).In("status", model.STATUS_BUILD, model.STATUS_BUILD_WARNINGS).
+		Desc("finished_at")
 }
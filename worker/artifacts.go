@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+// PKGDEST of the embedded makepkg.conf
+const ARTIFACTS_PATH = "/home/ci/pkg"
+
+// Directory built packages are stored in, empty to only report them
+var artifacts_dir *string
+
+func isPackageFile(name string) bool {
+	return strings.Contains(name, ".pkg.tar") && !strings.HasSuffix(name, ".sig")
+}
+
+// Copies the built packages out of the sandbox and reads their metadata
+func collectArtifacts(ctx context.Context, work *model.Work, sandbox BuildSandbox) ([]model.Artifact, error) {
+	log.Printf("[%s] Collecting artifacts\n", work.PackageBase)
+
+	tarArchive, err := sandbox.CopyOut(ctx, ARTIFACTS_PATH)
+	if err != nil {
+		return nil, err
+	}
+	defer tarArchive.Close()
+
+	var artifacts []model.Artifact
+	tarReader := tar.NewReader(tarArchive)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fileName := path.Base(header.Name)
+		if header.Typeflag != tar.TypeReg || !isPackageFile(fileName) {
+			continue
+		}
+
+		sha256Sum, err := copyArtifact(work, fileName, tarReader)
+		if err != nil {
+			return nil, err
+		}
+
+		artifacts = append(artifacts, model.Artifact{
+			FileName: fileName,
+			Size:     header.Size,
+			SHA256:   sha256Sum,
+		})
+	}
+
+	for i := range artifacts {
+		if err := readPkgInfo(ctx, sandbox, &artifacts[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return artifacts, nil
+}
+
+// Returns the SHA256 sum of an artifact and stores it in the artifacts directory, if configured
+func copyArtifact(work *model.Work, fileName string, reader io.Reader) (string, error) {
+	hash := sha256.New()
+	writer := io.Writer(hash)
+
+	if len(*artifacts_dir) > 0 {
+		dir := filepath.Join(*artifacts_dir, strconv.FormatInt(work.BuildId, 10))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		file, err := os.Create(filepath.Join(dir, fileName))
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		writer = io.MultiWriter(hash, file)
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Packages are compressed with zstd by default, which bsdtar of the sandbox knows how to read
+func readPkgInfo(ctx context.Context, sandbox BuildSandbox, artifact *model.Artifact) error {
+	output, exitCode, err := sandbox.Exec(ctx, ExecOptions{
+		Cmd: []string{
+			"bsdtar", "-xOf", ARTIFACTS_PATH + "/" + artifact.FileName, ".PKGINFO",
+		},
+		User: "ci",
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if exitCode > 0 {
+		return fmt.Errorf("Failed to read .PKGINFO of %s: %s", artifact.FileName, output)
+	}
+
+	artifact.PkgInfo = string(output)
+	applyPkgInfo(artifact, artifact.PkgInfo)
+	return nil
+}
+
+// Sets the package fields of an artifact from the content of its .PKGINFO
+func applyPkgInfo(artifact *model.Artifact, pkgInfo string) {
+	scanner := bufio.NewScanner(strings.NewReader(pkgInfo))
+	for scanner.Scan() {
+		keyValue := strings.SplitN(scanner.Text(), " = ", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		switch keyValue[0] {
+		case "pkgname":
+			artifact.PackageName = keyValue[1]
+		case "pkgver":
+			// [epoch:]pkgver-pkgrel
+			separator := strings.LastIndex(keyValue[1], "-")
+			if separator < 0 {
+				artifact.PackageVersion = keyValue[1]
+			} else {
+				artifact.PackageVersion = keyValue[1][:separator]
+				artifact.PackageRelease = keyValue[1][separator+1:]
+			}
+		case "arch":
+			artifact.Architecture = keyValue[1]
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/hashworks/aur-ci/worker/model"
+	"github.com/moby/moby/client"
+)
+
+const (
+	ERROR_CATEGORY_TIMEOUT          = "timeout"
+	ERROR_CATEGORY_OUT_OF_MEMORY    = "out-of-memory"
+	ERROR_CATEGORY_BUILD            = "build"
+	ERROR_CATEGORY_REQUIRES_NETWORK = "requires-network"
+	ERROR_CATEGORY_DOCKER_DAEMON    = "docker-daemon"
+	ERROR_CATEGORY_IMAGE            = "image"
+	ERROR_CATEGORY_CANCELLED        = "cancelled"
+	ERROR_CATEGORY_INTERNAL         = "internal"
+)
+
+var errOutOfMemory = errors.New("the kernel killed a process since the build ran out of memory")
+var errRequiresNetwork = errors.New("the build failed without, but succeeded with network access")
+var errImageUnavailable = errors.New("the build image is not available")
+
+// A command of the build exited with a non-zero exit code, which is the fault of the package
+type exitCodeError struct {
+	Command  string
+	ExitCode int
+}
+
+func (e *exitCodeError) Error() string {
+	return fmt.Sprintf("%s failed with exit code %d", e.Command, e.ExitCode)
+}
+
+// Maps an error of a build step to a work result status and error category.
+// timedOutStep is the step whose own timeout was exceeded, if any, totalTimedOut tells if the whole build timed out.
+// Docker wraps context errors, so we never compare errors directly.
+func classifyError(err error, timedOutStep string, totalTimedOut bool) (model.WorkResultStatus, string) {
+	var exitCodeErr *exitCodeError
+	var unavailableErr errdefs.ErrUnavailable
+	var systemErr errdefs.ErrSystem
+
+	switch {
+	case len(timedOutStep) > 0:
+		return model.WORK_RESULT_STATUS_TIMEOUT, ERROR_CATEGORY_TIMEOUT
+	case totalTimedOut || errors.Is(err, context.DeadlineExceeded):
+		return model.WORK_RESULT_STATUS_TIMEOUT, ERROR_CATEGORY_TIMEOUT
+	case errors.Is(err, errOutOfMemory):
+		return model.WORK_RESULT_STATUS_OUT_OF_MEMORY, ERROR_CATEGORY_OUT_OF_MEMORY
+	case errors.Is(err, errRequiresNetwork):
+		return model.WORK_RESULT_STATUS_REQUIRES_NETWORK, ERROR_CATEGORY_REQUIRES_NETWORK
+	case errors.As(err, &exitCodeErr):
+		return model.WORK_RESULT_STATUS_FAILED, ERROR_CATEGORY_BUILD
+	case client.IsErrConnectionFailed(err), errors.As(err, &unavailableErr), errors.As(err, &systemErr):
+		return model.WORK_RESULT_STATUS_INTERNAL_ERROR, ERROR_CATEGORY_DOCKER_DAEMON
+	case errors.Is(err, errImageUnavailable):
+		return model.WORK_RESULT_STATUS_INTERNAL_ERROR, ERROR_CATEGORY_IMAGE
+	default:
+		return model.WORK_RESULT_STATUS_INTERNAL_ERROR, ERROR_CATEGORY_INTERNAL
+	}
+}
+
+// Sets status, error category, message and the timed out step of the work result
+func applyError(workResult *model.WorkResult, err error, timedOutStep string, totalTimedOut bool) {
+	workResult.Status, workResult.ErrorCategory = classifyError(err, timedOutStep, totalTimedOut)
+	workResult.ErrorMessage = err.Error()
+	if workResult.Status == model.WORK_RESULT_STATUS_TIMEOUT {
+		if len(timedOutStep) > 0 {
+			workResult.TimedOutStep = timedOutStep
+		} else {
+			workResult.TimedOutStep = STEP_TOTAL
+		}
+	}
+}
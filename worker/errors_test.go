@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+func TestClassifyError(t *testing.T) {
+	buildErr := &exitCodeError{Command: "makepkg --noextract", ExitCode: 2}
+
+	tests := []struct {
+		name          string
+		err           error
+		timedOutStep  string
+		totalTimedOut bool
+		status        model.WorkResultStatus
+		category      string
+	}{
+		{"step timed out", context.Canceled, STEP_BUILD, false, model.WORK_RESULT_STATUS_TIMEOUT, ERROR_CATEGORY_TIMEOUT},
+		{"step timeout wins over exit code", buildErr, STEP_CHECK, false, model.WORK_RESULT_STATUS_TIMEOUT, ERROR_CATEGORY_TIMEOUT},
+		{"total timed out", context.Canceled, "", true, model.WORK_RESULT_STATUS_TIMEOUT, ERROR_CATEGORY_TIMEOUT},
+		{"wrapped deadline", fmt.Errorf("exec: %w", context.DeadlineExceeded), "", false, model.WORK_RESULT_STATUS_TIMEOUT, ERROR_CATEGORY_TIMEOUT},
+		{"out of memory", fmt.Errorf("%w: %s", errOutOfMemory, buildErr), "", false, model.WORK_RESULT_STATUS_OUT_OF_MEMORY, ERROR_CATEGORY_OUT_OF_MEMORY},
+		{"requires network", errRequiresNetwork, "", false, model.WORK_RESULT_STATUS_REQUIRES_NETWORK, ERROR_CATEGORY_REQUIRES_NETWORK},
+		{"exit code", buildErr, "", false, model.WORK_RESULT_STATUS_FAILED, ERROR_CATEGORY_BUILD},
+		{"wrapped exit code", fmt.Errorf("pacman: %w", buildErr), "", false, model.WORK_RESULT_STATUS_FAILED, ERROR_CATEGORY_BUILD},
+		{"daemon unavailable", errdefs.Unavailable(errors.New("daemon is shutting down")), "", false, model.WORK_RESULT_STATUS_INTERNAL_ERROR, ERROR_CATEGORY_DOCKER_DAEMON},
+		{"daemon system error", errdefs.System(errors.New("no space left on device")), "", false, model.WORK_RESULT_STATUS_INTERNAL_ERROR, ERROR_CATEGORY_DOCKER_DAEMON},
+		{"image unavailable", fmt.Errorf("%w: not found", errImageUnavailable), "", false, model.WORK_RESULT_STATUS_INTERNAL_ERROR, ERROR_CATEGORY_IMAGE},
+		{"unknown", errors.New("something else"), "", false, model.WORK_RESULT_STATUS_INTERNAL_ERROR, ERROR_CATEGORY_INTERNAL},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, category := classifyError(test.err, test.timedOutStep, test.totalTimedOut)
+			if status != test.status || category != test.category {
+				t.Errorf("classifyError() = %d, %s; want %d, %s", status, category, test.status, test.category)
+			}
+		})
+	}
+}
+
+func TestApplyErrorTimedOutStep(t *testing.T) {
+	var workResult model.WorkResult
+	applyError(&workResult, context.Canceled, STEP_DOWNLOAD, false)
+	if workResult.TimedOutStep != STEP_DOWNLOAD {
+		t.Errorf("TimedOutStep = %q, want %q", workResult.TimedOutStep, STEP_DOWNLOAD)
+	}
+
+	workResult = model.WorkResult{}
+	applyError(&workResult, context.DeadlineExceeded, "", true)
+	if workResult.TimedOutStep != STEP_TOTAL {
+		t.Errorf("TimedOutStep = %q, want %q", workResult.TimedOutStep, STEP_TOTAL)
+	}
+
+	workResult = model.WorkResult{}
+	applyError(&workResult, errRequiresNetwork, "", false)
+	if len(workResult.TimedOutStep) > 0 {
+		t.Errorf("TimedOutStep = %q, want none", workResult.TimedOutStep)
+	}
+	if workResult.ErrorMessage != errRequiresNetwork.Error() {
+		t.Errorf("ErrorMessage = %q, want %q", workResult.ErrorMessage, errRequiresNetwork.Error())
+	}
+}
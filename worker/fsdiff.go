@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+// Paths the build user may write to or the sandbox manages. Changes elsewhere mean the build escaped its user.
+var FILESYSTEM_DIFF_ALLOWED_PATHS = []string{"/home/ci", "/tmp", "/var/tmp", "/dev", "/proc", "/sys", "/run",
+	"/etc/hosts", "/etc/hostname", "/etc/resolv.conf"}
+
+// Reporting stops after this many changes, a build that changes that much is suspicious enough
+const MAX_FILESYSTEM_CHANGES = 1000
+
+const FILESYSTEM_DIFF_TIMEOUT = 5 * time.Minute
+
+var filesystem_diff *bool
+
+type filesystemEntry struct {
+	fileType string
+	mode     string
+	size     string
+	mtime    string
+}
+
+// Path -> entry. Files are compared by type, mode, size and modification time, which is cheaper than hashing.
+type filesystemSnapshot map[string]filesystemEntry
+
+// Lists all files of the root filesystem of the sandbox, except allowed paths and other mounts
+func takeFilesystemSnapshot(ctx context.Context, sandbox BuildSandbox) (filesystemSnapshot, error) {
+	cmd := []string{"find", "/", "-xdev"}
+	for _, path := range FILESYSTEM_DIFF_ALLOWED_PATHS {
+		cmd = append(cmd, "-path", path, "-prune", "-o")
+	}
+	cmd = append(cmd, "-printf", `%p\t%y\t%m\t%s\t%T@\n`)
+
+	output, exitCode, err := sandbox.Exec(ctx, ExecOptions{
+		Cmd: cmd,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	// find exits with 1 if files vanished while it ran
+	if exitCode > 1 {
+		return nil, fmt.Errorf("find failed with exit code %d", exitCode)
+	}
+
+	snapshot := make(filesystemSnapshot)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		snapshot[fields[0]] = filesystemEntry{
+			fileType: fields[1],
+			mode:     fields[2],
+			size:     fields[3],
+			mtime:    fields[4],
+		}
+	}
+	return snapshot, nil
+}
+
+// Returns the changes between two snapshots, sorted by path
+func diffFilesystemSnapshots(before filesystemSnapshot, after filesystemSnapshot) []model.FilesystemChange {
+	var changes []model.FilesystemChange
+	for path, afterEntry := range after {
+		beforeEntry, ok := before[path]
+		if !ok {
+			changes = append(changes, model.FilesystemChange{
+				Path:     path,
+				Change:   model.FILESYSTEM_CHANGE_ADDED,
+				FileType: afterEntry.fileType,
+			})
+		} else if beforeEntry != afterEntry {
+			changes = append(changes, model.FilesystemChange{
+				Path:     path,
+				Change:   model.FILESYSTEM_CHANGE_MODIFIED,
+				FileType: afterEntry.fileType,
+			})
+		}
+	}
+	for path, beforeEntry := range before {
+		if _, ok := after[path]; !ok {
+			changes = append(changes, model.FilesystemChange{
+				Path:     path,
+				Change:   model.FILESYSTEM_CHANGE_REMOVED,
+				FileType: beforeEntry.fileType,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	if len(changes) > MAX_FILESYSTEM_CHANGES {
+		changes = changes[:MAX_FILESYSTEM_CHANGES]
+	}
+	return changes
+}
+
+// Compares the filesystem to the snapshot taken before the package code ran and reports the changes.
+// Only compares once, later calls do nothing.
+func (b *buildState) reportFilesystemChanges() {
+	if b.filesystemSnapshot == nil {
+		return
+	}
+	before := b.filesystemSnapshot
+	b.filesystemSnapshot = nil
+
+	// The build context might be expired already
+	ctx, cancel := context.WithTimeout(context.Background(), FILESYSTEM_DIFF_TIMEOUT)
+	defer cancel()
+
+	after, err := takeFilesystemSnapshot(ctx, b.sandbox)
+	if err != nil {
+		log.Printf("[%s] Failed to take filesystem snapshot: %s\n", b.work.PackageBase, err)
+		return
+	}
+
+	b.workResult.FilesystemChanges = diffFilesystemSnapshots(before, after)
+	if len(b.workResult.FilesystemChanges) > 0 {
+		log.Printf("[%s] Build changed %d files outside of allowed paths\n", b.work.PackageBase, len(b.workResult.FilesystemChanges))
+	}
+}
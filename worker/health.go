@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+// Set with -ldflags "-X main.version=…"
+var version = "dev"
+
+// Collects the capacity and health of this worker, reported to the controller with every heartbeat.
+// Values that can't be determined are left empty.
+func collectHeartbeat() model.Heartbeat {
+	runningBuildIds := getRunningBuildIds()
+
+	heartbeat := model.Heartbeat{
+		FreeSlots:       *work_amount - len(runningBuildIds),
+		RunningBuildIds: runningBuildIds,
+		WorkerVersion:   version,
+	}
+
+	ctx := context.Background()
+	heartbeat.Sandbox = sandbox_runtime.Name()
+	sandboxVersion, err := sandbox_runtime.Version(ctx)
+	if err != nil {
+		log.Printf("Failed to get %s version: %s\n", heartbeat.Sandbox, err)
+	} else {
+		heartbeat.SandboxVersion = sandboxVersion
+	}
+
+	heartbeat.CPUCount = runtime.NumCPU()
+	heartbeat.Architecture = getArchitecture()
+	heartbeat.LoadAverage = getLoadAverage()
+	heartbeat.MemoryTotal = getMemInfo("MemTotal:")
+	heartbeat.MemoryAvailable = getMemInfo("MemAvailable:")
+	heartbeat.DiskTotal, heartbeat.DiskAvailable = getDiskSpace(sandbox_runtime.StoragePath(ctx))
+
+	return heartbeat
+}
+
+// Returns the load average of the last minute
+func getLoadAverage() float64 {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+	loadAverage, _ := strconv.ParseFloat(fields[0], 64)
+	return loadAverage
+}
+
+// Returns the machine hardware name, f.e. x86_64
+func getArchitecture() string {
+	var uname syscall.Utsname
+	if err := syscall.Uname(&uname); err != nil {
+		return ""
+	}
+	var machine strings.Builder
+	for _, c := range uname.Machine {
+		if c == 0 {
+			break
+		}
+		machine.WriteByte(byte(c))
+	}
+	return machine.String()
+}
+
+// Returns a value of /proc/meminfo in bytes, f.e. MemAvailable:
+func getMemInfo(key string) int64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == key {
+			kibibytes, _ := strconv.ParseInt(fields[1], 10, 64)
+			return kibibytes * 1024
+		}
+	}
+	return 0
+}
+
+// Returns total and available disk space in bytes of the filesystem the path is on.
+// Falls back to the root filesystem, since we might not be allowed to access the storage path of the sandbox.
+func getDiskSpace(path string) (int64, int64) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		if path == "/" {
+			return 0, 0
+		}
+		return getDiskSpace("/")
+	}
+	return int64(stat.Blocks) * int64(stat.Bsize), int64(stat.Bavail) * int64(stat.Bsize)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+const DISK_LIMIT_MODE_STORAGE_OPT = "storage-opt"
+const DISK_LIMIT_MODE_TMPFS = "tmpfs"
+
+// Resource limits of builds the controller didn't override
+var default_resource_limits model.ResourceLimits
+var disk_limit_mode *string
+
+// Returns the default resource limits, overridden by the non-zero limits of the work
+func getResourceLimits(work *model.Work) model.ResourceLimits {
+	limits := default_resource_limits
+	if work.ResourceLimits == nil {
+		return limits
+	}
+	if work.ResourceLimits.CPUs > 0 {
+		limits.CPUs = work.ResourceLimits.CPUs
+	}
+	if work.ResourceLimits.MemoryBytes > 0 {
+		limits.MemoryBytes = work.ResourceLimits.MemoryBytes
+	}
+	if work.ResourceLimits.PidsLimit > 0 {
+		limits.PidsLimit = work.ResourceLimits.PidsLimit
+	}
+	if work.ResourceLimits.DiskBytes > 0 {
+		limits.DiskBytes = work.ResourceLimits.DiskBytes
+	}
+	return limits
+}
+
+// Returns true if the memory.events (cgroup v2) or memory.oom_control (cgroup v1) content reports an OOM kill
+func hasOOMKillEvent(events string) bool {
+	for _, line := range strings.Split(events, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			if count, err := strconv.Atoi(fields[1]); err == nil && count > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"archive/tar"
-	"bufio"
 	"bytes"
 	"context"
 	_ "embed"
@@ -15,17 +14,10 @@ import (
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
-	"sync"
 	"time"
 
-	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/network"
-	"github.com/docker/docker/api/types/versions"
+	units "github.com/docker/go-units"
 	"github.com/hashworks/aur-ci/worker/model"
-	"github.com/moby/moby/client"
-	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/robfig/cron/v3"
 )
 
@@ -33,12 +25,9 @@ import (
 var makepkgConf []byte
 var rootfsTAR []byte
 
-var docker_client *client.Client
-
 var controller_uri *string
 var work_amount *int
-
-const DOCKER_CONTAINER_PREFIX = "aur-ci-worker-build"
+var pacman_mirror *string
 
 func sendHeartbeat() error {
 	hostname, err := os.Hostname()
@@ -46,27 +35,54 @@ func sendHeartbeat() error {
 		log.Fatal("Failed to get hostname: ", err)
 		return err
 	}
-	response, err := http.Post(*controller_uri+"/api/v1/worker/heartbeat/"+hostname, "", nil)
+	data, err := json.Marshal(collectHeartbeat())
+	if err != nil {
+		log.Println("Failed to marshal heartbeat: ", err)
+		return err
+	}
+	response, err := http.Post(*controller_uri+"/api/v1/worker/heartbeat/"+hostname, "application/json", bytes.NewReader(data))
 	if err != nil {
 		log.Println("Failed to send heartbeat to controller: ", err)
 		return err
-	} else if response.StatusCode != http.StatusNoContent {
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNoContent:
+		return nil
+	case http.StatusOK:
+		var heartbeatResponse model.HeartbeatResponse
+		if err := json.NewDecoder(response.Body).Decode(&heartbeatResponse); err != nil {
+			log.Println("Failed to decode heartbeat response: ", err)
+			return err
+		}
+		for _, buildId := range heartbeatResponse.CancelBuildIds {
+			if cancelRunningBuild(buildId) {
+				log.Printf("Aborting build %d, the controller cancelled it\n", buildId)
+			}
+		}
+		return nil
+	default:
 		log.Printf("Failed to send heartbeat to controller (code %d)\n", response.StatusCode)
-		return err
+		return errors.New(fmt.Sprintf("Unexpected status code %d", response.StatusCode))
 	}
-	return nil
 }
 
-func requestWork() ([]model.Work, error) {
+func requestWork(amount int, wait time.Duration) ([]model.Work, error) {
 	var availableWorks []model.Work
 
-	response, err := http.Get(fmt.Sprintf(*controller_uri+"/api/v1/worker/requestWork?amount=%d", *work_amount))
+	// The controller holds the request open for up to `wait` if no work is available (long-polling)
+	client := &http.Client{
+		Timeout: wait + REQUEST_WORK_TIMEOUT,
+	}
+	response, err := client.Get(fmt.Sprintf(*controller_uri+"/api/v1/worker/requestWork?amount=%d&wait=%d", amount, int(wait.Seconds())))
 	if err != nil {
 		return availableWorks, err
-	} else if response.StatusCode != 200 {
-		return availableWorks, errors.New(fmt.Sprintf("Unexpected status code %d", response.StatusCode))
 	}
 	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return availableWorks, errors.New(fmt.Sprintf("Unexpected status code %d", response.StatusCode))
+	}
 
 	err = json.NewDecoder(response.Body).Decode(&availableWorks)
 	return availableWorks, err
@@ -101,206 +117,103 @@ func getEnv(key string, defaultValue string) string {
 }
 
 func initRootFSTARBuffer() {
-	var rootfsTARBuffer bytes.Buffer
-	tarWriter := tar.NewWriter(&rootfsTARBuffer)
+	var err error
+	rootfsTAR, err = createFileTAR("home/ci/.makepkg.conf", makepkgConf)
+	if err != nil {
+		log.Fatal("Failed to create tar buffer: ", err)
+	}
+}
+
+// Returns a TAR archive of a single file
+func createFileTAR(name string, content []byte) ([]byte, error) {
+	var tarBuffer bytes.Buffer
+	tarWriter := tar.NewWriter(&tarBuffer)
 	if err := tarWriter.WriteHeader(&tar.Header{
-		Name: "home/ci/.makepkg.conf",
+		Name: name,
 		Mode: 0644,
-		Size: int64(len(makepkgConf)),
+		Size: int64(len(content)),
 	}); err != nil {
-		log.Fatal("Failed to create tar buffer: ", err)
+		return nil, err
 	}
-	if _, err := tarWriter.Write(makepkgConf); err != nil {
-		log.Fatal("Failed to create tar buffer: ", err)
+	if _, err := tarWriter.Write(content); err != nil {
+		return nil, err
 	}
 	if err := tarWriter.Close(); err != nil {
-		log.Fatal("Failed to create tar buffer: ", err)
+		return nil, err
 	}
-	rootfsTAR = rootfsTARBuffer.Bytes()
+	return tarBuffer.Bytes(), nil
 }
 
-func initDockerClient() {
-	var err error
-	docker_client, err = client.NewClientWithOpts(client.FromEnv)
-	if err != nil { // Note: This won't be null if the socket is not available
-		log.Fatal("Failed to create docker_client: ", err)
-	}
-
-	// Test the socket
-	if _, err = docker_client.Info(context.Background()); err != nil {
-		log.Fatal("Failed to connect to docker daemon: ", err)
+// Points the mirrorlist of the sandbox to the configured mirror, f.e. the pacman mirror proxy of the controller
+func configureMirror(ctx context.Context, sandbox BuildSandbox) error {
+	if len(*pacman_mirror) == 0 {
+		return nil
 	}
-}
-
-func pullArchLinuxImage() error {
-	log.Println("Pulling Arch Linux image")
-	readCloser, err := docker_client.ImagePull(context.Background(), "archlinux/archlinux:base-devel", types.ImagePullOptions{})
+	mirrorlistTAR, err := createFileTAR("etc/pacman.d/mirrorlist", []byte("Server = "+*pacman_mirror+"\n"))
 	if err != nil {
-		log.Println("Failed to pull image: ", err)
 		return err
 	}
-	loadResponse, err := docker_client.ImageLoad(context.Background(), readCloser, true)
-	if err != nil {
-		log.Println("Failed to load image: ", err)
-		return err
-	}
-	if err := loadResponse.Body.Close(); err != nil {
-		log.Println("Failed to close image load response: ", err)
-		return err
-	}
-	if err := readCloser.Close(); err != nil {
-		log.Println("Failed to close image reader: ", err)
-		return err
-	}
-	return nil
+	return sandbox.CopyIn(ctx, "/", bytes.NewReader(mirrorlistTAR))
 }
 
-func removeOldContainers() {
-	log.Println("Removing old containers")
-
-	ctx := context.Background()
-
-	containers, err := docker_client.ContainerList(ctx, types.ContainerListOptions{})
-	if err != nil {
-		log.Println("Failed to get list of containers: ", err)
-		return
-	}
-	for _, container := range containers {
-		for _, name := range container.Names {
-			if strings.HasPrefix(name, "/"+DOCKER_CONTAINER_PREFIX) {
-				if err := docker_client.ContainerRemove(ctx, container.ID, types.ContainerRemoveOptions{
-					RemoveVolumes: true,
-					Force:         true,
-				}); err != nil {
-					log.Println("Failed to remove old container: ", err)
-				}
-				break
-			}
-		}
-	}
-}
-
-func createContainer(ctx context.Context, work *model.Work) (container.ContainerCreateCreatedBody, error) {
-	log.Printf("[%s] Creating container\n", work.PackageBase)
-
-	var platform *v1.Platform
-	if versions.GreaterThanOrEqualTo(docker_client.ClientVersion(), "1.41") {
-		platform = &v1.Platform{
-			Architecture: "amd64",
-			OS:           "linux",
-		}
-	} else {
-		platform = nil
-	}
-
-	// TODO: We need a long-running command (read: forever) to execute more than one command (exec).
-	// This sounds like a hacky way to do things, maybe we should switch to containerd alltogether?
-	buildContainer, err := docker_client.ContainerCreate(ctx,
-		&container.Config{
-			Image: "archlinux/archlinux:base-devel",
-			Cmd:   []string{"tail", "-f", "/dev/null"},
+// Adds the build user and its makepkg.conf. Safe to call if the sandbox has both already.
+func createBuildUser(ctx context.Context, sandbox BuildSandbox) error {
+	_, exitCode, err := sandbox.Exec(ctx, ExecOptions{
+		Cmd: []string{
+			"bash", "-c", "id ci >/dev/null 2>&1 || useradd -m ci; mkdir -p /home/ci/aur",
 		},
-		&container.HostConfig{},
-		&network.NetworkingConfig{},
-		platform,
-		fmt.Sprintf("%s-%d-%s", DOCKER_CONTAINER_PREFIX, work.BuildId, work.PackageBase))
-	if err != nil {
-		log.Printf("[%s] Failed to create container: %s\n", work.PackageBase, err)
-		return container.ContainerCreateCreatedBody{}, err
-	}
-
-	return buildContainer, nil
-}
-
-func runAndWaitForExec(ctx context.Context, cli *client.Client, containerID string, execConfig types.ExecConfig) ([]byte, int, error) {
-	idResponse, err := cli.ContainerExecCreate(ctx, containerID, execConfig)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	attach, err := cli.ContainerExecAttach(ctx, idResponse.ID, types.ExecStartCheck{})
+	}, nil)
 	if err != nil {
-		return nil, 0, err
-	}
-	defer attach.Close()
-
-	var inspect types.ContainerExecInspect
-
-	for {
-		inspect, err = cli.ContainerExecInspect(ctx, idResponse.ID)
-		if err != nil {
-			return nil, 0, err
-		}
-
-		if !inspect.Running {
-			break
-		}
-
-		time.Sleep(100 * time.Millisecond)
+		return err
 	}
-
-	scanner := bufio.NewScanner(attach.Reader)
-	scanner.Split(bufio.ScanLines)
-	var execLog []string
-
-	for scanner.Scan() {
-		execLog = append(execLog, scanner.Text())
+	if exitCode > 0 {
+		return errors.New(fmt.Sprintf("Unexpected exit code %d", exitCode))
 	}
 
-	return []byte(strings.Join(execLog, "\n")), inspect.ExitCode, nil
+	// copy rootfs
+	return sandbox.CopyIn(ctx, "/", bytes.NewReader(rootfsTAR))
 }
 
-func prepareContainer(ctx context.Context, work *model.Work, containerId string) error {
-	log.Printf("[%s] Preparing container and inserting data\n", work.PackageBase)
-
-	// add user
-	_, exitCode, err := runAndWaitForExec(ctx, docker_client, containerId, types.ExecConfig{
+func fixBuildUserPermissions(ctx context.Context, sandbox BuildSandbox) error {
+	_, exitCode, err := sandbox.Exec(ctx, ExecOptions{
 		Cmd: []string{
-			"bash", "-c", "useradd -m ci; mkdir -p /home/ci/aur",
+			"chown", "-R", "ci:ci", "/home/ci",
 		},
-		AttachStdout: true,
-	})
+	}, nil)
 	if err != nil {
 		return err
 	}
 	if exitCode > 0 {
 		return errors.New(fmt.Sprintf("Unexpected exit code %d", exitCode))
 	}
+	return nil
+}
 
-	// copy rootfs
-	if err := docker_client.CopyToContainer(ctx, containerId, "/", bytes.NewReader(rootfsTAR), types.CopyToContainerOptions{}); err != nil {
-		return err
-	}
+func prepareSandbox(ctx context.Context, work *model.Work, sandbox BuildSandbox) error {
+	log.Printf("[%s] Preparing sandbox and inserting data\n", work.PackageBase)
 
-	// copy package
-	data := make([]byte, base64.StdEncoding.DecodedLen(len(work.PackageBaseDataBase64)))
-	_, err = base64.StdEncoding.Decode(data, []byte(work.PackageBaseDataBase64))
-	if err != nil {
+	if err := configureMirror(ctx, sandbox); err != nil {
 		return err
 	}
-
-	if err := docker_client.CopyToContainer(ctx, containerId, "/home/ci/aur", bytes.NewReader(data), types.CopyToContainerOptions{}); err != nil {
+	if err := createBuildUser(ctx, sandbox); err != nil {
 		return err
 	}
 
-	// fix permissions
-	_, exitCode, err = runAndWaitForExec(ctx, docker_client, containerId, types.ExecConfig{
-		Cmd: []string{
-			"chown", "-R", "ci:ci", "/home/ci",
-		},
-		AttachStdout: true,
-	})
+	// copy package
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(work.PackageBaseDataBase64)))
+	_, err := base64.StdEncoding.Decode(data, []byte(work.PackageBaseDataBase64))
 	if err != nil {
 		return err
 	}
-	if exitCode > 0 {
+
+	if err := sandbox.CopyIn(ctx, "/home/ci/aur", bytes.NewReader(data)); err != nil {
 		return err
 	}
 
-	return nil
+	return fixBuildUserPermissions(ctx, sandbox)
 }
 
-func installDependencies(ctx context.Context, work *model.Work, containerId string) ([]byte, int, error) {
+func installDependencies(ctx context.Context, work *model.Work, sandbox BuildSandbox) ([]byte, int, error) {
 	log.Printf("[%s] Updating system and installing dependencies\n", work.PackageBase)
 
 	cmd := []string{
@@ -313,167 +226,131 @@ func installDependencies(ctx context.Context, work *model.Work, containerId stri
 	}
 	cmd = append(cmd, work.Dependencies...)
 
-	return runAndWaitForExec(ctx, docker_client, containerId, types.ExecConfig{
-		Cmd:          cmd,
-		AttachStdout: true,
-	})
+	return sandbox.Exec(ctx, ExecOptions{
+		Cmd: cmd,
+	}, nil)
 }
 
-func downloadAndExtractPackage(ctx context.Context, work *model.Work, containerId string) ([]byte, int, error) {
+func downloadAndExtractPackage(ctx context.Context, work *model.Work, sandbox BuildSandbox) ([]byte, int, error) {
 	log.Printf("[%s] Downloading and extracting package sources\n", work.PackageBase)
 
-	return runAndWaitForExec(ctx, docker_client, containerId, types.ExecConfig{
+	return sandbox.Exec(ctx, ExecOptions{
 		Cmd: []string{
 			"makepkg", "--nobuild",
 		},
-		User:         "ci",
-		AttachStdout: true,
-		WorkingDir:   "/home/ci/aur/" + work.PackageBase,
-	})
+		User:       "ci",
+		WorkingDir: "/home/ci/aur/" + work.PackageBase,
+	}, nil)
 }
 
-func buildPackage(ctx context.Context, work *model.Work, containerId string) ([]byte, int, error) {
+func buildPackage(ctx context.Context, work *model.Work, sandbox BuildSandbox, lineHandler func(string)) ([]byte, int, error) {
 	log.Printf("[%s] Building package\n", work.PackageBase)
 
-	return runAndWaitForExec(ctx, docker_client, containerId, types.ExecConfig{
+	return sandbox.Exec(ctx, ExecOptions{
 		Cmd: []string{
 			"makepkg", "--noextract",
 		},
-		User:         "ci",
-		AttachStdout: true,
-		WorkingDir:   "/home/ci/aur/" + work.PackageBase,
-	})
+		User:       "ci",
+		WorkingDir: "/home/ci/aur/" + work.PackageBase,
+		Env:        getBuildEnv(work),
+	}, lineHandler)
 }
 
-func handleWork(waitgroup *sync.WaitGroup, work *model.Work) {
-	defer waitgroup.Done()
-
-	log.Printf("[%s] Handling work request\n", work.PackageBase)
-
-	workResult := model.WorkResult{
-		BuildId: work.BuildId,
-		Status:  model.WORK_RESULT_STATUS_INTERNAL_ERROR,
+// Returns the environment of makepkg builds
+func getBuildEnv(work *model.Work) []string {
+	if work.SourceDateEpoch > 0 {
+		return []string{fmt.Sprintf("SOURCE_DATE_EPOCH=%d", work.SourceDateEpoch)}
 	}
-	defer sendWorkResult(&workResult, work.PackageBase)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
-	defer cancel()
+	return nil
+}
 
-	buildContainer, err := createContainer(ctx, work)
+func main() {
+	workAmountEnvOrDefault, err := strconv.ParseUint(getEnv("WORK_AMOUNT", "1"), 10, 32)
 	if err != nil {
-		return
+		log.Fatal("Failed to parse $WORK_AMOUNT")
 	}
-
-	defer docker_client.ContainerRemove(ctx, buildContainer.ID, types.ContainerRemoveOptions{
-		RemoveVolumes: true,
-		Force:         true,
-	})
-
-	log.Printf("[%s] Starting container ID %s\n", work.PackageBase, buildContainer.ID)
-
-	if err := docker_client.ContainerStart(ctx, buildContainer.ID, types.ContainerStartOptions{}); err != nil {
-		log.Printf("[%s] Failed to start container: %s\n", work.PackageBase, err)
-		if err == context.DeadlineExceeded {
-			workResult.Status = model.WORK_RESULT_STATUS_TIMEOUT
-		} else {
-			workResult.Status = model.WORK_RESULT_STATUS_INTERNAL_ERROR
-		}
-		return
+	buildCPUsEnvOrDefault, err := strconv.ParseFloat(getEnv("BUILD_CPUS", "0"), 64)
+	if err != nil {
+		log.Fatal("Failed to parse $BUILD_CPUS")
 	}
-
-	err = prepareContainer(ctx, work, buildContainer.ID)
+	buildPidsEnvOrDefault, err := strconv.ParseInt(getEnv("BUILD_PIDS", "4096"), 10, 64)
 	if err != nil {
-		log.Printf("[%s] Failed to prepare container: %s\n", work.PackageBase, err)
-		if err == context.DeadlineExceeded {
-			workResult.Status = model.WORK_RESULT_STATUS_TIMEOUT
-		} else {
-			workResult.Status = model.WORK_RESULT_STATUS_INTERNAL_ERROR
-		}
-		return
+		log.Fatal("Failed to parse $BUILD_PIDS")
 	}
 
-	var pacmanLog []byte
-	pacmanLog, workResult.PacmanExitCode, err = installDependencies(ctx, work, buildContainer.ID)
+	controller_uri = flag.String("controller", getEnv("CONTROLLER_URI", "http://127.0.0.1:8080"), "Controller URI")
+	work_amount = flag.Int("work-amount", int(workAmountEnvOrDefault), "Amount of packages to build at once")
+	buildCPUs := flag.Float64("build-cpus", buildCPUsEnvOrDefault, "CPUs a single build may use, 0 for no limit")
+	buildMemory := flag.String("build-memory", getEnv("BUILD_MEMORY", ""), "Memory a single build may use, f.e. 4g, empty for no limit")
+	buildPids := flag.Int64("build-pids", buildPidsEnvOrDefault, "Amount of processes a single build may run, 0 for no limit")
+	buildDisk := flag.String("build-disk", getEnv("BUILD_DISK", ""), "Disk space a single build may use, f.e. 20g, empty for no limit")
+	pacman_mirror = flag.String("mirror", getEnv("MIRROR", ""), "Pacman mirror used by builds, f.e. the proxy of the controller: http://controller:8080/mirror/$repo/os/$arch. Empty to keep the mirrorlist of the sandbox.")
+	sourceCachePath := flag.String("source-cache", getEnv("SOURCE_CACHE", ""), "Directory to cache downloaded sources in, shared by builds of the same package. Empty to disable.")
+	sourceCacheSize := flag.String("source-cache-size", getEnv("SOURCE_CACHE_SIZE", "50g"), "Size of the source cache, least recently used sources are removed first")
+	artifacts_dir = flag.String("artifacts-dir", getEnv("ARTIFACTS_DIR", ""), "Directory to store built packages in, in a subdirectory per build ID. Empty to only report them.")
+	run_namcap = flag.Bool("namcap", true, "Lint the PKGBUILD and built packages with namcap")
+	filesystem_diff = flag.Bool("filesystem-diff", true, "Report files a build changed outside of the home directory of the build user")
+	isolate_build_network = flag.Bool("isolate-build-network", getEnv("ISOLATE_BUILD_NETWORK", "true") == "true", "Disconnect build containers from the network after downloading sources")
+	sandbox := flag.String("sandbox", getEnv("SANDBOX", SANDBOX_DOCKER), "Sandbox to build packages in: docker, podman (rootless, docker compatible socket), nspawn (systemd-nspawn, requires root) or chroot (clean chroot like devtools, requires root)")
+	podmanSocket := flag.String("podman-socket", getEnv("PODMAN_SOCKET", fmt.Sprintf("unix:///run/user/%d/podman/podman.sock", os.Getuid())), "Socket of the podman API service")
+	nspawnRoot := flag.String("nspawn-root", getEnv("NSPAWN_ROOT", "/var/lib/aur-ci-worker/root"), "Arch Linux root filesystem used by the nspawn sandbox, f.e. created with mkarchroot")
+	nspawnBuilds := flag.String("nspawn-builds", getEnv("NSPAWN_BUILDS", "/var/lib/aur-ci-worker/builds"), "Directory the nspawn sandbox snapshots the root filesystem to for every build")
+	chrootPath := flag.String("chroot-path", getEnv("CHROOT_PATH", "/var/lib/aur-ci-worker/chroot"), "Directory of the clean chroot maintained by the chroot sandbox")
+	chrootPacmanConf := flag.String("chroot-pacman-conf", getEnv("CHROOT_PACMAN_CONF", DEVTOOLS_PACMAN_CONF), "pacman.conf used to create the clean chroot")
+	chrootMakepkgConf := flag.String("chroot-makepkg-conf", getEnv("CHROOT_MAKEPKG_CONF", DEVTOOLS_MAKEPKG_CONF), "makepkg.conf used to create the clean chroot")
+	snapshotMode := flag.String("snapshot-mode", getEnv("SNAPSHOT_MODE", SNAPSHOT_MODE_AUTO), "How the nspawn and chroot sandboxes snapshot the root filesystem: auto (btrfs if the root is a btrfs subvolume, overlayfs otherwise), btrfs, overlayfs or copy")
+	disk_limit_mode = flag.String("build-disk-mode", getEnv("BUILD_DISK_MODE", DISK_LIMIT_MODE_STORAGE_OPT), "How to limit disk space: storage-opt (requires quota support of the storage driver) or tmpfs (uses memory)")
+	flag.Parse()
 
-	if err != nil {
-		log.Printf("[%s] Failed to install dependencies: %s\n", work.PackageBase, err)
-		if err == context.DeadlineExceeded {
-			workResult.Status = model.WORK_RESULT_STATUS_TIMEOUT
-		} else {
-			workResult.Status = model.WORK_RESULT_STATUS_INTERNAL_ERROR
-		}
-		return
+	if *work_amount < 1 {
+		log.Fatal("Work amount must be at least 1")
 	}
-	workResult.PacmanLogBase64 = base64.StdEncoding.EncodeToString(pacmanLog)
 
-	if workResult.PacmanExitCode > 0 {
-		log.Printf("[%s] Pacman failed with exit code %d.\n", work.PackageBase, workResult.PacmanExitCode)
-		workResult.Status = model.WORK_RESULT_STATUS_FAILED
-		return
+	if len(*controller_uri) == 0 {
+		log.Fatal("Missing controller URI")
 	}
 
-	// TODO: Make filesystem diff before and after build
-
-	var makepkgExtractLog []byte
-	makepkgExtractLog, workResult.MakepkgExtractExitCode, err = downloadAndExtractPackage(ctx, work, buildContainer.ID)
-
-	if err != nil {
-		log.Printf("[%s] Failed to download and extract package: %s\n", work.PackageBase, err)
-		if err == context.DeadlineExceeded {
-			workResult.Status = model.WORK_RESULT_STATUS_TIMEOUT
-		} else {
-			workResult.Status = model.WORK_RESULT_STATUS_INTERNAL_ERROR
+	default_resource_limits.CPUs = *buildCPUs
+	default_resource_limits.PidsLimit = *buildPids
+	if len(*buildMemory) > 0 {
+		default_resource_limits.MemoryBytes, err = units.RAMInBytes(*buildMemory)
+		if err != nil {
+			log.Fatal("Failed to parse build memory limit: ", err)
 		}
-		return
-	}
-	workResult.MakepkgExtractLogBase64 = base64.StdEncoding.EncodeToString(makepkgExtractLog)
-
-	if workResult.MakepkgExtractExitCode > 0 {
-		log.Printf("[%s] makepkg --nobuild failed with exit code %d.\n", work.PackageBase, workResult.MakepkgExtractExitCode)
-		workResult.Status = model.WORK_RESULT_STATUS_FAILED
-		return
 	}
-
-	var makepkgBuildLog []byte
-	makepkgBuildLog, workResult.MakepkgBuildExitCode, err = buildPackage(ctx, work, buildContainer.ID)
-
-	if err != nil {
-		log.Printf("[%s] Failed to build package: %s\n", work.PackageBase, err)
-		if err == context.DeadlineExceeded {
-			workResult.Status = model.WORK_RESULT_STATUS_TIMEOUT
-		} else {
-			workResult.Status = model.WORK_RESULT_STATUS_INTERNAL_ERROR
+	if len(*buildDisk) > 0 {
+		default_resource_limits.DiskBytes, err = units.RAMInBytes(*buildDisk)
+		if err != nil {
+			log.Fatal("Failed to parse build disk limit: ", err)
 		}
-		return
 	}
-	workResult.MakepkgBuildLogBase64 = base64.StdEncoding.EncodeToString(makepkgBuildLog)
-
-	if workResult.MakepkgBuildExitCode > 0 {
-		log.Printf("[%s] makepkg --noextract failed with exit code %d.\n", work.PackageBase, workResult.MakepkgBuildExitCode)
-		workResult.Status = model.WORK_RESULT_STATUS_FAILED
-		return
+	if *disk_limit_mode != DISK_LIMIT_MODE_STORAGE_OPT && *disk_limit_mode != DISK_LIMIT_MODE_TMPFS {
+		log.Fatal("Unknown build disk mode ", *disk_limit_mode)
 	}
 
-	workResult.Status = model.WORK_RESULT_STATUS_SUCCESS
-}
-
-func main() {
-	workAmountEnvOrDefault, err := strconv.ParseUint(getEnv("WORK_AMOUNT", "1"), 10, 32)
-	if err != nil {
-		log.Fatal("Failed to parse $WORK_AMOUNT")
+	if len(*sourceCachePath) > 0 {
+		sourceCacheBytes, err := units.RAMInBytes(*sourceCacheSize)
+		if err != nil {
+			log.Fatal("Failed to parse source cache size: ", err)
+		}
+		source_cache = newSourceCache(*sourceCachePath, sourceCacheBytes)
 	}
 
-	controller_uri = flag.String("controller", getEnv("CONTROLLER_URI", "http://127.0.0.1:8080"), "Controller URI")
-	work_amount = flag.Int("work-amount", int(workAmountEnvOrDefault), "Amount of packages to build at once")
-	flag.Parse()
+	initRootFSTARBuffer()
 
-	if len(*controller_uri) == 0 {
-		log.Fatal("Missing controller URI")
+	switch *sandbox {
+	case SANDBOX_DOCKER:
+		sandbox_runtime = newDockerRuntime()
+	case SANDBOX_PODMAN:
+		sandbox_runtime = newPodmanRuntime(*podmanSocket)
+	case SANDBOX_NSPAWN:
+		sandbox_runtime = newNspawnRuntime(*nspawnRoot, *nspawnBuilds, *snapshotMode)
+	case SANDBOX_CHROOT:
+		sandbox_runtime = newChrootRuntime(*chrootPath, *chrootPacmanConf, *chrootMakepkgConf, *snapshotMode)
+	default:
+		log.Fatal("Unknown sandbox ", *sandbox)
 	}
-
-	initRootFSTARBuffer()
-	initDockerClient()
-	defer docker_client.Close()
+	defer sandbox_runtime.Close()
 
 	log.Printf("Sending initial heartbeat / registration to controller at %s", *controller_uri)
 	err = sendHeartbeat()
@@ -485,30 +362,10 @@ func main() {
 	c.AddFunc("@every 1m", func() { _ = sendHeartbeat() })
 	c.Start()
 
-	log.Println("Registration successfull. Requesting work in a loop.")
-	for {
-		time.Sleep(time.Second)
-
-		availableWorkload, err := requestWork()
-		if err != nil {
-			log.Println("Failed to request work: ", err)
-			continue
-		}
-
-		if len(availableWorkload) == 0 {
-			continue
-		}
-
-		pullArchLinuxImage()
-		removeOldContainers()
+	sandbox_runtime.Update(context.Background())
+	sandbox_runtime.RemoveLeftovers(context.Background())
+	c.AddFunc("@every 6h", func() { _ = sandbox_runtime.Update(context.Background()) })
 
-		var waitgroup sync.WaitGroup
-		waitgroup.Add(len(availableWorkload))
-
-		for _, work := range availableWorkload {
-			go handleWork(&waitgroup, &work)
-		}
-
-		waitgroup.Wait()
-	}
+	log.Println("Registration successfull. Requesting work in a loop.")
+	runScheduler(*work_amount)
 }
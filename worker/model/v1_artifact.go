@@ -0,0 +1,15 @@
+package model
+
+// TODO: Use controller model files
+
+// A package file produced by a build, one per package of split packages
+type Artifact struct {
+	FileName       string
+	Size           int64
+	SHA256         string
+	PackageName    string
+	PackageVersion string // [epoch:]pkgver
+	PackageRelease string
+	Architecture   string
+	PkgInfo        string // Content of .PKGINFO
+}
@@ -0,0 +1,16 @@
+package model
+
+// TODO: Use controller model files
+
+const (
+	FILESYSTEM_CHANGE_ADDED    = "added"
+	FILESYSTEM_CHANGE_MODIFIED = "modified"
+	FILESYSTEM_CHANGE_REMOVED  = "removed"
+)
+
+// A file a build changed outside of the paths it may write to
+type FilesystemChange struct {
+	Path     string
+	Change   string // added, modified or removed
+	FileType string // Type of find -printf %y, f.e. f for regular files or d for directories
+}
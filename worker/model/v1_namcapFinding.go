@@ -0,0 +1,17 @@
+package model
+
+// TODO: Use controller model files
+
+const (
+	NAMCAP_SEVERITY_ERROR   = "error"
+	NAMCAP_SEVERITY_WARNING = "warning"
+	NAMCAP_SEVERITY_INFO    = "info"
+)
+
+// A finding of namcap in the PKGBUILD or a package of a build
+type NamcapFinding struct {
+	Target   string // PKGBUILD or the file name of a package
+	Severity string // error, warning or info
+	Tag      string // f.e. dependency-detected-not-included
+	Message  string // Arguments of the tag
+}
@@ -0,0 +1,9 @@
+package model
+
+// TODO: Use controller model files
+
+// Result of building a commit twice and comparing the packages
+type ReproducibilityResult struct {
+	Reproducible bool
+	Differences  []string // f.e. "foo-1-1-x86_64.pkg.tar.zst: ./usr/bin/foo differs in sha256digest, size"
+}
@@ -0,0 +1,11 @@
+package model
+
+// TODO: Use controller model files
+// Timeouts of a build in seconds. Zero values mean "no step timeout" or, in overrides, "use the default".
+type Timeouts struct {
+	TotalSeconds        int
+	DependenciesSeconds int
+	DownloadSeconds     int
+	BuildSeconds        int
+	CheckSeconds        int
+}
@@ -7,4 +7,8 @@ type Work struct {
 	PackageBase           string
 	PackageBaseDataBase64 string
 	Dependencies          []string
+	ResourceLimits        *ResourceLimits
+	Timeouts              *Timeouts
+	CheckReproducibility  bool  // Build twice and compare the packages
+	SourceDateEpoch       int64 // Passed to makepkg if set, f.e. the commit time
 }
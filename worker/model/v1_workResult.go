@@ -5,20 +5,31 @@ package model
 type WorkResultStatus int8
 
 const (
-	WORK_RESULT_STATUS_INTERNAL_ERROR WorkResultStatus = 0
-	WORK_RESULT_STATUS_TIMEOUT        WorkResultStatus = 10
-	WORK_RESULT_STATUS_FAILED         WorkResultStatus = 20
-	WORK_RESULT_STATUS_SUCCESS        WorkResultStatus = 30
+	WORK_RESULT_STATUS_INTERNAL_ERROR   WorkResultStatus = 0
+	WORK_RESULT_STATUS_TIMEOUT          WorkResultStatus = 10
+	WORK_RESULT_STATUS_OUT_OF_MEMORY    WorkResultStatus = 15
+	WORK_RESULT_STATUS_FAILED           WorkResultStatus = 20
+	WORK_RESULT_STATUS_REQUIRES_NETWORK WorkResultStatus = 25 // Build failed without, but succeeded with network access
+	WORK_RESULT_STATUS_SUCCESS          WorkResultStatus = 30
 )
 
 type WorkResult struct {
-	Id                      int64
-	BuildId                 int64
-	Status                  WorkResultStatus
-	PacmanExitCode          int
-	PacmanLogBase64         string
-	MakepkgExtractExitCode  int
-	MakepkgExtractLogBase64 string
-	MakepkgBuildExitCode    int
-	MakepkgBuildLogBase64   string
+	Id                           int64
+	BuildId                      int64
+	Status                       WorkResultStatus
+	PacmanExitCode               int
+	PacmanLogBase64              string
+	MakepkgExtractExitCode       int
+	MakepkgExtractLogBase64      string
+	MakepkgBuildExitCode         int
+	MakepkgBuildLogBase64        string
+	MakepkgBuildNetworkExitCode  int
+	MakepkgBuildNetworkLogBase64 string
+	TimedOutStep                 string // total, dependencies, download, build or check
+	ErrorCategory                string // timeout, out-of-memory, build, requires-network, docker-daemon, image, cancelled or internal
+	ErrorMessage                 string
+	NamcapFindings               []NamcapFinding
+	FilesystemChanges            []FilesystemChange
+	Reproducibility              *ReproducibilityResult // nil if not checked
+	Artifacts                    []Artifact
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+const NAMCAP_PKGBUILD_TARGET = "PKGBUILD"
+
+// Output of `namcap -m`, f.e. "foo E: dependency-detected-not-included bar (libraries […])"
+var namcapLineRegexp = regexp.MustCompile(`^(.+?) ([EWI]): (\S+)\s*(.*)$`)
+
+var namcapSeverities = map[string]string{
+	"E": model.NAMCAP_SEVERITY_ERROR,
+	"W": model.NAMCAP_SEVERITY_WARNING,
+	"I": model.NAMCAP_SEVERITY_INFO,
+}
+
+var run_namcap *bool
+
+// Installs namcap after the build, so it doesn't influence it, and lints the PKGBUILD and all built packages
+func runNamcap(ctx context.Context, work *model.Work, sandbox BuildSandbox, artifacts []model.Artifact) ([]model.NamcapFinding, error) {
+	log.Printf("[%s] Running namcap\n", work.PackageBase)
+
+	output, exitCode, err := sandbox.Exec(ctx, ExecOptions{
+		Cmd: []string{
+			"pacman", "-S", "--noconfirm", "--noprogressbar", "--needed", "namcap",
+		},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode > 0 {
+		return nil, fmt.Errorf("Failed to install namcap: %s", output)
+	}
+
+	findings, err := runNamcapOn(ctx, work, sandbox, NAMCAP_PKGBUILD_TARGET, "PKGBUILD")
+	if err != nil {
+		return nil, err
+	}
+	for _, artifact := range artifacts {
+		artifactFindings, err := runNamcapOn(ctx, work, sandbox, artifact.FileName, ARTIFACTS_PATH+"/"+artifact.FileName)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, artifactFindings...)
+	}
+
+	return findings, nil
+}
+
+func runNamcapOn(ctx context.Context, work *model.Work, sandbox BuildSandbox, target string, path string) ([]model.NamcapFinding, error) {
+	output, exitCode, err := sandbox.Exec(ctx, ExecOptions{
+		Cmd: []string{
+			"namcap", "-m", path,
+		},
+		User:       "ci",
+		WorkingDir: "/home/ci/aur/" + work.PackageBase,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode > 0 {
+		return nil, fmt.Errorf("namcap failed on %s with exit code %d: %s", target, exitCode, output)
+	}
+	return parseNamcapOutput(target, output), nil
+}
+
+// Returns the findings of namcap output, lines that aren't findings are ignored
+func parseNamcapOutput(target string, output []byte) []model.NamcapFinding {
+	var findings []model.NamcapFinding
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		match := namcapLineRegexp.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		findings = append(findings, model.NamcapFinding{
+			Target:   target,
+			Severity: namcapSeverities[match[2]],
+			Tag:      match[3],
+			Message:  match[4],
+		})
+	}
+	return findings
+}
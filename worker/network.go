@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+var isolate_build_network *bool
+
+// Builds the package again with network access, starting from a clean $srcdir.
+// Used to find out if a build only failed since it was isolated from the network.
+func buildPackageWithNetwork(ctx context.Context, work *model.Work, sandbox BuildSandbox, lineHandler func(string)) ([]byte, int, error) {
+	log.Printf("[%s] Building package again with network access\n", work.PackageBase)
+
+	if err := sandbox.ConnectNetwork(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	return sandbox.Exec(ctx, ExecOptions{
+		Cmd: []string{
+			"makepkg", "--force", "--cleanbuild",
+		},
+		User:       "ci",
+		WorkingDir: "/home/ci/aur/" + work.PackageBase,
+		Env:        getBuildEnv(work),
+	}, lineHandler)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+// State of a single build, shared by its steps
+type buildState struct {
+	work         *model.Work
+	workResult   *model.WorkResult
+	stepTimeouts model.Timeouts
+	sandbox      BuildSandbox
+	isolated     bool
+	// nil if the source cache is disabled or unavailable for this build
+	sourceCacheEntry *sourceCacheEntry
+	// Taken before code of the package runs, nil once compared
+	filesystemSnapshot filesystemSnapshot
+}
+
+type buildStep struct {
+	// Used in logs: "Failed to <name>"
+	name string
+	// Reported if the step exceeds its own timeout, empty if it has none
+	timeoutStep string
+	// Returns the timeout of the step in seconds, nil if it has none
+	timeoutSeconds func(timeouts *model.Timeouts) int
+	// Returns true if the step should be skipped, nil if it always runs
+	skip func(build *buildState) bool
+	run  func(ctx context.Context, watchdog *stepWatchdog, build *buildState) error
+}
+
+var buildSteps = []buildStep{
+	{
+		name: "create sandbox",
+		run:  createSandboxStep,
+	},
+	{
+		name: "prepare sandbox",
+		run:  prepareSandboxStep,
+	},
+	{
+		name: "stage reproducibility build",
+		skip: func(build *buildState) bool { return !build.work.CheckReproducibility },
+		run:  stageReproducibilityBuildStep,
+	},
+	{
+		name:           "install dependencies",
+		timeoutStep:    STEP_DEPENDENCIES,
+		timeoutSeconds: func(timeouts *model.Timeouts) int { return timeouts.DependenciesSeconds },
+		run:            installDependenciesStep,
+	},
+	{
+		name: "take filesystem snapshot",
+		skip: func(build *buildState) bool { return !*filesystem_diff },
+		run:  takeFilesystemSnapshotStep,
+	},
+	{
+		name:           "download and extract package",
+		timeoutStep:    STEP_DOWNLOAD,
+		timeoutSeconds: func(timeouts *model.Timeouts) int { return timeouts.DownloadSeconds },
+		run:            downloadAndExtractPackageStep,
+	},
+	{
+		name: "disconnect sandbox from network",
+		skip: func(build *buildState) bool { return !*isolate_build_network },
+		run:  disconnectNetworkStep,
+	},
+	{
+		name:           "build package",
+		timeoutStep:    STEP_BUILD,
+		timeoutSeconds: func(timeouts *model.Timeouts) int { return timeouts.BuildSeconds },
+		run:            buildPackageStep,
+	},
+	{
+		name: "collect artifacts",
+		run:  collectArtifactsStep,
+	},
+	{
+		name: "check reproducibility",
+		skip: func(build *buildState) bool { return !build.work.CheckReproducibility },
+		run:  checkReproducibilityStep,
+	},
+	{
+		// After all code of the package ran
+		name: "compare filesystem",
+		run:  compareFilesystemStep,
+	},
+	{
+		name: "run namcap",
+		skip: func(build *buildState) bool { return !*run_namcap },
+		run:  runNamcapStep,
+	},
+}
+
+// Runs the build of the work and reports its result. Cancelling the context aborts the build.
+func handleWork(parentCtx context.Context, work *model.Work) {
+	log.Printf("[%s] Handling work request\n", work.PackageBase)
+
+	workResult := model.WorkResult{
+		BuildId: work.BuildId,
+		Status:  model.WORK_RESULT_STATUS_INTERNAL_ERROR,
+	}
+	defer sendWorkResult(&workResult, work.PackageBase)
+
+	ctx, cancel := context.WithTimeout(parentCtx, getTotalTimeout(work))
+	defer cancel()
+
+	build := buildState{
+		work:         work,
+		workResult:   &workResult,
+		stepTimeouts: getStepTimeouts(work),
+	}
+
+	var mounts []BindMount
+	build.sourceCacheEntry = acquireSourceCacheEntry(work)
+	if build.sourceCacheEntry != nil {
+		// Released after the sandbox is destroyed
+		defer build.sourceCacheEntry.release()
+		mounts = append(mounts, BindMount{
+			Source: build.sourceCacheEntry.path,
+			Target: SOURCE_CACHE_TARGET,
+		})
+	}
+
+	build.sandbox = sandbox_runtime.NewSandbox(work, getResourceLimits(work), mounts)
+	defer build.destroySandbox()
+
+	err := runBuildSteps(ctx, &build, buildSteps)
+	// Failed builds might have changed the filesystem as well
+	build.reportFilesystemChanges()
+	if parentCtx.Err() != nil {
+		workResult.Status = model.WORK_RESULT_STATUS_INTERNAL_ERROR
+		workResult.ErrorCategory = ERROR_CATEGORY_CANCELLED
+		workResult.ErrorMessage = "the build was cancelled by the controller"
+		workResult.TimedOutStep = ""
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	workResult.Status = model.WORK_RESULT_STATUS_SUCCESS
+}
+
+// Runs the steps in order and stops at the first error, which is classified into the work result
+func runBuildSteps(ctx context.Context, build *buildState, steps []buildStep) error {
+	for _, step := range steps {
+		if step.skip != nil && step.skip(build) {
+			continue
+		}
+
+		timeoutSeconds := 0
+		if step.timeoutSeconds != nil {
+			timeoutSeconds = step.timeoutSeconds(&build.stepTimeouts)
+		}
+
+		watchdog, stepCtx := newStepWatchdog(ctx, step.timeoutStep, timeoutSeconds)
+		err := step.run(stepCtx, watchdog, build)
+		watchdog.Stop()
+
+		if err != nil {
+			log.Printf("[%s] Failed to %s: %s\n", build.work.PackageBase, step.name, err)
+			applyError(build.workResult, err, watchdog.TimedOutStep(), ctx.Err() != nil)
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *buildState) destroySandbox() {
+	// The build context might be expired already
+	if err := b.sandbox.Destroy(context.Background()); err != nil {
+		log.Printf("[%s] Failed to destroy sandbox: %s\n", b.work.PackageBase, err)
+	}
+}
+
+// Returns an exitCodeError for a non-zero exit code, wrapping errOutOfMemory if the kernel killed a process of the build
+func (b *buildState) exitCodeError(ctx context.Context, command string, exitCode int) error {
+	err := &exitCodeError{
+		Command:  command,
+		ExitCode: exitCode,
+	}
+	if b.sandbox.WasOOMKilled(ctx) {
+		return fmt.Errorf("%w: %s", errOutOfMemory, err)
+	}
+	return err
+}
+
+func createSandboxStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	err := build.sandbox.Create(ctx)
+	var notFoundErr errdefs.ErrNotFound
+	if errors.As(err, &notFoundErr) {
+		return fmt.Errorf("%w: %s", errImageUnavailable, err)
+	}
+	return err
+}
+
+func prepareSandboxStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	return prepareSandbox(ctx, build.work, build.sandbox)
+}
+
+func stageReproducibilityBuildStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	return stageReproducibilityBuild(ctx, build.work, build.sandbox)
+}
+
+func installDependenciesStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	pacmanLog, exitCode, err := installDependencies(ctx, build.work, build.sandbox)
+	if err != nil {
+		return err
+	}
+	build.workResult.PacmanExitCode = exitCode
+	build.workResult.PacmanLogBase64 = base64.StdEncoding.EncodeToString(pacmanLog)
+
+	if exitCode > 0 {
+		return build.exitCodeError(ctx, "pacman", exitCode)
+	}
+	return nil
+}
+
+// Failures are only logged, the build itself doesn't depend on the snapshot
+func takeFilesystemSnapshotStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	snapshot, err := takeFilesystemSnapshot(ctx, build.sandbox)
+	if err != nil {
+		log.Printf("[%s] Failed to take filesystem snapshot: %s\n", build.work.PackageBase, err)
+		return nil
+	}
+	build.filesystemSnapshot = snapshot
+	return nil
+}
+
+func compareFilesystemStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	build.reportFilesystemChanges()
+	return nil
+}
+
+func downloadAndExtractPackageStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	makepkgExtractLog, exitCode, err := downloadAndExtractPackage(ctx, build.work, build.sandbox)
+	if err != nil {
+		return err
+	}
+	build.workResult.MakepkgExtractExitCode = exitCode
+	build.workResult.MakepkgExtractLogBase64 = base64.StdEncoding.EncodeToString(makepkgExtractLog)
+
+	if exitCode > 0 {
+		// Sources failing their checksums would fail every following build
+		if build.sourceCacheEntry != nil {
+			build.sourceCacheEntry.discard = true
+		}
+		return build.exitCodeError(ctx, "makepkg --nobuild", exitCode)
+	}
+	return nil
+}
+
+func disconnectNetworkStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	log.Printf("[%s] Disconnecting sandbox from network\n", build.work.PackageBase)
+
+	if err := build.sandbox.DisconnectNetwork(ctx); err != nil {
+		return err
+	}
+	build.isolated = true
+	return nil
+}
+
+// Builds the package. If the build fails without network access it is repeated with network access,
+// to find out if the package requires network access during build().
+func buildPackageStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	lineHandler := watchdog.makepkgLineHandler(build.stepTimeouts.CheckSeconds)
+
+	makepkgBuildLog, exitCode, err := buildPackage(ctx, build.work, build.sandbox, lineHandler)
+	if err != nil {
+		return err
+	}
+	build.workResult.MakepkgBuildExitCode = exitCode
+	build.workResult.MakepkgBuildLogBase64 = base64.StdEncoding.EncodeToString(makepkgBuildLog)
+
+	if exitCode == 0 {
+		return nil
+	}
+
+	buildErr := build.exitCodeError(ctx, "makepkg --noextract", exitCode)
+	if !build.isolated || errors.Is(buildErr, errOutOfMemory) {
+		return buildErr
+	}
+
+	// The second build gets the full build timeout again
+	watchdog.SwitchStep(STEP_BUILD, build.stepTimeouts.BuildSeconds)
+
+	makepkgBuildNetworkLog, exitCode, err := buildPackageWithNetwork(ctx, build.work, build.sandbox, lineHandler)
+	if err != nil {
+		return err
+	}
+	build.workResult.MakepkgBuildNetworkExitCode = exitCode
+	build.workResult.MakepkgBuildNetworkLogBase64 = base64.StdEncoding.EncodeToString(makepkgBuildNetworkLog)
+
+	if exitCode > 0 {
+		return build.exitCodeError(ctx, "makepkg --cleanbuild with network access", exitCode)
+	}
+
+	log.Printf("[%s] Package requires network access during build.\n", build.work.PackageBase)
+	return errRequiresNetwork
+}
+
+func collectArtifactsStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	artifacts, err := collectArtifacts(ctx, build.work, build.sandbox)
+	if err != nil {
+		return err
+	}
+	build.workResult.Artifacts = artifacts
+	return nil
+}
+
+// Failures are only logged, since they don't change the result of the build
+func checkReproducibilityStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	result, err := checkReproducibility(ctx, build.work, build.sandbox, build.workResult.Artifacts)
+	if err != nil {
+		log.Printf("[%s] Failed to check reproducibility: %s\n", build.work.PackageBase, err)
+		return nil
+	}
+	build.workResult.Reproducibility = result
+	return nil
+}
+
+// Lints the build. Failures are only logged, since they don't change the result of the build.
+func runNamcapStep(ctx context.Context, watchdog *stepWatchdog, build *buildState) error {
+	if build.isolated {
+		if err := build.sandbox.ConnectNetwork(ctx); err != nil {
+			log.Printf("[%s] Failed to connect sandbox to network for namcap: %s\n", build.work.PackageBase, err)
+			return nil
+		}
+		build.isolated = false
+	}
+
+	findings, err := runNamcap(ctx, build.work, build.sandbox, build.workResult.Artifacts)
+	if err != nil {
+		log.Printf("[%s] Failed to run namcap: %s\n", build.work.PackageBase, err)
+		return nil
+	}
+	build.workResult.NamcapFindings = findings
+	return nil
+}
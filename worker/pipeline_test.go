@@ -0,0 +1,404 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/hashworks/aur-ci/worker/model"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Result of a command executed in the fake container
+type fakeExecResult struct {
+	output   string
+	exitCode int
+	// Keeps the command running until its context is cancelled
+	hang bool
+}
+
+// A docker daemon with a single container. Commands succeed without output unless exec returns something else.
+// Methods the build steps don't use are left to the embedded nil interface and panic.
+type fakeDockerClient struct {
+	dockerClient
+
+	exec      func(cmd []string) fakeExecResult
+	oomKilled bool
+
+	mutex        sync.Mutex
+	execs        map[string]fakeExecResult
+	commands     []string
+	disconnected bool
+}
+
+func newFakeDockerClient(exec func(cmd []string) fakeExecResult) *fakeDockerClient {
+	return &fakeDockerClient{
+		exec:  exec,
+		execs: make(map[string]fakeExecResult),
+	}
+}
+
+func (c *fakeDockerClient) ClientVersion() string {
+	return "1.41"
+}
+
+func (c *fakeDockerClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *v1.Platform, containerName string) (container.ContainerCreateCreatedBody, error) {
+	return container.ContainerCreateCreatedBody{ID: "container"}, nil
+}
+
+func (c *fakeDockerClient) ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error {
+	return nil
+}
+
+func (c *fakeDockerClient) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+	return nil
+}
+
+func (c *fakeDockerClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			State: &types.ContainerState{OOMKilled: c.oomKilled},
+		},
+		NetworkSettings: &types.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{"bridge": {}},
+		},
+	}, nil
+}
+
+func (c *fakeDockerClient) ContainerExecCreate(ctx context.Context, containerID string, config types.ExecConfig) (types.IDResponse, error) {
+	result := fakeExecResult{}
+	if c.exec != nil {
+		result = c.exec(config.Cmd)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	id := strconv.Itoa(len(c.execs))
+	c.execs[id] = result
+	c.commands = append(c.commands, strings.Join(config.Cmd, " "))
+	return types.IDResponse{ID: id}, nil
+}
+
+func (c *fakeDockerClient) ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error) {
+	c.mutex.Lock()
+	exec := c.execs[execID]
+	c.mutex.Unlock()
+
+	var multiplexed bytes.Buffer
+	if _, err := stdcopy.NewStdWriter(&multiplexed, stdcopy.Stdout).Write([]byte(exec.output)); err != nil {
+		return types.HijackedResponse{}, err
+	}
+
+	conn, remote := net.Pipe()
+	remote.Close()
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(&multiplexed)}, nil
+}
+
+func (c *fakeDockerClient) ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error) {
+	c.mutex.Lock()
+	exec := c.execs[execID]
+	c.mutex.Unlock()
+
+	if exec.hang {
+		<-ctx.Done()
+		return types.ContainerExecInspect{}, ctx.Err()
+	}
+	return types.ContainerExecInspect{ExecID: execID, ExitCode: exec.exitCode}, nil
+}
+
+func (c *fakeDockerClient) CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options types.CopyToContainerOptions) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.commands = append(c.commands, "copy to "+dstPath)
+	return nil
+}
+
+// The artifacts directory is always empty
+func (c *fakeDockerClient) CopyFromContainer(ctx context.Context, containerID, srcPath string) (io.ReadCloser, types.ContainerPathStat, error) {
+	var tarBuffer bytes.Buffer
+	if err := tar.NewWriter(&tarBuffer).Close(); err != nil {
+		return nil, types.ContainerPathStat{}, err
+	}
+	return ioutil.NopCloser(&tarBuffer), types.ContainerPathStat{}, nil
+}
+
+func (c *fakeDockerClient) NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.disconnected = false
+	return nil
+}
+
+func (c *fakeDockerClient) NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.disconnected = true
+	return nil
+}
+
+func (c *fakeDockerClient) ran(command string) bool {
+	return c.lastIndexOf(command) >= 0
+}
+
+// Returns the position of the last run of a command with the prefix, or of a copy into the sandbox, -1 if there was none
+func (c *fakeDockerClient) lastIndexOf(prefix string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i := len(c.commands) - 1; i >= 0; i-- {
+		if strings.HasPrefix(c.commands[i], prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isCommand(cmd []string, command string) bool {
+	return strings.Join(cmd, " ") == command
+}
+
+// Sets the flags the build steps read, pacman mirror and artifacts directory disabled
+func setBuildStepFlags(t *testing.T, isolateBuildNetwork bool) {
+	disabled := false
+	empty := ""
+	previousFilesystemDiff, previousNamcap := filesystem_diff, run_namcap
+	previousIsolation, previousMirror, previousArtifactsDir := isolate_build_network, pacman_mirror, artifacts_dir
+	t.Cleanup(func() {
+		filesystem_diff, run_namcap = previousFilesystemDiff, previousNamcap
+		isolate_build_network, pacman_mirror, artifacts_dir = previousIsolation, previousMirror, previousArtifactsDir
+	})
+
+	filesystem_diff = &disabled
+	run_namcap = &disabled
+	isolate_build_network = &isolateBuildNetwork
+	pacman_mirror = &empty
+	artifacts_dir = &empty
+}
+
+func runFakeBuild(t *testing.T, client *fakeDockerClient, timeouts *model.Timeouts) (*model.WorkResult, error) {
+	return runFakeWork(t, client, &model.Work{
+		BuildId:     1,
+		PackageBase: "foo",
+		Timeouts:    timeouts,
+	})
+}
+
+func runFakeWork(t *testing.T, client *fakeDockerClient, work *model.Work) (*model.WorkResult, error) {
+	workResult := &model.WorkResult{
+		BuildId: work.BuildId,
+		Status:  model.WORK_RESULT_STATUS_INTERNAL_ERROR,
+	}
+	runtime := &dockerRuntime{
+		name:   SANDBOX_DOCKER,
+		client: client,
+		image:  BUILD_IMAGE,
+	}
+	build := buildState{
+		work:         work,
+		workResult:   workResult,
+		stepTimeouts: getStepTimeouts(work),
+		sandbox:      runtime.NewSandbox(work, model.ResourceLimits{}, nil),
+	}
+	defer build.destroySandbox()
+
+	ctx, cancel := context.WithTimeout(context.Background(), getTotalTimeout(work))
+	defer cancel()
+	return workResult, runBuildSteps(ctx, &build, buildSteps)
+}
+
+func TestRunBuildStepsSuccess(t *testing.T) {
+	setBuildStepFlags(t, true)
+	client := newFakeDockerClient(func(cmd []string) fakeExecResult {
+		if isCommand(cmd, "makepkg --noextract") {
+			return fakeExecResult{output: "==> Finished making: foo 1-1"}
+		}
+		return fakeExecResult{}
+	})
+
+	workResult, err := runFakeBuild(t, client, nil)
+	if err != nil {
+		t.Fatalf("runBuildSteps() = %s, want no error", err)
+	}
+	if workResult.Status != model.WORK_RESULT_STATUS_INTERNAL_ERROR || len(workResult.ErrorCategory) > 0 {
+		t.Errorf("work result was classified as %d, %s", workResult.Status, workResult.ErrorCategory)
+	}
+	if workResult.MakepkgBuildLogBase64 != base64.StdEncoding.EncodeToString([]byte("==> Finished making: foo 1-1")) {
+		t.Errorf("MakepkgBuildLogBase64 = %q, want the build output", workResult.MakepkgBuildLogBase64)
+	}
+	if !client.disconnected {
+		t.Error("the sandbox was not disconnected from the network")
+	}
+	if client.ran("makepkg --force --cleanbuild") {
+		t.Error("a successful build was repeated with network access")
+	}
+}
+
+func TestRunBuildStepsFailed(t *testing.T) {
+	setBuildStepFlags(t, false)
+	client := newFakeDockerClient(func(cmd []string) fakeExecResult {
+		if isCommand(cmd, "makepkg --noextract") {
+			return fakeExecResult{exitCode: 4}
+		}
+		return fakeExecResult{}
+	})
+
+	workResult, err := runFakeBuild(t, client, nil)
+	if err == nil {
+		t.Fatal("runBuildSteps() succeeded, want an error")
+	}
+	if workResult.Status != model.WORK_RESULT_STATUS_FAILED || workResult.ErrorCategory != ERROR_CATEGORY_BUILD {
+		t.Errorf("work result = %d, %s; want %d, %s", workResult.Status, workResult.ErrorCategory, model.WORK_RESULT_STATUS_FAILED, ERROR_CATEGORY_BUILD)
+	}
+	if workResult.MakepkgBuildExitCode != 4 {
+		t.Errorf("MakepkgBuildExitCode = %d, want 4", workResult.MakepkgBuildExitCode)
+	}
+	if client.ran("makepkg --force --cleanbuild") {
+		t.Error("the build was repeated with network access, but was never isolated")
+	}
+}
+
+func TestRunBuildStepsRequiresNetwork(t *testing.T) {
+	setBuildStepFlags(t, true)
+	client := newFakeDockerClient(nil)
+	client.exec = func(cmd []string) fakeExecResult {
+		// Fails as long as the sandbox is disconnected
+		if isCommand(cmd, "makepkg --noextract") || isCommand(cmd, "makepkg --force --cleanbuild") {
+			if client.disconnected {
+				return fakeExecResult{exitCode: 1}
+			}
+		}
+		return fakeExecResult{}
+	}
+
+	workResult, err := runFakeBuild(t, client, nil)
+	if err == nil {
+		t.Fatal("runBuildSteps() succeeded, want an error")
+	}
+	if workResult.Status != model.WORK_RESULT_STATUS_REQUIRES_NETWORK || workResult.ErrorCategory != ERROR_CATEGORY_REQUIRES_NETWORK {
+		t.Errorf("work result = %d, %s; want %d, %s", workResult.Status, workResult.ErrorCategory, model.WORK_RESULT_STATUS_REQUIRES_NETWORK, ERROR_CATEGORY_REQUIRES_NETWORK)
+	}
+	if workResult.MakepkgBuildExitCode != 1 || workResult.MakepkgBuildNetworkExitCode != 0 {
+		t.Errorf("exit codes = %d, %d; want 1, 0", workResult.MakepkgBuildExitCode, workResult.MakepkgBuildNetworkExitCode)
+	}
+}
+
+func TestRunBuildStepsOutOfMemory(t *testing.T) {
+	setBuildStepFlags(t, true)
+	client := newFakeDockerClient(func(cmd []string) fakeExecResult {
+		if isCommand(cmd, "makepkg --noextract") {
+			return fakeExecResult{exitCode: 137}
+		}
+		return fakeExecResult{}
+	})
+	client.oomKilled = true
+
+	workResult, err := runFakeBuild(t, client, nil)
+	if err == nil {
+		t.Fatal("runBuildSteps() succeeded, want an error")
+	}
+	if workResult.Status != model.WORK_RESULT_STATUS_OUT_OF_MEMORY || workResult.ErrorCategory != ERROR_CATEGORY_OUT_OF_MEMORY {
+		t.Errorf("work result = %d, %s; want %d, %s", workResult.Status, workResult.ErrorCategory, model.WORK_RESULT_STATUS_OUT_OF_MEMORY, ERROR_CATEGORY_OUT_OF_MEMORY)
+	}
+	if client.ran("makepkg --force --cleanbuild") {
+		t.Error("a build that ran out of memory was repeated with network access")
+	}
+}
+
+func TestRunBuildStepsOutOfMemoryFromCgroup(t *testing.T) {
+	setBuildStepFlags(t, false)
+	client := newFakeDockerClient(func(cmd []string) fakeExecResult {
+		switch {
+		case isCommand(cmd, "makepkg --noextract"):
+			return fakeExecResult{exitCode: 137}
+		case len(cmd) == 3 && strings.Contains(cmd[2], "memory.events"):
+			return fakeExecResult{output: "low 0\nhigh 0\nmax 12\noom 1\noom_kill 1", exitCode: 0}
+		}
+		return fakeExecResult{}
+	})
+
+	workResult, _ := runFakeBuild(t, client, nil)
+	if workResult.Status != model.WORK_RESULT_STATUS_OUT_OF_MEMORY {
+		t.Errorf("Status = %d, want %d", workResult.Status, model.WORK_RESULT_STATUS_OUT_OF_MEMORY)
+	}
+}
+
+func TestRunBuildStepsTimeout(t *testing.T) {
+	setBuildStepFlags(t, true)
+	client := newFakeDockerClient(func(cmd []string) fakeExecResult {
+		if isCommand(cmd, "makepkg --noextract") {
+			return fakeExecResult{hang: true}
+		}
+		return fakeExecResult{}
+	})
+
+	workResult, err := runFakeBuild(t, client, &model.Timeouts{BuildSeconds: 1})
+	if err == nil {
+		t.Fatal("runBuildSteps() succeeded, want an error")
+	}
+	if workResult.Status != model.WORK_RESULT_STATUS_TIMEOUT || workResult.TimedOutStep != STEP_BUILD {
+		t.Errorf("work result = %d, %s; want %d, %s", workResult.Status, workResult.TimedOutStep, model.WORK_RESULT_STATUS_TIMEOUT, STEP_BUILD)
+	}
+	if client.ran("makepkg --force --cleanbuild") {
+		t.Error("a build that timed out was repeated with network access")
+	}
+}
+
+func TestRunBuildStepsDependencyTimeout(t *testing.T) {
+	setBuildStepFlags(t, true)
+	client := newFakeDockerClient(func(cmd []string) fakeExecResult {
+		if len(cmd) > 0 && cmd[0] == "pacman" {
+			return fakeExecResult{hang: true}
+		}
+		return fakeExecResult{}
+	})
+
+	workResult, _ := runFakeBuild(t, client, &model.Timeouts{DependenciesSeconds: 1})
+	if workResult.Status != model.WORK_RESULT_STATUS_TIMEOUT || workResult.TimedOutStep != STEP_DEPENDENCIES {
+		t.Errorf("work result = %d, %s; want %d, %s", workResult.Status, workResult.TimedOutStep, model.WORK_RESULT_STATUS_TIMEOUT, STEP_DEPENDENCIES)
+	}
+	if client.ran("makepkg --nobuild") {
+		t.Error("the sources were downloaded after the dependencies timed out")
+	}
+}
+
+func TestRunBuildStepsReproducibility(t *testing.T) {
+	setBuildStepFlags(t, true)
+	enabled := true
+	filesystem_diff = &enabled
+	client := newFakeDockerClient(nil)
+
+	_, err := runFakeWork(t, client, &model.Work{
+		BuildId:              1,
+		PackageBase:          "foo",
+		CheckReproducibility: true,
+	})
+	if err != nil {
+		t.Fatalf("runBuildSteps() = %s, want no error", err)
+	}
+
+	// Code of the package runs first in makepkg --nobuild, it must not be able to prepare the directory
+	staged, firstPackageCode := client.lastIndexOf("copy to "+REPRODUCIBILITY_BUILD_PATH), client.lastIndexOf("makepkg --nobuild")
+	if staged < 0 || staged > firstPackageCode {
+		t.Errorf("the second build was staged at %d, after code of the package ran at %d", staged, firstPackageCode)
+	}
+	if client.lastIndexOf("copy to") > firstPackageCode {
+		t.Error("a copy into the sandbox happened after code of the package ran")
+	}
+
+	secondBuild, snapshot := client.lastIndexOf("makepkg --holdver"), client.lastIndexOf("find / -xdev")
+	if secondBuild < 0 || snapshot < secondBuild {
+		t.Errorf("the filesystem was compared at %d, before the second build at %d", snapshot, secondBuild)
+	}
+}
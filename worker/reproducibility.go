@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+// The second build happens in another directory, to find packages that depend on their build path
+const REPRODUCIBILITY_BUILD_PATH = "/home/ci/repro"
+const REPRODUCIBILITY_ARTIFACTS_PATH = "/home/ci/repro-pkg"
+
+// Reporting stops after this many differences
+const MAX_REPRODUCIBILITY_DIFFERENCES = 100
+
+// Records the build environment, including the build path, and differs by design
+const BUILDINFO_MTREE_PATH = "./.BUILDINFO"
+
+// Copies the package into the directory of the second build. Happens before any code of the package runs,
+// so the build can't replace the directory with a symlink to a path we would copy to.
+func stageReproducibilityBuild(ctx context.Context, work *model.Work, sandbox BuildSandbox) error {
+	data, err := base64.StdEncoding.DecodeString(work.PackageBaseDataBase64)
+	if err != nil {
+		return err
+	}
+	_, exitCode, err := sandbox.Exec(ctx, ExecOptions{
+		Cmd: []string{
+			"mkdir", "-p", REPRODUCIBILITY_BUILD_PATH, REPRODUCIBILITY_ARTIFACTS_PATH,
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if exitCode > 0 {
+		return fmt.Errorf("Unexpected exit code %d", exitCode)
+	}
+	if err := sandbox.CopyIn(ctx, REPRODUCIBILITY_BUILD_PATH, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	return fixBuildUserPermissions(ctx, sandbox)
+}
+
+// Builds the package a second time in another directory at another time and compares the packages.
+// Packages are compared by their .MTREE, which lists checksums, sizes and attributes of all files.
+// The directory of the second build must have been staged before the first build.
+func checkReproducibility(ctx context.Context, work *model.Work, sandbox BuildSandbox, artifacts []model.Artifact) (*model.ReproducibilityResult, error) {
+	log.Printf("[%s] Building package again to check reproducibility\n", work.PackageBase)
+
+	// Sources are in SRCDEST already, --holdver keeps makepkg from updating VCS sources without network access
+	_, exitCode, err := sandbox.Exec(ctx, ExecOptions{
+		Cmd: []string{
+			"makepkg", "--holdver",
+		},
+		User:       "ci",
+		WorkingDir: REPRODUCIBILITY_BUILD_PATH + "/" + work.PackageBase,
+		Env:        append(getBuildEnv(work), "PKGDEST="+REPRODUCIBILITY_ARTIFACTS_PATH),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode > 0 {
+		return &model.ReproducibilityResult{
+			Differences: []string{fmt.Sprintf("Second build failed with exit code %d", exitCode)},
+		}, nil
+	}
+
+	var differences []string
+	for _, artifact := range artifacts {
+		mtree, err := readMTREE(ctx, sandbox, ARTIFACTS_PATH+"/"+artifact.FileName)
+		if err != nil {
+			return nil, err
+		}
+		secondMTREE, err := readMTREE(ctx, sandbox, REPRODUCIBILITY_ARTIFACTS_PATH+"/"+artifact.FileName)
+		if err != nil {
+			differences = append(differences, fmt.Sprintf("%s: Missing in second build", artifact.FileName))
+			continue
+		}
+		for _, difference := range diffMTREEs(mtree, secondMTREE) {
+			differences = append(differences, artifact.FileName+": "+difference)
+		}
+	}
+
+	if len(differences) > MAX_REPRODUCIBILITY_DIFFERENCES {
+		differences = differences[:MAX_REPRODUCIBILITY_DIFFERENCES]
+	}
+	return &model.ReproducibilityResult{
+		Reproducible: len(differences) == 0,
+		Differences:  differences,
+	}, nil
+}
+
+// Path -> keyword -> value, f.e. "./usr/bin/foo" -> "sha256digest" -> "…"
+type mtree map[string]map[string]string
+
+// Returns the parsed .MTREE of a package. Only entries are parsed, /set defaults are the same for all packages.
+func readMTREE(ctx context.Context, sandbox BuildSandbox, packagePath string) (mtree, error) {
+	output, exitCode, err := sandbox.Exec(ctx, ExecOptions{
+		Cmd: []string{
+			"bash", "-c", `bsdtar -xOf "$1" .MTREE | gzip -dc`, "bash", packagePath,
+		},
+		User: "ci",
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode > 0 {
+		return nil, fmt.Errorf("Failed to read .MTREE of %s: %s", packagePath, output)
+	}
+
+	entries := make(mtree)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "./") {
+			continue
+		}
+		keywords := make(map[string]string)
+		for _, field := range fields[1:] {
+			keyValue := strings.SplitN(field, "=", 2)
+			if len(keyValue) == 2 {
+				keywords[keyValue[0]] = keyValue[1]
+			}
+		}
+		entries[fields[0]] = keywords
+	}
+	return entries, nil
+}
+
+// Returns human readable differences of two .MTREEs, sorted by path
+func diffMTREEs(first mtree, second mtree) []string {
+	var differences []string
+	for path, firstKeywords := range first {
+		if path == BUILDINFO_MTREE_PATH {
+			continue
+		}
+		secondKeywords, ok := second[path]
+		if !ok {
+			differences = append(differences, path+" only exists in the first build")
+			continue
+		}
+		var differentKeywords []string
+		for keyword, value := range firstKeywords {
+			if secondKeywords[keyword] != value {
+				differentKeywords = append(differentKeywords, keyword)
+			}
+		}
+		for keyword := range secondKeywords {
+			if _, ok := firstKeywords[keyword]; !ok {
+				differentKeywords = append(differentKeywords, keyword)
+			}
+		}
+		if len(differentKeywords) > 0 {
+			sort.Strings(differentKeywords)
+			differences = append(differences, path+" differs in "+strings.Join(differentKeywords, ", "))
+		}
+	}
+	for path := range second {
+		if _, ok := first[path]; !ok && path != BUILDINFO_MTREE_PATH {
+			differences = append(differences, path+" only exists in the second build")
+		}
+	}
+
+	sort.Strings(differences)
+	return differences
+}
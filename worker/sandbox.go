@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashworks/aur-ci/worker/model"
+	"golang.org/x/sys/unix"
+)
+
+const SANDBOX_DOCKER = "docker"
+const SANDBOX_PODMAN = "podman"
+const SANDBOX_NSPAWN = "nspawn"
+const SANDBOX_CHROOT = "chroot"
+
+// Prefix of all sandboxes created by the worker, used to remove leftovers
+const SANDBOX_PREFIX = "aur-ci-worker-build"
+
+// systemd-nspawn machine names must be valid hostnames, which are limited to 64 characters
+const SANDBOX_NAME_MAX_LENGTH = 64
+
+// Creates sandboxes for builds, f.e. a container engine
+type SandboxRuntime interface {
+	Name() string
+	// Returns the version of the runtime, f.e. the version of the docker daemon
+	Version(ctx context.Context) (string, error)
+	// Returns a path on the filesystem builds are stored on, used to report available disk space
+	StoragePath(ctx context.Context) string
+	// Updates base images or root filesystems. Called at startup and periodically.
+	Update(ctx context.Context) error
+	// Removes sandboxes left behind by previous runs of the worker
+	RemoveLeftovers(ctx context.Context) error
+	NewSandbox(work *model.Work, limits model.ResourceLimits, mounts []BindMount) BuildSandbox
+	Close() error
+}
+
+// A directory of the host, writable in the sandbox
+type BindMount struct {
+	Source string
+	Target string
+}
+
+type ExecOptions struct {
+	Cmd []string
+	// Defaults to root
+	User       string
+	WorkingDir string
+	// Additional environment variables, f.e. SOURCE_DATE_EPOCH=0
+	Env []string
+}
+
+// An isolated environment a single build runs in
+type BuildSandbox interface {
+	// Creates and starts the sandbox
+	Create(ctx context.Context) error
+	// Extracts a TAR archive into a directory of the sandbox
+	CopyIn(ctx context.Context, dstPath string, tarArchive io.Reader) error
+	// Returns a TAR archive of a path in the sandbox, entries are relative to the parent of the path
+	CopyOut(ctx context.Context, srcPath string) (io.ReadCloser, error)
+	// Runs a command and returns its combined output and exit code.
+	// The line handler is called for every line of output while the command runs, it may be nil.
+	Exec(ctx context.Context, options ExecOptions, lineHandler func(string)) ([]byte, int, error)
+	DisconnectNetwork(ctx context.Context) error
+	ConnectNetwork(ctx context.Context) error
+	// Returns true if the kernel killed a process of the sandbox since it ran out of memory
+	WasOOMKilled(ctx context.Context) bool
+	// Stops and removes the sandbox. Must be safe to call if Create failed.
+	Destroy(ctx context.Context) error
+}
+
+var sandbox_runtime SandboxRuntime
+
+// Returns the name of the sandbox of a build, which is unique by the build ID. The package base is added for humans,
+// characters that neither hostnames nor docker container names allow are replaced, f.e. the pluses of libc++.
+func getSandboxName(work *model.Work) string {
+	packageBase := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, work.PackageBase)
+
+	name := fmt.Sprintf("%s-%d-%s", SANDBOX_PREFIX, work.BuildId, packageBase)
+	if len(name) > SANDBOX_NAME_MAX_LENGTH {
+		name = name[:SANDBOX_NAME_MAX_LENGTH]
+	}
+	return strings.TrimRight(name, "-")
+}
+
+// Extracts a TAR archive into a directory of a sandbox root on the host, used by sandboxes without an API to do so.
+// Code of the package might have replaced parts of the path with symlinks to the host, so neither the destination
+// nor the entries are resolved by path: every component is opened relative to its parent without following symlinks.
+// Entries must not leave the destination.
+func extractTAR(rootDir string, dstPath string, tarArchive io.Reader) error {
+	rootFd, err := unix.Open(rootDir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: rootDir, Err: err}
+	}
+	defer unix.Close(rootFd)
+
+	dstFd, err := openDirNoFollow(rootFd, dstPath, false, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dstFd)
+
+	tarReader := tar.NewReader(tarArchive)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Cleaning an absolute path removes all .. components
+		name := filepath.Clean("/" + header.Name)
+		if name == "/" {
+			if header.Typeflag == tar.TypeDir {
+				continue
+			}
+			return errors.New("TAR entry outside of destination: " + header.Name)
+		}
+		parent, base := filepath.Split(name)
+
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			fd, err := openDirNoFollow(dstFd, name, true, mode)
+			if err != nil {
+				return err
+			}
+			unix.Close(fd)
+		case tar.TypeReg:
+			if err := writeFileNoFollow(dstFd, parent, base, mode, tarReader); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			parentFd, err := openDirNoFollow(dstFd, parent, true, 0755)
+			if err != nil {
+				return err
+			}
+			err = unix.Symlinkat(header.Linkname, parentFd, base)
+			unix.Close(parentFd)
+			if err != nil {
+				return &os.PathError{Op: "symlinkat", Path: name, Err: err}
+			}
+		default:
+			return fmt.Errorf("Unsupported TAR entry type %c of %s", header.Typeflag, header.Name)
+		}
+	}
+}
+
+// Opens a directory below the directory of dirFd component by component, failing on symlinks.
+// Missing directories are created with the mode if requested. The returned file descriptor must be closed.
+func openDirNoFollow(dirFd int, path string, create bool, mode os.FileMode) (int, error) {
+	fd, err := unix.Openat(dirFd, ".", unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "openat", Path: ".", Err: err}
+	}
+
+	for _, component := range strings.Split(filepath.Clean("/"+path), "/") {
+		if len(component) == 0 {
+			continue
+		}
+
+		// Fails with ELOOP if the component is a symlink
+		nextFd, err := unix.Openat(fd, component, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err == unix.ENOENT && create {
+			if err = unix.Mkdirat(fd, component, uint32(mode)); err == nil || err == unix.EEXIST {
+				nextFd, err = unix.Openat(fd, component, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+			}
+		}
+		unix.Close(fd)
+		if err != nil {
+			return -1, &os.PathError{Op: "openat", Path: path, Err: err}
+		}
+		fd = nextFd
+	}
+
+	return fd, nil
+}
+
+// Creates or truncates a regular file below the directory of dirFd. Fails if the file or a parent is a symlink.
+func writeFileNoFollow(dirFd int, parent string, name string, mode os.FileMode, content io.Reader) error {
+	parentFd, err := openDirNoFollow(dirFd, parent, true, 0755)
+	if err != nil {
+		return err
+	}
+	fd, err := unix.Openat(parentFd, name, unix.O_CREAT|unix.O_TRUNC|unix.O_WRONLY|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(mode))
+	unix.Close(parentFd)
+	if err != nil {
+		return &os.PathError{Op: "openat", Path: filepath.Join(parent, name), Err: err}
+	}
+
+	file := os.NewFile(uintptr(fd), filepath.Join(parent, name))
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Returns a TAR archive of a path on the host. Entries are relative to the parent of the path.
+func createTAR(srcPath string) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+
+	go func() {
+		tarWriter := tar.NewWriter(pipeWriter)
+		parent := filepath.Dir(srcPath)
+
+		err := filepath.Walk(srcPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			linkName := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				if linkName, err = os.Readlink(path); err != nil {
+					return err
+				}
+			}
+
+			header, err := tar.FileInfoHeader(info, linkName)
+			if err != nil {
+				return err
+			}
+			if header.Name, err = filepath.Rel(parent, path); err != nil {
+				return err
+			}
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(tarWriter, file)
+			return err
+		})
+		if err == nil {
+			err = tarWriter.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+
+	return pipeReader
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Configuration of devtools used by extra-x86_64-build
+const DEVTOOLS_PACMAN_CONF = "/usr/share/devtools/pacman.conf.d/extra.conf"
+const DEVTOOLS_MAKEPKG_CONF = "/usr/share/devtools/makepkg.conf.d/x86_64.conf"
+
+// Builds in a clean chroot maintained by the worker, the same way Arch Linux maintainers build
+// with devtools: The chroot is created once with mkarchroot and the configuration of devtools,
+// updated periodically and snapshotted for every build. Runs the chroot with systemd-nspawn.
+func newChrootRuntime(chrootPath string, pacmanConf string, makepkgConf string, snapshotMode string) *nspawnRuntime {
+	rootPath := filepath.Join(chrootPath, "root")
+
+	if _, err := os.Stat(rootPath); os.IsNotExist(err) {
+		createChroot(chrootPath, rootPath, pacmanConf, makepkgConf)
+	} else if err != nil {
+		log.Fatal("Failed to access chroot: ", err)
+	}
+
+	return newNspawnRuntimeWithName(SANDBOX_CHROOT, rootPath, filepath.Join(chrootPath, "builds"), snapshotMode)
+}
+
+// Creates the root of the chroot, a btrfs subvolume if the chroot path is on btrfs
+func createChroot(chrootPath string, rootPath string, pacmanConf string, makepkgConf string) {
+	if _, err := exec.LookPath("mkarchroot"); err != nil {
+		log.Fatal("Failed to find mkarchroot, is devtools installed? ", err)
+	}
+	if err := os.MkdirAll(chrootPath, 0755); err != nil {
+		log.Fatal("Failed to create chroot directory: ", err)
+	}
+
+	log.Printf("Creating chroot %s\n", rootPath)
+	cmd := exec.Command("mkarchroot", "-C", pacmanConf, "-M", makepkgConf, rootPath, "base-devel")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// Don't leave a broken chroot behind, we wouldn't create it again
+		os.RemoveAll(rootPath)
+		log.Fatal("Failed to create chroot: ", err)
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/hashworks/aur-ci/worker/model"
+	"github.com/moby/moby/client"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Fully qualified, podman doesn't resolve short names without configuration
+const BUILD_IMAGE = "docker.io/archlinux/archlinux:base-devel"
+
+// The build image with an upgraded system and the build user, created by the worker once a day.
+// Tagged with the date, f.e. localhost/aur-ci-worker-base:2021-04-01
+const BASE_IMAGE = "localhost/aur-ci-worker-base"
+const BASE_IMAGE_TAG_FORMAT = "2006-01-02"
+
+// Volume shared by all builds, so packages are only downloaded once
+const PACMAN_CACHE_VOLUME = "aur-ci-pacman-cache"
+const PACMAN_CACHE_PATH = "/var/cache/pacman/pkg"
+
+// Runs builds in containers of a docker daemon or a docker compatible API, f.e. the one of podman
+type dockerRuntime struct {
+	name   string
+	client dockerClient
+	// Image builds are created from, the base image or the build image as fallback
+	image      string
+	imageMutex sync.Mutex
+}
+
+type dockerSandbox struct {
+	runtime      *dockerRuntime
+	name         string
+	image        string
+	work         *model.Work
+	limits       model.ResourceLimits
+	mounts       []BindMount
+	containerId  string
+	networkNames []string
+}
+
+// Connects to the docker daemon configured in the environment ($DOCKER_HOST)
+func newDockerRuntime() *dockerRuntime {
+	return newDockerCompatibleRuntime(SANDBOX_DOCKER, client.FromEnv)
+}
+
+// Connects to the docker compatible API of podman. Works rootless with the socket of the user.
+func newPodmanRuntime(socket string) *dockerRuntime {
+	return newDockerCompatibleRuntime(SANDBOX_PODMAN, client.WithHost(socket), client.WithAPIVersionNegotiation())
+}
+
+func newDockerCompatibleRuntime(name string, opts ...client.Opt) *dockerRuntime {
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil { // Note: This won't be null if the socket is not available
+		log.Fatalf("Failed to create %s client: %s", name, err)
+	}
+
+	// Test the socket
+	if _, err = cli.Info(context.Background()); err != nil {
+		log.Fatalf("Failed to connect to %s: %s", name, err)
+	}
+
+	runtime := &dockerRuntime{
+		name:   name,
+		client: cli,
+		image:  BUILD_IMAGE,
+	}
+	runtime.useLatestBaseImage(context.Background())
+	return runtime
+}
+
+func (r *dockerRuntime) Name() string {
+	return r.name
+}
+
+func (r *dockerRuntime) Version(ctx context.Context) (string, error) {
+	info, err := r.client.Info(ctx)
+	if err != nil {
+		return "", err
+	}
+	return info.ServerVersion, nil
+}
+
+func (r *dockerRuntime) StoragePath(ctx context.Context) string {
+	info, err := r.client.Info(ctx)
+	if err != nil || len(info.DockerRootDir) == 0 {
+		return "/"
+	}
+	return info.DockerRootDir
+}
+
+func (r *dockerRuntime) getImage() string {
+	r.imageMutex.Lock()
+	defer r.imageMutex.Unlock()
+	return r.image
+}
+
+func (r *dockerRuntime) setImage(image string) {
+	r.imageMutex.Lock()
+	defer r.imageMutex.Unlock()
+	r.image = image
+}
+
+// Pulls the build image and creates the base image of the day, if it doesn't exist yet
+func (r *dockerRuntime) Update(ctx context.Context) error {
+	baseImage := BASE_IMAGE + ":" + time.Now().UTC().Format(BASE_IMAGE_TAG_FORMAT)
+	if _, _, err := r.client.ImageInspectWithRaw(ctx, baseImage); err == nil {
+		r.setImage(baseImage)
+		return nil
+	}
+
+	if err := r.pullBuildImage(ctx); err != nil {
+		return err
+	}
+
+	if err := r.createBaseImage(ctx, baseImage); err != nil {
+		log.Println("Failed to create base image: ", err)
+		return err
+	}
+	r.setImage(baseImage)
+	r.removeOldBaseImages(ctx, baseImage)
+
+	return nil
+}
+
+func (r *dockerRuntime) pullBuildImage(ctx context.Context) error {
+	log.Println("Pulling Arch Linux image")
+	readCloser, err := r.client.ImagePull(ctx, BUILD_IMAGE, types.ImagePullOptions{})
+	if err != nil {
+		log.Println("Failed to pull image: ", err)
+		return err
+	}
+	defer readCloser.Close()
+
+	// The pull happens while we read its progress
+	if _, err := io.Copy(io.Discard, readCloser); err != nil {
+		log.Println("Failed to pull image: ", err)
+		return err
+	}
+	return nil
+}
+
+// Upgrades the system of the build image and adds the build user, so builds only have to
+// install their dependencies
+func (r *dockerRuntime) createBaseImage(ctx context.Context, baseImage string) error {
+	log.Printf("Creating base image %s\n", baseImage)
+
+	sandbox := &dockerSandbox{
+		runtime: r,
+		name:    SANDBOX_PREFIX + "-base",
+		image:   BUILD_IMAGE,
+	}
+	defer func() {
+		if err := sandbox.Destroy(context.Background()); err != nil {
+			log.Println("Failed to remove base image container: ", err)
+		}
+	}()
+
+	if err := sandbox.Create(ctx); err != nil {
+		return err
+	}
+	if err := configureMirror(ctx, sandbox); err != nil {
+		return err
+	}
+
+	output, exitCode, err := sandbox.Exec(ctx, ExecOptions{
+		Cmd: []string{
+			"pacman", "-Syu", "--noconfirm", "--noprogressbar",
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if exitCode > 0 {
+		return fmt.Errorf("System upgrade failed with exit code %d: %s", exitCode, output)
+	}
+
+	if err := createBuildUser(ctx, sandbox); err != nil {
+		return err
+	}
+	if err := fixBuildUserPermissions(ctx, sandbox); err != nil {
+		return err
+	}
+
+	_, err = r.client.ContainerCommit(ctx, sandbox.containerId, types.ContainerCommitOptions{
+		Reference: baseImage,
+		Comment:   "Created by aur-ci-worker",
+	})
+	return err
+}
+
+// Uses the newest base image available, so a restarted worker doesn't need to wait for a new one
+func (r *dockerRuntime) useLatestBaseImage(ctx context.Context) {
+	images, err := r.listBaseImages(ctx)
+	if err != nil {
+		log.Println("Failed to list base images: ", err)
+		return
+	}
+
+	var latest string
+	for _, image := range images {
+		for _, tag := range image.RepoTags {
+			// The date format sorts lexically
+			if strings.HasPrefix(tag, BASE_IMAGE+":") && tag > latest {
+				latest = tag
+			}
+		}
+	}
+	if len(latest) > 0 {
+		r.setImage(latest)
+	}
+}
+
+func (r *dockerRuntime) listBaseImages(ctx context.Context) ([]types.ImageSummary, error) {
+	return r.client.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", BASE_IMAGE)),
+	})
+}
+
+// Removes base images of previous days. Images still used by running builds are removed by a later update.
+func (r *dockerRuntime) removeOldBaseImages(ctx context.Context, baseImage string) {
+	images, err := r.listBaseImages(ctx)
+	if err != nil {
+		log.Println("Failed to list base images: ", err)
+		return
+	}
+
+	for _, image := range images {
+		for _, tag := range image.RepoTags {
+			if tag == baseImage || !strings.HasPrefix(tag, BASE_IMAGE+":") {
+				continue
+			}
+			if _, err := r.client.ImageRemove(ctx, tag, types.ImageRemoveOptions{PruneChildren: true}); err != nil {
+				log.Println("Failed to remove old base image: ", err)
+			}
+		}
+	}
+}
+
+func (r *dockerRuntime) RemoveLeftovers(ctx context.Context) error {
+	log.Println("Removing old containers")
+
+	containers, err := r.client.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		log.Println("Failed to get list of containers: ", err)
+		return err
+	}
+	for _, container := range containers {
+		for _, name := range container.Names {
+			if strings.HasPrefix(name, "/"+SANDBOX_PREFIX) {
+				if err := r.client.ContainerRemove(ctx, container.ID, types.ContainerRemoveOptions{
+					RemoveVolumes: true,
+					Force:         true,
+				}); err != nil {
+					log.Println("Failed to remove old container: ", err)
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (r *dockerRuntime) NewSandbox(work *model.Work, limits model.ResourceLimits, mounts []BindMount) BuildSandbox {
+	return &dockerSandbox{
+		runtime: r,
+		name:    getSandboxName(work),
+		image:   r.getImage(),
+		work:    work,
+		limits:  limits,
+		mounts:  mounts,
+	}
+}
+
+func (r *dockerRuntime) Close() error {
+	return r.client.Close()
+}
+
+func (s *dockerSandbox) Create(ctx context.Context) error {
+	log.Printf("Creating container %s from %s\n", s.name, s.image)
+
+	var platform *v1.Platform
+	if versions.GreaterThanOrEqualTo(s.runtime.client.ClientVersion(), "1.41") {
+		platform = &v1.Platform{
+			Architecture: "amd64",
+			OS:           "linux",
+		}
+	} else {
+		platform = nil
+	}
+
+	// We need a long-running command (read: forever) to execute more than one command (exec)
+	buildContainer, err := s.runtime.client.ContainerCreate(ctx,
+		&container.Config{
+			Image: s.image,
+			Cmd:   []string{"tail", "-f", "/dev/null"},
+		},
+		s.createHostConfig(),
+		&network.NetworkingConfig{},
+		platform,
+		s.name)
+	if err != nil {
+		return err
+	}
+	s.containerId = buildContainer.ID
+
+	log.Printf("Starting container ID %s\n", s.containerId)
+
+	return s.runtime.client.ContainerStart(ctx, s.containerId, types.ContainerStartOptions{})
+}
+
+func (s *dockerSandbox) createHostConfig() *container.HostConfig {
+	hostConfig := container.HostConfig{
+		Mounts: []mount.Mount{
+			{
+				Type:   mount.TypeVolume,
+				Source: PACMAN_CACHE_VOLUME,
+				Target: PACMAN_CACHE_PATH,
+			},
+		},
+	}
+	for _, bindMount := range s.mounts {
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: bindMount.Source,
+			Target: bindMount.Target,
+		})
+	}
+
+	if s.limits.CPUs > 0 {
+		hostConfig.NanoCPUs = int64(s.limits.CPUs * 1e9)
+	}
+	if s.limits.MemoryBytes > 0 {
+		hostConfig.Memory = s.limits.MemoryBytes
+		// Swapping would only hide an exceeded limit and slow down parallel builds
+		hostConfig.MemorySwap = s.limits.MemoryBytes
+	}
+	if s.limits.PidsLimit > 0 {
+		hostConfig.PidsLimit = &s.limits.PidsLimit
+	}
+	if s.limits.DiskBytes > 0 {
+		if *disk_limit_mode == DISK_LIMIT_MODE_TMPFS {
+			// Builds happen in the home directory, docker defaults to noexec
+			hostConfig.Tmpfs = map[string]string{
+				"/home/ci": fmt.Sprintf("size=%d,exec", s.limits.DiskBytes),
+			}
+		} else {
+			// Requires a storage driver with quota support, f.e. overlay2 on xfs with pquota
+			hostConfig.StorageOpt = map[string]string{
+				"size": strconv.FormatInt(s.limits.DiskBytes, 10),
+			}
+		}
+	}
+
+	return &hostConfig
+}
+
+func (s *dockerSandbox) CopyIn(ctx context.Context, dstPath string, tarArchive io.Reader) error {
+	return s.runtime.client.CopyToContainer(ctx, s.containerId, dstPath, tarArchive, types.CopyToContainerOptions{})
+}
+
+func (s *dockerSandbox) CopyOut(ctx context.Context, srcPath string) (io.ReadCloser, error) {
+	readCloser, _, err := s.runtime.client.CopyFromContainer(ctx, s.containerId, srcPath)
+	return readCloser, err
+}
+
+func (s *dockerSandbox) Exec(ctx context.Context, options ExecOptions, lineHandler func(string)) ([]byte, int, error) {
+	cli := s.runtime.client
+
+	idResponse, err := cli.ContainerExecCreate(ctx, s.containerId, types.ExecConfig{
+		Cmd:          options.Cmd,
+		User:         options.User,
+		WorkingDir:   options.WorkingDir,
+		Env:          options.Env,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, idResponse.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer attach.Close()
+
+	var execLog []string
+	scannerDone := make(chan struct{})
+
+	// Without a TTY stdout and stderr are multiplexed into one stream
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pipeWriter, pipeWriter, attach.Reader)
+		pipeWriter.CloseWithError(err)
+	}()
+
+	go func() {
+		defer close(scannerDone)
+		scanner := bufio.NewScanner(pipeReader)
+		scanner.Split(bufio.ScanLines)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if lineHandler != nil {
+				lineHandler(line)
+			}
+			execLog = append(execLog, line)
+		}
+		// Unblock the copy if we stopped early
+		pipeReader.Close()
+	}()
+
+	var inspect types.ContainerExecInspect
+
+	for {
+		inspect, err = cli.ContainerExecInspect(ctx, idResponse.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if !inspect.Running {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	<-scannerDone
+
+	return []byte(strings.Join(execLog, "\n")), inspect.ExitCode, nil
+}
+
+// Disconnects the container from all its networks and remembers them, so they can be reconnected
+func (s *dockerSandbox) DisconnectNetwork(ctx context.Context) error {
+	inspect, err := s.runtime.client.ContainerInspect(ctx, s.containerId)
+	if err != nil {
+		return err
+	}
+
+	if inspect.NetworkSettings != nil {
+		for networkName := range inspect.NetworkSettings.Networks {
+			if err := s.runtime.client.NetworkDisconnect(ctx, networkName, s.containerId, true); err != nil {
+				return err
+			}
+			s.networkNames = append(s.networkNames, networkName)
+		}
+	}
+
+	return nil
+}
+
+func (s *dockerSandbox) ConnectNetwork(ctx context.Context) error {
+	for _, networkName := range s.networkNames {
+		if err := s.runtime.client.NetworkConnect(ctx, networkName, s.containerId, nil); err != nil {
+			return err
+		}
+	}
+	s.networkNames = nil
+	return nil
+}
+
+// Processes of an exec aren't the main process of the container, so the OOMKilled state
+// isn't always set. The memory cgroup of the container knows better.
+func (s *dockerSandbox) WasOOMKilled(ctx context.Context) bool {
+	inspect, err := s.runtime.client.ContainerInspect(ctx, s.containerId)
+	if err == nil && inspect.State != nil && inspect.State.OOMKilled {
+		return true
+	}
+
+	// cgroup v2 and cgroup v1
+	events, exitCode, err := s.Exec(ctx, ExecOptions{
+		Cmd: []string{
+			"bash", "-c", "cat /sys/fs/cgroup/memory.events /sys/fs/cgroup/memory/memory.oom_control 2>/dev/null",
+		},
+	}, nil)
+	if err != nil || exitCode > 1 {
+		return false
+	}
+
+	return hasOOMKillEvent(string(events))
+}
+
+func (s *dockerSandbox) Destroy(ctx context.Context) error {
+	if len(s.containerId) == 0 {
+		return nil
+	}
+	return s.runtime.client.ContainerRemove(ctx, s.containerId, types.ContainerRemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	})
+}
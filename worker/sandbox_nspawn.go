@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+// Exit code of a process killed by SIGKILL, which is what the OOM killer sends
+const SIGKILL_EXIT_CODE = 128 + 9
+
+// How the root filesystem is snapshotted for every build
+const SNAPSHOT_MODE_AUTO = "auto"
+const SNAPSHOT_MODE_COPY = "copy"
+const SNAPSHOT_MODE_BTRFS = "btrfs"
+const SNAPSHOT_MODE_OVERLAYFS = "overlayfs"
+
+// Directory in the builds directory shared by all builds, so packages are only downloaded once
+const NSPAWN_PACMAN_CACHE_DIR = "pacman-cache"
+
+const BTRFS_SUPER_MAGIC = 0x9123683E
+
+// The root directory of a btrfs subvolume always has this inode number
+const BTRFS_SUBVOLUME_INODE = 256
+
+// Runs builds with systemd-nspawn in snapshots of an Arch Linux root filesystem, f.e. created with
+// `mkarchroot root base-devel`. Doesn't need a container daemon, but the worker has to run as root.
+type nspawnRuntime struct {
+	name         string
+	rootPath     string
+	buildsPath   string
+	snapshotMode string
+	// Builds snapshot the root filesystem, which must not happen during an update.
+	// overlayfs snapshots use the root filesystem until they are destroyed.
+	rootMutex sync.RWMutex
+}
+
+type nspawnSandbox struct {
+	runtime *nspawnRuntime
+	work    *model.Work
+	limits  model.ResourceLimits
+	mounts  []BindMount
+	// Directory of all files of the sandbox
+	path string
+	// Root filesystem of the sandbox, path itself or a directory in it
+	root         string
+	created      bool
+	isolated     bool
+	lastExitCode int
+}
+
+func newNspawnRuntime(rootPath string, buildsPath string, snapshotMode string) *nspawnRuntime {
+	return newNspawnRuntimeWithName(SANDBOX_NSPAWN, rootPath, buildsPath, snapshotMode)
+}
+
+func newNspawnRuntimeWithName(name string, rootPath string, buildsPath string, snapshotMode string) *nspawnRuntime {
+	if _, err := exec.LookPath("systemd-nspawn"); err != nil {
+		log.Fatal("Failed to find systemd-nspawn: ", err)
+	}
+	if _, err := os.Stat(filepath.Join(rootPath, "usr/bin/pacman")); err != nil {
+		log.Fatalf("%s doesn't look like an Arch Linux root filesystem: %s", rootPath, err)
+	}
+	if err := os.MkdirAll(buildsPath, 0700); err != nil {
+		log.Fatal("Failed to create builds directory: ", err)
+	}
+	if err := os.MkdirAll(filepath.Join(buildsPath, NSPAWN_PACMAN_CACHE_DIR), 0755); err != nil {
+		log.Fatal("Failed to create pacman cache directory: ", err)
+	}
+
+	switch snapshotMode {
+	case SNAPSHOT_MODE_AUTO:
+		// Like makechrootpkg btrfs subvolumes are snapshotted, but overlayfs is used instead of copying the root otherwise
+		if isBtrfsSubvolume(rootPath) {
+			snapshotMode = SNAPSHOT_MODE_BTRFS
+		} else {
+			snapshotMode = SNAPSHOT_MODE_OVERLAYFS
+		}
+	case SNAPSHOT_MODE_BTRFS:
+		if !isBtrfsSubvolume(rootPath) {
+			log.Fatalf("%s isn't a btrfs subvolume", rootPath)
+		}
+	case SNAPSHOT_MODE_COPY, SNAPSHOT_MODE_OVERLAYFS:
+	default:
+		log.Fatal("Unknown snapshot mode ", snapshotMode)
+	}
+	log.Printf("Snapshotting %s with %s for every build\n", rootPath, snapshotMode)
+
+	return &nspawnRuntime{
+		name:         name,
+		rootPath:     rootPath,
+		buildsPath:   buildsPath,
+		snapshotMode: snapshotMode,
+	}
+}
+
+func isBtrfsSubvolume(path string) bool {
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &statfs); err != nil || statfs.Type != BTRFS_SUPER_MAGIC {
+		return false
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return false
+	}
+	return stat.Ino == BTRFS_SUBVOLUME_INODE
+}
+
+func (r *nspawnRuntime) Name() string {
+	return r.name
+}
+
+func (r *nspawnRuntime) Version(ctx context.Context) (string, error) {
+	output, err := exec.CommandContext(ctx, "systemd-nspawn", "--version").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(string(output), "\n", 2)[0], nil
+}
+
+func (r *nspawnRuntime) StoragePath(ctx context.Context) string {
+	return r.buildsPath
+}
+
+// Updates the root filesystem, builds only need to install their dependencies afterwards
+func (r *nspawnRuntime) Update(ctx context.Context) error {
+	r.rootMutex.Lock()
+	defer r.rootMutex.Unlock()
+
+	log.Println("Updating root filesystem")
+	output, err := exec.CommandContext(ctx, "systemd-nspawn", "--quiet", "--directory="+r.rootPath, r.getPacmanCacheBind(),
+		"pacman", "-Syu", "--noconfirm", "--noprogressbar").CombinedOutput()
+	if err != nil {
+		log.Printf("Failed to update root filesystem: %s\n%s", err, output)
+		return err
+	}
+	return nil
+}
+
+func (r *nspawnRuntime) RemoveLeftovers(ctx context.Context) error {
+	log.Println("Removing old build directories")
+
+	entries, err := ioutil.ReadDir(r.buildsPath)
+	if err != nil {
+		log.Println("Failed to get list of build directories: ", err)
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), SANDBOX_PREFIX) {
+			path := filepath.Join(r.buildsPath, entry.Name())
+			if err := r.removeSnapshot(ctx, path, r.getSnapshotRoot(path)); err != nil {
+				log.Println("Failed to remove old build directory: ", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *nspawnRuntime) NewSandbox(work *model.Work, limits model.ResourceLimits, mounts []BindMount) BuildSandbox {
+	path := filepath.Join(r.buildsPath, getSandboxName(work))
+	return &nspawnSandbox{
+		runtime: r,
+		work:    work,
+		limits:  limits,
+		mounts:  mounts,
+		path:    path,
+		root:    r.getSnapshotRoot(path),
+	}
+}
+
+func (r *nspawnRuntime) getPacmanCacheBind() string {
+	return "--bind=" + filepath.Join(r.buildsPath, NSPAWN_PACMAN_CACHE_DIR) + ":" + PACMAN_CACHE_PATH
+}
+
+func (r *nspawnRuntime) getSnapshotRoot(path string) string {
+	if r.snapshotMode == SNAPSHOT_MODE_OVERLAYFS {
+		return filepath.Join(path, "root")
+	}
+	return path
+}
+
+func (r *nspawnRuntime) createSnapshot(ctx context.Context, path string, root string) error {
+	var cmd *exec.Cmd
+	switch r.snapshotMode {
+	case SNAPSHOT_MODE_BTRFS:
+		cmd = exec.CommandContext(ctx, "btrfs", "subvolume", "snapshot", r.rootPath, path)
+	case SNAPSHOT_MODE_OVERLAYFS:
+		upper := filepath.Join(path, "upper")
+		work := filepath.Join(path, "work")
+		for _, dir := range []string{upper, work, root} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+		cmd = exec.CommandContext(ctx, "mount", "-t", "overlay", "overlay",
+			"-o", fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", r.rootPath, upper, work), root)
+	default:
+		// Cheap on filesystems with reflink support, f.e. xfs
+		cmd = exec.CommandContext(ctx, "cp", "--archive", "--reflink=auto", r.rootPath, path)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return nil
+}
+
+// Removes a snapshot, even if it was only partially created
+func (r *nspawnRuntime) removeSnapshot(ctx context.Context, path string, root string) error {
+	switch r.snapshotMode {
+	case SNAPSHOT_MODE_BTRFS:
+		if isBtrfsSubvolume(path) {
+			output, err := exec.CommandContext(ctx, "btrfs", "subvolume", "delete", path).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, output)
+			}
+		}
+	case SNAPSHOT_MODE_OVERLAYFS:
+		// Never remove files of the root filesystem through a mounted overlay
+		if err := syscall.Unmount(root, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(path)
+}
+
+func (r *nspawnRuntime) Close() error {
+	return nil
+}
+
+func (s *nspawnSandbox) Create(ctx context.Context) error {
+	log.Printf("[%s] Snapshotting root filesystem\n", s.work.PackageBase)
+
+	s.runtime.rootMutex.RLock()
+	s.created = true
+	if s.runtime.snapshotMode != SNAPSHOT_MODE_OVERLAYFS {
+		defer s.runtime.rootMutex.RUnlock()
+	}
+
+	return s.runtime.createSnapshot(ctx, s.path, s.root)
+}
+
+func (s *nspawnSandbox) CopyIn(ctx context.Context, dstPath string, tarArchive io.Reader) error {
+	return extractTAR(s.root, dstPath, tarArchive)
+}
+
+func (s *nspawnSandbox) CopyOut(ctx context.Context, srcPath string) (io.ReadCloser, error) {
+	path := filepath.Join(s.root, filepath.Clean("/"+srcPath))
+	if _, err := os.Lstat(path); err != nil {
+		return nil, err
+	}
+	return createTAR(path), nil
+}
+
+// Every command runs in its own short-lived container on the same directory
+func (s *nspawnSandbox) Exec(ctx context.Context, options ExecOptions, lineHandler func(string)) ([]byte, int, error) {
+	args := []string{
+		"--quiet",
+		"--as-pid2",
+		"--directory=" + s.root,
+		"--machine=" + getSandboxName(s.work),
+		s.runtime.getPacmanCacheBind(),
+	}
+	for _, bindMount := range s.mounts {
+		args = append(args, "--bind="+bindMount.Source+":"+bindMount.Target)
+	}
+	if len(options.User) > 0 {
+		args = append(args, "--user="+options.User)
+	}
+	if len(options.WorkingDir) > 0 {
+		args = append(args, "--chdir="+options.WorkingDir)
+	}
+	for _, env := range options.Env {
+		args = append(args, "--setenv="+env)
+	}
+	if s.isolated {
+		args = append(args, "--private-network")
+	}
+	if s.limits.CPUs > 0 {
+		args = append(args, fmt.Sprintf("--property=CPUQuota=%d%%", int(s.limits.CPUs*100)))
+	}
+	if s.limits.MemoryBytes > 0 {
+		args = append(args, fmt.Sprintf("--property=MemoryMax=%d", s.limits.MemoryBytes), "--property=MemorySwapMax=0")
+	}
+	if s.limits.PidsLimit > 0 {
+		args = append(args, fmt.Sprintf("--property=TasksMax=%d", s.limits.PidsLimit))
+	}
+	// Disk limits have to be enforced with quotas on the builds directory
+	args = append(args, "--")
+	args = append(args, options.Cmd...)
+
+	cmd := exec.CommandContext(ctx, "systemd-nspawn", args...)
+	pipeReader, pipeWriter := io.Pipe()
+	cmd.Stdout = pipeWriter
+	cmd.Stderr = pipeWriter
+
+	if err := cmd.Start(); err != nil {
+		return nil, 0, err
+	}
+
+	var execLog []string
+	scannerDone := make(chan struct{})
+
+	go func() {
+		defer close(scannerDone)
+		scanner := bufio.NewScanner(pipeReader)
+		scanner.Split(bufio.ScanLines)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if lineHandler != nil {
+				lineHandler(line)
+			}
+			execLog = append(execLog, line)
+		}
+		pipeReader.Close()
+	}()
+
+	err := cmd.Wait()
+	pipeWriter.Close()
+	<-scannerDone
+
+	if ctx.Err() != nil {
+		return nil, 0, ctx.Err()
+	}
+
+	exitCode := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, 0, err
+		}
+		exitCode = exitErr.ExitCode()
+	}
+	s.lastExitCode = exitCode
+
+	return []byte(strings.Join(execLog, "\n")), exitCode, nil
+}
+
+func (s *nspawnSandbox) DisconnectNetwork(ctx context.Context) error {
+	s.isolated = true
+	return nil
+}
+
+func (s *nspawnSandbox) ConnectNetwork(ctx context.Context) error {
+	s.isolated = false
+	return nil
+}
+
+// The scope of the last command is gone already, so we can only guess from its exit code
+func (s *nspawnSandbox) WasOOMKilled(ctx context.Context) bool {
+	return s.limits.MemoryBytes > 0 && s.lastExitCode == SIGKILL_EXIT_CODE
+}
+
+func (s *nspawnSandbox) Destroy(ctx context.Context) error {
+	if !s.created {
+		return nil
+	}
+	s.created = false
+
+	if s.runtime.snapshotMode == SNAPSHOT_MODE_OVERLAYFS {
+		defer s.runtime.rootMutex.RUnlock()
+	}
+	return s.runtime.removeSnapshot(ctx, s.path, s.root)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	content  string
+	linkname string
+}
+
+func createTestTAR(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	var tarBuffer bytes.Buffer
+	tarWriter := tar.NewWriter(&tarBuffer)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Mode:     0644,
+			Size:     int64(len(entry.content)),
+			Linkname: entry.linkname,
+		}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &tarBuffer
+}
+
+// A sandbox root with /home/ci, and a directory outside of it the package tries to write to
+func createTestRoot(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	host := filepath.Join(dir, "host")
+	for _, path := range []string{filepath.Join(root, "home", "ci"), host} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, host
+}
+
+func TestExtractTAR(t *testing.T) {
+	root, _ := createTestRoot(t)
+
+	err := extractTAR(root, "/home/ci", createTestTAR(t, []tarEntry{
+		{name: "foo/", typeflag: tar.TypeDir},
+		{name: "foo/PKGBUILD", typeflag: tar.TypeReg, content: "pkgname=foo"},
+		{name: "foo/src/../.SRCINFO", typeflag: tar.TypeReg, content: "pkgbase = foo"},
+		{name: "foo/link", typeflag: tar.TypeSymlink, linkname: "PKGBUILD"},
+		{name: "bar/baz", typeflag: tar.TypeReg, content: "baz"},
+	}))
+	if err != nil {
+		t.Fatalf("extractTAR() = %s", err)
+	}
+
+	for path, content := range map[string]string{
+		"home/ci/foo/PKGBUILD": "pkgname=foo",
+		"home/ci/foo/.SRCINFO": "pkgbase = foo",
+		"home/ci/foo/link":     "pkgname=foo",
+		"home/ci/bar/baz":      "baz",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			t.Errorf("Failed to read %s: %s", path, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", path, data, content)
+		}
+	}
+}
+
+func TestExtractTARDoesNotFollowSymlinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		dstPath string
+		entries []tarEntry
+	}{
+		{"symlinked destination", "/home/ci/repro", []tarEntry{
+			{name: "foo/PKGBUILD", typeflag: tar.TypeReg, content: "escaped"},
+		}},
+		{"symlinked directory", "/home/ci", []tarEntry{
+			{name: "repro/PKGBUILD", typeflag: tar.TypeReg, content: "escaped"},
+		}},
+		{"symlinked directory of a symlink entry", "/home/ci", []tarEntry{
+			{name: "repro/PKGBUILD", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+		}},
+		{"symlinked directory entry", "/home/ci", []tarEntry{
+			{name: "repro/", typeflag: tar.TypeDir},
+		}},
+		{"symlink in the archive", "/home/ci", []tarEntry{
+			{name: "escape", typeflag: tar.TypeSymlink, linkname: "../../.."},
+			{name: "escape/host/PKGBUILD", typeflag: tar.TypeReg, content: "escaped"},
+		}},
+		{"symlinked file", "/home/ci", []tarEntry{
+			{name: "PKGBUILD", typeflag: tar.TypeReg, content: "escaped"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root, host := createTestRoot(t)
+			hostFile := filepath.Join(host, "PKGBUILD")
+			if err := ioutil.WriteFile(hostFile, []byte("untouched"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			// Planted by the package in an earlier build
+			if err := os.Symlink(host, filepath.Join(root, "home", "ci", "repro")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(hostFile, filepath.Join(root, "home", "ci", "PKGBUILD")); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := extractTAR(root, test.dstPath, createTestTAR(t, test.entries)); err == nil {
+				t.Error("extractTAR() succeeded, want an error")
+			}
+
+			data, err := ioutil.ReadFile(hostFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "untouched" {
+				t.Errorf("the host file was changed to %q", data)
+			}
+		})
+	}
+}
+
+func TestGetSandboxName(t *testing.T) {
+	tests := []struct {
+		buildId     int64
+		packageBase string
+		expected    string
+	}{
+		{42, "foo", "aur-ci-worker-build-42-foo"},
+		{42, "libc++", "aur-ci-worker-build-42-libc"},
+		{42, "foo@bar_baz.qux", "aur-ci-worker-build-42-foo-bar-baz-qux"},
+		{42, "Foo", "aur-ci-worker-build-42-foo"},
+		{42, strings.Repeat("a", 100), "aur-ci-worker-build-42-" + strings.Repeat("a", 41)},
+		{42, strings.Repeat("a", 40) + "+b", "aur-ci-worker-build-42-" + strings.Repeat("a", 40)},
+	}
+
+	for _, test := range tests {
+		name := getSandboxName(&model.Work{BuildId: test.buildId, PackageBase: test.packageBase})
+		if name != test.expected {
+			t.Errorf("getSandboxName(%d, %s) = %q, want %q", test.buildId, test.packageBase, name, test.expected)
+		}
+		if len(name) > SANDBOX_NAME_MAX_LENGTH {
+			t.Errorf("getSandboxName(%d, %s) has %d characters, want at most %d", test.buildId, test.packageBase, len(name), SANDBOX_NAME_MAX_LENGTH)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+// How long the controller may hold a work request open if no work is available
+const REQUEST_WORK_WAIT = 30 * time.Second
+
+// Additional time a work request may take on top of REQUEST_WORK_WAIT
+const REQUEST_WORK_TIMEOUT = 30 * time.Second
+
+const MIN_BACKOFF = time.Second
+const MAX_BACKOFF = time.Minute
+
+var runningBuildsMutex sync.Mutex
+var runningBuilds = make(map[int64]context.CancelFunc)
+
+// Runs builds in a fixed amount of slots. As soon as any slot frees up new work is requested,
+// so a slow build never blocks the remaining slots.
+func runScheduler(slotCount int) {
+	slots := make(chan struct{}, slotCount)
+	backoff := MIN_BACKOFF
+
+	for {
+		// Block until at least one slot is free
+		slots <- struct{}{}
+		freeSlots := slotCount - len(slots) + 1
+
+		availableWorkload, err := requestWork(freeSlots, REQUEST_WORK_WAIT)
+		if err != nil {
+			<-slots
+			log.Printf("Failed to request work, retrying in %s: %s\n", backoff, err)
+			backoff = sleepAndIncreaseBackoff(backoff)
+			continue
+		}
+
+		if len(availableWorkload) == 0 {
+			<-slots
+			backoff = sleepAndIncreaseBackoff(backoff)
+			continue
+		}
+
+		backoff = MIN_BACKOFF
+
+		for i := range availableWorkload {
+			// We reserved one slot already, reserve the others as well.
+			// The controller never returns more work than requested, so this never blocks.
+			if i > 0 {
+				slots <- struct{}{}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			addRunningBuild(availableWorkload[i].BuildId, cancel)
+			go func(work *model.Work) {
+				defer func() { <-slots }()
+				defer removeRunningBuild(work.BuildId)
+				handleWork(ctx, work)
+			}(&availableWorkload[i])
+		}
+	}
+}
+
+func sleepAndIncreaseBackoff(backoff time.Duration) time.Duration {
+	time.Sleep(backoff)
+	backoff *= 2
+	if backoff > MAX_BACKOFF {
+		backoff = MAX_BACKOFF
+	}
+	return backoff
+}
+
+func addRunningBuild(buildId int64, cancel context.CancelFunc) {
+	runningBuildsMutex.Lock()
+	defer runningBuildsMutex.Unlock()
+	runningBuilds[buildId] = cancel
+}
+
+func removeRunningBuild(buildId int64) {
+	runningBuildsMutex.Lock()
+	defer runningBuildsMutex.Unlock()
+	if cancel, ok := runningBuilds[buildId]; ok {
+		cancel()
+		delete(runningBuilds, buildId)
+	}
+}
+
+// Aborts a running build, f.e. because the controller superseded it. Returns false if it isn't running.
+func cancelRunningBuild(buildId int64) bool {
+	runningBuildsMutex.Lock()
+	defer runningBuildsMutex.Unlock()
+	cancel, ok := runningBuilds[buildId]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func getRunningBuildIds() []int64 {
+	runningBuildsMutex.Lock()
+	defer runningBuildsMutex.Unlock()
+
+	buildIds := make([]int64, 0, len(runningBuilds))
+	for buildId := range runningBuilds {
+		buildIds = append(buildIds, buildId)
+	}
+	sort.Slice(buildIds, func(i, j int) bool { return buildIds[i] < buildIds[j] })
+	return buildIds
+}
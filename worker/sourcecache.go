@@ -0,0 +1,231 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+// SRCDEST of the embedded makepkg.conf
+const SOURCE_CACHE_TARGET = "/home/ci/src"
+
+// .SRCINFO keys that change if the sources of a package change
+var SOURCE_CACHE_SRCINFO_KEYS = []string{"source", "md5sums", "sha1sums", "sha224sums", "sha256sums", "sha384sums", "sha512sums", "b2sums"}
+
+// Persistent cache of downloaded sources, mounted as SRCDEST into sandboxes.
+// Every entry is a directory, keyed by the package base and a hash of its sources and checksums.
+// Entries are evicted least recently used first once the cache exceeds its size.
+type sourceCache struct {
+	path     string
+	maxBytes int64
+	mutex    sync.Mutex
+	// Entries used by running builds, they must not be shared or evicted
+	inUse map[string]struct{}
+}
+
+type sourceCacheEntry struct {
+	cache *sourceCache
+	key   string
+	path  string
+	// Set if the entry might contain broken sources, f.e. after a failed download
+	discard bool
+}
+
+// nil if disabled
+var source_cache *sourceCache
+
+func newSourceCache(path string, maxBytes int64) *sourceCache {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		log.Fatal("Failed to create source cache directory: ", err)
+	}
+	return &sourceCache{
+		path:     path,
+		maxBytes: maxBytes,
+		inUse:    make(map[string]struct{}),
+	}
+}
+
+// Returns the cache key of a package: its package base and a hash of the source and checksum lines
+// of its .SRCINFO. VCS sources are skipped by makepkg checks, so their clones are shared between commits.
+func getSourceCacheKey(work *model.Work) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(work.PackageBaseDataBase64)
+	if err != nil {
+		return "", err
+	}
+
+	srcinfo, err := readFileFromTAR(bytes.NewReader(data), work.PackageBase+"/.SRCINFO")
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(srcinfo))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key := strings.TrimSpace(strings.SplitN(line, "=", 2)[0])
+		for _, sourceKey := range SOURCE_CACHE_SRCINFO_KEYS {
+			// Also matches architecture specific keys, f.e. source_x86_64
+			if key == sourceKey || strings.HasPrefix(key, sourceKey+"_") {
+				lines = append(lines, line)
+				break
+			}
+		}
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return work.PackageBase + "-" + hex.EncodeToString(hash[:8]), nil
+}
+
+// Returns the content of a file in a TAR archive
+func readFileFromTAR(tarArchive io.Reader, name string) ([]byte, error) {
+	tarReader := tar.NewReader(tarArchive)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, os.ErrNotExist
+			}
+			return nil, err
+		}
+		if filepath.Clean(header.Name) == name {
+			return ioutil.ReadAll(tarReader)
+		}
+	}
+}
+
+// Returns the cache entry of a build, nil if the cache is disabled or another build uses the entry already
+func acquireSourceCacheEntry(work *model.Work) *sourceCacheEntry {
+	if source_cache == nil {
+		return nil
+	}
+
+	key, err := getSourceCacheKey(work)
+	if err != nil {
+		log.Printf("[%s] Not using source cache, failed to get cache key: %s\n", work.PackageBase, err)
+		return nil
+	}
+
+	entry := source_cache.acquire(key)
+	if entry == nil {
+		log.Printf("[%s] Not using source cache, entry %s is in use\n", work.PackageBase, key)
+	}
+	return entry
+}
+
+// Returns the entry of a key, nil if another build uses it already
+func (c *sourceCache) acquire(key string) *sourceCacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.inUse[key]; ok {
+		return nil
+	}
+
+	path := filepath.Join(c.path, key)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		log.Println("Failed to create source cache entry: ", err)
+		return nil
+	}
+	// The modification time marks the last use
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	c.inUse[key] = struct{}{}
+	return &sourceCacheEntry{
+		cache: c,
+		key:   key,
+		path:  path,
+	}
+}
+
+func (e *sourceCacheEntry) release() {
+	c := e.cache
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e.discard {
+		if err := os.RemoveAll(e.path); err != nil {
+			log.Println("Failed to remove source cache entry: ", err)
+		}
+	}
+	delete(c.inUse, e.key)
+
+	c.evict()
+}
+
+type sourceCacheEntryInfo struct {
+	key     string
+	size    int64
+	lastUse time.Time
+}
+
+// Removes the least recently used entries until the cache fits into its size. Expects the mutex to be locked.
+func (c *sourceCache) evict() {
+	if c.maxBytes <= 0 {
+		return
+	}
+
+	fileInfos, err := ioutil.ReadDir(c.path)
+	if err != nil {
+		log.Println("Failed to read source cache directory: ", err)
+		return
+	}
+
+	var entries []sourceCacheEntryInfo
+	var totalBytes int64
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() {
+			continue
+		}
+		size := getDirectorySize(filepath.Join(c.path, fileInfo.Name()))
+		totalBytes += size
+		entries = append(entries, sourceCacheEntryInfo{
+			key:     fileInfo.Name(),
+			size:    size,
+			lastUse: fileInfo.ModTime(),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUse.Before(entries[j].lastUse)
+	})
+
+	for _, entry := range entries {
+		if totalBytes <= c.maxBytes {
+			return
+		}
+		if _, ok := c.inUse[entry.key]; ok {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.path, entry.key)); err != nil {
+			log.Println("Failed to evict source cache entry: ", err)
+			continue
+		}
+		totalBytes -= entry.size
+	}
+}
+
+func getDirectorySize(path string) int64 {
+	var size int64
+	_ = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
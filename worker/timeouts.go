@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashworks/aur-ci/worker/model"
+)
+
+// Used if the controller didn't send any timeouts
+const DEFAULT_TOTAL_TIMEOUT = 30 * time.Minute
+
+const (
+	STEP_TOTAL        = "total"
+	STEP_DEPENDENCIES = "dependencies"
+	STEP_DOWNLOAD     = "download"
+	STEP_BUILD        = "build"
+	STEP_CHECK        = "check"
+)
+
+// makepkg runs build() and check() in one invocation, this tells us that check() started
+const MAKEPKG_CHECK_MARKER = "==> Starting check()..."
+
+func getTotalTimeout(work *model.Work) time.Duration {
+	if work.Timeouts == nil || work.Timeouts.TotalSeconds <= 0 {
+		return DEFAULT_TOTAL_TIMEOUT
+	}
+	return time.Duration(work.Timeouts.TotalSeconds) * time.Second
+}
+
+func getStepTimeouts(work *model.Work) model.Timeouts {
+	if work.Timeouts == nil {
+		return model.Timeouts{}
+	}
+	return *work.Timeouts
+}
+
+// Cancels the context of a step once the current step exceeds its timeout.
+// The step can be switched while running, f.e. when makepkg proceeds from build() to check().
+type stepWatchdog struct {
+	mutex        sync.Mutex
+	cancel       context.CancelFunc
+	timer        *time.Timer
+	timedOutStep string
+}
+
+// Starts a watchdog for a step, a timeout of zero seconds means the step is only limited by the parent context
+func newStepWatchdog(ctx context.Context, step string, timeoutSeconds int) (*stepWatchdog, context.Context) {
+	stepCtx, cancel := context.WithCancel(ctx)
+	watchdog := &stepWatchdog{
+		cancel: cancel,
+	}
+	watchdog.SwitchStep(step, timeoutSeconds)
+	return watchdog, stepCtx
+}
+
+func (w *stepWatchdog) SwitchStep(step string, timeoutSeconds int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if timeoutSeconds > 0 {
+		w.timer = time.AfterFunc(time.Duration(timeoutSeconds)*time.Second, func() {
+			w.mutex.Lock()
+			w.timedOutStep = step
+			w.mutex.Unlock()
+			w.cancel()
+		})
+	}
+}
+
+// Returns the step that exceeded its timeout or an empty string
+func (w *stepWatchdog) TimedOutStep() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.timedOutStep
+}
+
+func (w *stepWatchdog) Stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.cancel()
+}
+
+// Returns a line handler that switches the watchdog to the check step once makepkg starts check()
+func (w *stepWatchdog) makepkgLineHandler(checkTimeoutSeconds int) func(string) {
+	return func(line string) {
+		if strings.Contains(line, MAKEPKG_CHECK_MARKER) {
+			w.SwitchStep(STEP_CHECK, checkTimeoutSeconds)
+		}
+	}
+}